@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"goim/libs/define"
+	"net"
+	"net/http"
+	"strconv"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	adminChannelLimit    = 100
+	adminChannelLimitMax = 10000
+	adminKeyHeader       = "X-Api-Key"
+)
+
+// ChannelInfo is the admin view of a channel.
+type ChannelInfo struct {
+	Key       string            `json:"key"`
+	RoomId    string            `json:"room_id"`
+	Proto     string            `json:"proto"`
+	Addr      string            `json:"addr"`
+	Connected string            `json:"connected"`
+	Heartbeat string            `json:"heartbeat"` // heartbeat deadline
+	Queue     int               `json:"queue"`     // pending pushes
+	QueueSize int               `json:"queue_size"`
+	Secure    bool              `json:"secure"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// NewChannelInfo get the admin view of the channel in the bucket, the room
+// is read under the bucket lock, the heartbeat deadline is kept by the
+// channel for the pooled timer data may be reused by another connection.
+func NewChannelInfo(b *Bucket, ch *Channel) (i *ChannelInfo) {
+	i = &ChannelInfo{Key: ch.Key, RoomId: define.NoRoom, Proto: ch.Proto, Secure: ch.Secure != nil, Attrs: ch.Attrs}
+	b.cLock.RLock()
+	if room := ch.Room; room != nil {
+		i.RoomId = room.Id
+	}
+	b.cLock.RUnlock()
+	if ch.Conn != nil {
+		i.Addr = ch.Conn.RemoteAddr().String()
+	}
+	if !ch.Connected.IsZero() {
+		i.Connected = ch.Connected.Format("2006-01-02 15:04:05")
+	}
+	if d := ch.Deadline(); !d.IsZero() {
+		i.Heartbeat = d.Format("2006-01-02 15:04:05")
+	}
+	i.Queue, i.QueueSize = ch.Queue()
+	return
+}
+
+// RoomsInfo is the admin view of the rooms in a bucket.
+type RoomsInfo struct {
+	Bucket   int            `json:"bucket"`
+	Channels int            `json:"channels"`
+	Rooms    map[string]int `json:"rooms"` // room id to online number
+}
+
+// InitAdmin register the admin api on the monitor mux, the requests must
+// carry the key if not empty, or come from the loopback.
+func InitAdmin(mux *http.ServeMux, key string) {
+	mux.HandleFunc("/admin/channels", adminAuth(key, adminChannels))
+	mux.HandleFunc("/admin/channel", adminAuth(key, adminChannel))
+	mux.HandleFunc("/admin/rooms", adminAuth(key, adminRooms))
+}
+
+// adminAuth check the admin key in the X-Api-Key header, only the loopback
+// clients are allowed if no key.
+func adminAuth(key string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), []byte(key)) != 1 {
+				log.Warn("admin %s %s unauthorized from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if !loopback(r.RemoteAddr) {
+			log.Warn("admin %s %s forbidden from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// loopback report whether the remote address is a loopback address.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func adminWrite(w http.ResponseWriter, res map[string]interface{}) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error("json.Marshal(%v) error(%v)", res, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(b)
+}
+
+// adminChannels list the channels by sub key prefix.
+// GET /admin/channels?prefix=&limit=
+func adminChannels(w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		limit  = adminChannelLimit
+		prefix = r.FormValue("prefix")
+		infos  = []*ChannelInfo{}
+		bucket *Bucket
+		ch     *Channel
+	)
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s := r.FormValue("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > adminChannelLimitMax {
+			limit = adminChannelLimitMax
+		}
+	}
+	for _, bucket = range DefaultServer.Buckets {
+		for _, ch = range bucket.Channels(prefix, limit-len(infos)) {
+			infos = append(infos, NewChannelInfo(bucket, ch))
+		}
+		if len(infos) >= limit {
+			break
+		}
+	}
+	adminWrite(w, map[string]interface{}{"ret": OK, "data": infos})
+}
+
+// adminChannel show or close a channel.
+// GET /admin/channel?key= show the channel.
+// DELETE /admin/channel?key= close the channel.
+func adminChannel(w http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		key = r.FormValue("key")
+		b   *Bucket
+		ch  *Channel
+	)
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	b = DefaultServer.Bucket(key)
+	if ch = b.Channel(key); ch == nil {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		adminWrite(w, map[string]interface{}{"ret": OK, "data": NewChannelInfo(b, ch)})
+	case "DELETE":
+		if err = ch.Kick(); err != nil {
+			log.Error("key: %s admin close error(%v)", key, err)
+		}
+		log.Info("key: %s closed by admin %s", key, r.RemoteAddr)
+		adminWrite(w, map[string]interface{}{"ret": OK})
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminRooms list the rooms with online number per bucket.
+// GET /admin/rooms
+func adminRooms(w http.ResponseWriter, r *http.Request) {
+	var (
+		i      int
+		bucket *Bucket
+		infos  = make([]*RoomsInfo, 0, len(DefaultServer.Buckets))
+	)
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for i, bucket = range DefaultServer.Buckets {
+		infos = append(infos, &RoomsInfo{Bucket: i, Channels: bucket.ChannelCount(), Rooms: bucket.RoomsOnline()})
+	}
+	adminWrite(w, map[string]interface{}{"ret": OK, "data": infos})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, c := range []struct {
+		key, header, addr string
+		code              int
+	}{
+		{"", "", "127.0.0.1:1234", http.StatusOK},
+		{"", "", "[::1]:1234", http.StatusOK},
+		{"", "", "10.0.0.1:1234", http.StatusForbidden},
+		{"k", "k", "10.0.0.1:1234", http.StatusOK},
+		{"k", "x", "127.0.0.1:1234", http.StatusUnauthorized},
+		{"k", "", "10.0.0.1:1234", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("DELETE", "/admin/channel?key=1", nil)
+		r.RemoteAddr = c.addr
+		if c.header != "" {
+			r.Header.Set(adminKeyHeader, c.header)
+		}
+		w := httptest.NewRecorder()
+		adminAuth(c.key, ok)(w, r)
+		if w.Code != c.code {
+			t.Errorf("adminAuth(%q) header %q from %s got %d, want %d", c.key, c.header, c.addr, w.Code, c.code)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"goim/libs/define"
+	"goim/libs/filter"
 	"goim/libs/proto"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -12,6 +14,7 @@ type BucketOptions struct {
 	RoomSize      int
 	RoutineAmount uint64
 	RoutineSize   int
+	TopicMax      int // max topics of a channel
 }
 
 // Bucket is a channel holder.
@@ -20,9 +23,11 @@ type Bucket struct {
 	chs      map[string]*Channel // map sub key to a channel
 	boptions BucketOptions
 	// room
-	rooms       map[int32]*Room // bucket room channels
+	rooms       map[string]*Room // bucket room channels
 	routines    []chan *proto.BoardcastRoomArg
 	routinesNum uint64
+	// topic
+	topics map[string]map[*Channel]struct{} // topic subscribed channels
 }
 
 // NewBucket new a bucket struct. store the key with im channel.
@@ -32,7 +37,8 @@ func NewBucket(boptions BucketOptions) (b *Bucket) {
 	b.boptions = boptions
 
 	//room
-	b.rooms = make(map[int32]*Room, boptions.RoomSize)
+	b.rooms = make(map[string]*Room, boptions.RoomSize)
+	b.topics = make(map[string]map[*Channel]struct{})
 	b.routines = make([]chan *proto.BoardcastRoomArg, boptions.RoutineAmount)
 	for i := uint64(0); i < boptions.RoutineAmount; i++ {
 		c := make(chan *proto.BoardcastRoomArg, boptions.RoutineSize)
@@ -53,7 +59,7 @@ func (b *Bucket) RoomCount() int {
 }
 
 // Put put a channel according with sub key.
-func (b *Bucket) Put(key string, rid int32, ch *Channel) (err error) {
+func (b *Bucket) Put(key string, rid string, ch *Channel) (err error) {
 	var (
 		room *Room
 		ok   bool
@@ -85,6 +91,9 @@ func (b *Bucket) Del(key string) {
 	if ch, ok = b.chs[key]; ok {
 		room = ch.Room
 		delete(b.chs, key)
+		for topic := range ch.Topics {
+			b.unsub(ch, topic)
+		}
 	}
 	b.cLock.Unlock()
 	if room != nil && room.Del(ch) {
@@ -101,11 +110,15 @@ func (b *Bucket) Channel(key string) (ch *Channel) {
 	return
 }
 
-// Broadcast push msgs to all channels in the bucket.
-func (b *Bucket) Broadcast(p *proto.Proto) {
+// Broadcast push msgs to all channels in the bucket, only the channels
+// whose attributes match the filter if not nil.
+func (b *Bucket) Broadcast(p *proto.Proto, f *filter.Expr) {
 	var ch *Channel
 	b.cLock.RLock()
 	for _, ch = range b.chs {
+		if !f.Match(ch.Attrs) {
+			continue
+		}
 		// ignore error
 		ch.Push(p)
 	}
@@ -113,7 +126,7 @@ func (b *Bucket) Broadcast(p *proto.Proto) {
 }
 
 // Room get a room by roomid.
-func (b *Bucket) Room(rid int32) (room *Room) {
+func (b *Bucket) Room(rid string) (room *Room) {
 	b.cLock.RLock()
 	room, _ = b.rooms[rid]
 	b.cLock.RUnlock()
@@ -129,19 +142,29 @@ func (b *Bucket) DelRoom(room *Room) {
 	return
 }
 
-// BroadcastRoom broadcast a message to specified room
-func (b *Bucket) BroadcastRoom(arg *proto.BoardcastRoomArg) {
+// BroadcastRoom broadcast a message to specified room, false if the room
+// routine is full and not block.
+func (b *Bucket) BroadcastRoom(arg *proto.BoardcastRoomArg, block bool) bool {
 	num := atomic.AddUint64(&b.routinesNum, 1) % b.boptions.RoutineAmount
-	b.routines[num] <- arg
+	if block {
+		b.routines[num] <- arg
+		return true
+	}
+	select {
+	case b.routines[num] <- arg:
+		return true
+	default:
+		return false
+	}
 }
 
 // Rooms get all room id where online number > 0.
-func (b *Bucket) Rooms() (res map[int32]struct{}) {
+func (b *Bucket) Rooms() (res map[string]struct{}) {
 	var (
-		roomId int32
+		roomId string
 		room   *Room
 	)
-	res = make(map[int32]struct{})
+	res = make(map[string]struct{})
 	b.cLock.RLock()
 	for roomId, room = range b.rooms {
 		if room.Online > 0 {
@@ -152,6 +175,40 @@ func (b *Bucket) Rooms() (res map[int32]struct{}) {
 	return
 }
 
+// Channels get at most limit channels whose sub key has the prefix.
+func (b *Bucket) Channels(prefix string, limit int) (chs []*Channel) {
+	var (
+		key string
+		ch  *Channel
+	)
+	b.cLock.RLock()
+	for key, ch = range b.chs {
+		if len(chs) >= limit {
+			break
+		}
+		if strings.HasPrefix(key, prefix) {
+			chs = append(chs, ch)
+		}
+	}
+	b.cLock.RUnlock()
+	return
+}
+
+// RoomsOnline get the online number of all rooms.
+func (b *Bucket) RoomsOnline() (res map[string]int) {
+	var (
+		roomId string
+		room   *Room
+	)
+	b.cLock.RLock()
+	res = make(map[string]int, len(b.rooms))
+	for roomId, room = range b.rooms {
+		res[roomId] = room.Online
+	}
+	b.cLock.RUnlock()
+	return
+}
+
 // roomproc
 func (b *Bucket) roomproc(c chan *proto.BoardcastRoomArg) {
 	for {
@@ -163,5 +220,6 @@ func (b *Bucket) roomproc(c chan *proto.BoardcastRoomArg) {
 		if room = b.Room(arg.RoomId); room != nil {
 			room.Push(&arg.P)
 		}
+		arg.P.Frame().Unref()
 	}
 }
@@ -1,8 +1,271 @@
 package main
 
 import (
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"goim/libs/filter"
+	"goim/libs/proto"
+	"strconv"
 	"testing"
 )
 
 func TestBucket(t *testing.T) {
 }
+
+func TestBucketChannels(t *testing.T) {
+	b := NewBucket(BucketOptions{ChannelSize: 10, RoomSize: 10, RoutineAmount: 1, RoutineSize: 1})
+	for _, key := range []string{"a_1", "a_2", "b_1"} {
+		ch := NewChannel(1, 1)
+		ch.Key = key
+		if err := b.Put(key, "1", ch); err != nil {
+			t.Fatalf("b.Put(%s) error(%v)", key, err)
+		}
+	}
+	b.Put("c_1", define.NoRoom, NewChannel(1, 1))
+	if chs := b.Channels("a_", 10); len(chs) != 2 {
+		t.Errorf("b.Channels(a_) got %d, want 2", len(chs))
+	}
+	if chs := b.Channels("", 2); len(chs) != 2 {
+		t.Errorf("b.Channels limit 2 got %d", len(chs))
+	}
+	if rooms := b.RoomsOnline(); len(rooms) != 1 || rooms["1"] != 3 {
+		t.Errorf("b.RoomsOnline() got %v, want map[1:3]", rooms)
+	}
+	b.Del("b_1")
+	if rooms := b.RoomsOnline(); rooms["1"] != 2 {
+		t.Errorf("b.RoomsOnline() got %v, want map[1:2]", rooms)
+	}
+	info := NewChannelInfo(b, b.Channel("a_1"))
+	if info.Key != "a_1" || info.RoomId != "1" || info.QueueSize != 1 {
+		t.Errorf("NewChannelInfo() got %+v", info)
+	}
+}
+
+func TestBucketTopics(t *testing.T) {
+	b := NewBucket(BucketOptions{ChannelSize: 10, RoomSize: 10, RoutineAmount: 1, RoutineSize: 1, TopicMax: 2})
+	ch := NewChannel(1, 1)
+	b.Put("a_1", define.NoRoom, ch)
+	if err := b.Sub(ch, []string{"t1", "t2", "t3"}); err != ErrTopicMax {
+		t.Errorf("b.Sub() error(%v), want ErrTopicMax", err)
+	}
+	if topics := b.ChannelTopics(ch); len(topics) != 2 {
+		t.Errorf("b.ChannelTopics() got %v, want 2 topics", topics)
+	}
+	b.Unsub(ch, []string{"t1"})
+	if topics := b.Topics(); len(topics) != 1 {
+		t.Errorf("b.Topics() got %v, want map[t2:{}]", topics)
+	}
+	b.Del("a_1")
+	if n := b.TopicCount(); n != 0 {
+		t.Errorf("b.TopicCount() got %d, want 0", n)
+	}
+}
+
+func TestBucketBroadcastFilter(t *testing.T) {
+	b := NewBucket(BucketOptions{ChannelSize: 10, RoomSize: 10, RoutineAmount: 1, RoutineSize: 1})
+	ios, android, none := NewChannel(1, 1), NewChannel(1, 1), NewChannel(1, 1)
+	ios.Attrs = map[string]string{"platform": "ios", "version": "5.2"}
+	android.Attrs = map[string]string{"platform": "android", "version": "6.0"}
+	b.Put("ios", define.NoRoom, ios)
+	b.Put("android", define.NoRoom, android)
+	b.Put("none", define.NoRoom, none)
+	f, err := filter.Parse("platform == ios && version >= 5")
+	if err != nil {
+		t.Fatalf("filter.Parse() error(%v)", err)
+	}
+	b.Broadcast(&proto.Proto{}, f)
+	if n, _ := ios.Queue(); n != 1 {
+		t.Errorf("ios queue got %d, want 1", n)
+	}
+	if n, _ := android.Queue(); n != 0 {
+		t.Errorf("android queue got %d, want 0", n)
+	}
+	b.Broadcast(&proto.Proto{}, nil)
+	if n, _ := none.Queue(); n != 1 {
+		t.Errorf("none queue got %d, want 1", n)
+	}
+}
+
+func TestChannelPushExpired(t *testing.T) {
+	ch := NewChannel(1, 2)
+	ch.Push(&proto.Proto{Expire: 1})
+	ch.Push(&proto.Proto{})
+	if n, _ := ch.Queue(); n != 1 {
+		t.Errorf("ch.Queue() got %d, want 1", n)
+	}
+}
+
+func TestRoomMembers(t *testing.T) {
+	r := NewRoom("1")
+	a, b, c := NewChannel(1, 1), NewChannel(1, 1), NewChannel(1, 1)
+	r.Put(a)
+	r.Put(b)
+	r.Put(c)
+	snapshot := r.Members()
+	if r.Del(a) {
+		t.Fatal("r.Del(a) dropped the room")
+	}
+	if len(snapshot) != 3 || snapshot[0] != a {
+		t.Errorf("snapshot changed by r.Del(), got %v", snapshot)
+	}
+	r.Push(&proto.Proto{})
+	if n, _ := a.Queue(); n != 0 {
+		t.Errorf("a queue got %d, want 0", n)
+	}
+	if n, _ := c.Queue(); n != 1 {
+		t.Errorf("c queue got %d, want 1", n)
+	}
+	if r.Del(c) {
+		t.Fatal("r.Del(c) dropped the room")
+	}
+	if !r.Del(b) {
+		t.Error("r.Del(b) should drop the empty room")
+	}
+	if err := r.Put(a); err != ErrRoomDroped {
+		t.Errorf("r.Put() error(%v), want ErrRoomDroped", err)
+	}
+}
+
+func TestRoomShards(t *testing.T) {
+	var (
+		r   = NewRoom("1")
+		chs = make([]*Channel, 2*roomShardSize+1)
+	)
+	for i := range chs {
+		chs[i] = NewChannel(1, 1)
+		r.Put(chs[i])
+	}
+	if n := len(r.loadShards()); n != 3 {
+		t.Fatalf("shards got %d, want 3", n)
+	}
+	// the full first shard is open again
+	r.Del(chs[0])
+	first := r.loadShards()[1].load()
+	r.Put(chs[0])
+	if n := len(r.loadShards()[0].load()); n != roomShardSize {
+		t.Errorf("first shard got %d, want %d", n, roomShardSize)
+	}
+	if &first[0] != &r.loadShards()[1].load()[0] {
+		t.Error("the other shard copied")
+	}
+	if n := len(r.Members()); n != len(chs) {
+		t.Errorf("members got %d, want %d", n, len(chs))
+	}
+	r.Push(&proto.Proto{})
+	for i, ch := range chs {
+		if n, _ := ch.Queue(); n != 1 {
+			t.Fatalf("channel %d queue got %d, want 1", i, n)
+		}
+	}
+	for i, ch := range chs {
+		if r.Del(ch) != (i == len(chs)-1) {
+			t.Fatalf("r.Del(%d) dropped %t", i, !(i == len(chs)-1))
+		}
+	}
+}
+
+func newBenchRoom(n int) (r *Room) {
+	r = NewRoom("1")
+	for i := 0; i < n; i++ {
+		r.Put(NewChannel(1, 1))
+	}
+	return
+}
+
+func BenchmarkRoomPush(b *testing.B) {
+	r := newBenchRoom(10000)
+	p := &proto.Proto{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Push(p)
+	}
+}
+
+func BenchmarkRoomPushParallel(b *testing.B) {
+	r := newBenchRoom(10000)
+	p := &proto.Proto{}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Push(p)
+		}
+	})
+}
+
+// BenchmarkRoomPushChurn push while the members join and leave.
+func BenchmarkRoomPushChurn(b *testing.B) {
+	var (
+		r    = newBenchRoom(10000)
+		p    = &proto.Proto{}
+		stop = make(chan struct{})
+		done = make(chan struct{})
+	)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				close(done)
+				return
+			default:
+			}
+			ch := NewChannel(1, 1)
+			r.Put(ch)
+			r.Del(ch)
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Push(p)
+	}
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+// BenchmarkBucketsBroadcastRoom fanout a room spread in the buckets.
+func BenchmarkBucketsBroadcastRoom(b *testing.B) {
+	var (
+		bs  = make([]*Bucket, 64)
+		arg = &proto.BoardcastRoomArg{RoomId: "1"}
+	)
+	for i := range bs {
+		bs[i] = NewBucket(BucketOptions{ChannelSize: 1024, RoomSize: 1, RoutineAmount: 4, RoutineSize: 1024})
+		for j := 0; j < 1000; j++ {
+			bs[i].Put(strconv.Itoa(j), "1", NewChannel(1, 1))
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, bucket := range bs {
+			bucket.BroadcastRoom(arg, true)
+		}
+	}
+}
+
+func TestRoomPushFrame(t *testing.T) {
+	var (
+		pool = bytes.NewPool(1, 64)
+		r    = NewRoom("1")
+		a, b = NewChannel(1, 1), NewChannel(1, 1)
+		p    = &proto.Proto{Operation: 5, Body: []byte("{}")}
+	)
+	r.Put(a)
+	r.Put(b)
+	b.Push(&proto.Proto{}) // full
+	f := proto.NewFrame(p, pool)
+	p.SetFrame(f)
+	r.Push(p)
+	f.Unref()
+	if q := a.Ready(); q != p {
+		t.Fatalf("a.Ready() got %v, want the pushed proto", q)
+	}
+	buf := pool.Get()
+	if &buf.Bytes()[0] == &f.Websocket()[0] {
+		t.Fatal("frame released before written")
+	}
+	pool.Put(buf)
+	p.Frame().Unref()
+	if buf = pool.Get(); &buf.Bytes()[0] != &f.TCP()[0] {
+		t.Error("frame not released after written")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	log "github.com/thinkboy/log4go"
+)
+
+var (
+	tcpCerts       *Certificates // tcp tls listener certificates
+	websocketCerts *Certificates // websocket tls listener certificates
+)
+
+// Certificates hold the certificates of tls listeners, selected by SNI.
+// reload only affects new handshakes, established connections are kept.
+type Certificates struct {
+	lock  sync.RWMutex
+	certs []tls.Certificate
+}
+
+// NewCertificates new a certificates struct and load the key pairs.
+func NewCertificates(certFiles, privateFiles []string) (c *Certificates, err error) {
+	c = new(Certificates)
+	err = c.Load(certFiles, privateFiles)
+	return
+}
+
+// Load load all the key pairs, the certFiles and privateFiles must be paired
+// by index. the first one is the default certificate if no SNI matched.
+func (c *Certificates) Load(certFiles, privateFiles []string) (err error) {
+	var (
+		i     int
+		cert  tls.Certificate
+		certs []tls.Certificate
+	)
+	if len(certFiles) == 0 || len(certFiles) != len(privateFiles) {
+		return ErrCertificate
+	}
+	certs = make([]tls.Certificate, 0, len(certFiles))
+	for i = 0; i < len(certFiles); i++ {
+		if cert, err = tls.LoadX509KeyPair(certFiles[i], privateFiles[i]); err != nil {
+			log.Error("tls.LoadX509KeyPair(\"%s\", \"%s\") error(%v)", certFiles[i], privateFiles[i], err)
+			return
+		}
+		// leaf used for SNI hostname matching
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			log.Error("x509.ParseCertificate(\"%s\") error(%v)", certFiles[i], err)
+			return
+		}
+		certs = append(certs, cert)
+	}
+	c.lock.Lock()
+	c.certs = certs
+	c.lock.Unlock()
+	return
+}
+
+// GetCertificate select a certificate by the client hello server name.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var (
+		i     int
+		certs []tls.Certificate
+	)
+	c.lock.RLock()
+	certs = c.certs
+	c.lock.RUnlock()
+	if len(certs) == 0 {
+		return nil, ErrCertificate
+	}
+	if hello.ServerName != "" {
+		for i = 0; i < len(certs); i++ {
+			if certs[i].Leaf.VerifyHostname(hello.ServerName) == nil {
+				return &certs[i], nil
+			}
+		}
+	}
+	return &certs[0], nil
+}
+
+// Config return a tls config use the certificates.
+func (c *Certificates) Config() *tls.Config {
+	return &tls.Config{GetCertificate: c.GetCertificate}
+}
+
+// reloadCertificates reload the tls listeners certificates after config reload.
+func reloadCertificates() {
+	if tcpCerts != nil {
+		if err := tcpCerts.Load(Conf.TCPCertFile, Conf.TCPPrivateFile); err != nil {
+			log.Error("tcp certificates reload error(%v)", err)
+		} else {
+			log.Info("tcp certificates reload: %v", Conf.TCPCertFile)
+		}
+	}
+	if websocketCerts != nil {
+		if err := websocketCerts.Load(Conf.WebsocketCertFile, Conf.WebsocketPrivateFile); err != nil {
+			log.Error("websocket certificates reload error(%v)", err)
+		} else {
+			log.Info("websocket certificates reload: %v", Conf.WebsocketCertFile)
+		}
+	}
+}
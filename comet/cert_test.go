@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir, host string) (certFile, privateFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, host+".pem")
+	privateFile = filepath.Join(dir, host+".key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goim-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	aCert, aKey := writeTestCert(t, dir, "a.goim.io")
+	bCert, bKey := writeTestCert(t, dir, "b.goim.io")
+	if _, err = NewCertificates([]string{aCert, bCert}, []string{aKey}); err != ErrCertificate {
+		t.Errorf("unpaired files error(%v)", err)
+	}
+	c, err := NewCertificates([]string{aCert, bCert}, []string{aKey, bKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, host := range map[string]string{"a.goim.io": "a.goim.io", "b.goim.io": "b.goim.io", "c.goim.io": "a.goim.io", "": "a.goim.io"} {
+		cert, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.Subject.CommonName != host {
+			t.Errorf("sni: \"%s\" got certificate: \"%s\", want: \"%s\"", name, cert.Leaf.Subject.CommonName, host)
+		}
+	}
+	// reload, the default certificate changed
+	if err = c.Load([]string{bCert}, []string{bKey}); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.goim.io"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.Subject.CommonName != "b.goim.io" {
+		t.Errorf("after reload got certificate: \"%s\"", cert.Leaf.Subject.CommonName)
+	}
+}
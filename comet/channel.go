@@ -3,6 +3,11 @@ package main
 import (
 	"goim/libs/bufio"
 	"goim/libs/proto"
+	"goim/libs/ratelimit"
+	itime "goim/libs/time"
+	"net"
+	"sync/atomic"
+	"time"
 )
 
 // Channel used by message pusher send msg to write goroutine.
@@ -12,8 +17,18 @@ type Channel struct {
 	signal   chan *proto.Proto
 	Writer   bufio.Writer
 	Reader   bufio.Reader
-	Next     *Channel
-	Prev     *Channel
+	Secure   *Secure             // secure session, nil if not
+	Limit    *ratelimit.Bucket   // upstream message limit, nil if not
+	Topics   map[string]struct{} // subscribed topics, protected by bucket
+	Attrs    map[string]string   // auth attributes for the broadcast filter
+	// admin
+	Key       string
+	Proto     string           // tcp or websocket
+	Conn      net.Conn         // underlying connection
+	Connected time.Time        // connect time
+	Timer     *itime.TimerData // handshake and heartbeat deadline
+	deadline  atomic.Int64     // heartbeat deadline in unix nanoseconds
+	notify    atomic.Value     // func() wakes the poller writes, unset if not polled
 }
 
 func NewChannel(cli, svr int) *Channel {
@@ -23,11 +38,58 @@ func NewChannel(cli, svr int) *Channel {
 	return c
 }
 
+// Accept set the connection info of the channel.
+func (c *Channel) Accept(typ string, conn net.Conn, td *itime.TimerData) {
+	c.Proto = typ
+	c.Conn = conn
+	c.Connected = time.Now()
+	c.Timer = td
+}
+
+// Heartbeat reset the heartbeat deadline of the channel.
+func (c *Channel) Heartbeat(tr *itime.Timer, hb time.Duration) {
+	tr.Set(c.Timer, hb)
+	c.deadline.Store(time.Now().Add(hb).UnixNano())
+}
+
+// Deadline get the heartbeat deadline, zero before the handshake done.
+func (c *Channel) Deadline() (t time.Time) {
+	if d := c.deadline.Load(); d > 0 {
+		t = time.Unix(0, d)
+	}
+	return
+}
+
+// Queue returns the length and capacity of the push queue.
+func (c *Channel) Queue() (n, size int) {
+	return len(c.signal), cap(c.signal)
+}
+
+// Kick close the underlying connection, the reader goroutine then exits
+// and cleans up the channel as a normal disconnect.
+func (c *Channel) Kick() (err error) {
+	if c.Conn != nil {
+		err = c.Conn.Close()
+	}
+	return
+}
+
 // Push server push message.
 func (c *Channel) Push(p *proto.Proto) (err error) {
+	if p.Expired() {
+		expiredDrop.Inc()
+		return
+	}
+	// the shared frame is released after written
+	p.Frame().Ref()
 	select {
 	case c.signal <- p:
+		if wake, ok := c.notify.Load().(func()); ok {
+			wake()
+		}
 	default:
+		p.Frame().Unref()
+		channelDrop.Inc()
 	}
 	return
 }
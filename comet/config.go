@@ -47,30 +47,40 @@ type Config struct {
 	Whitelist []string `goconf:"base:white.list:,"`
 	WhiteLog  string   `goconf:"base:white.log"`
 	// tcp
-	TCPBind         []string `goconf:"tcp:bind:,"`
-	TCPSndbuf       int      `goconf:"tcp:sndbuf:memory"`
-	TCPRcvbuf       int      `goconf:"tcp:rcvbuf:memory"`
-	TCPKeepalive    bool     `goconf:"tcp:keepalive"`
-	TCPReader       int      `goconf:"tcp:reader"`
-	TCPReadBuf      int      `goconf:"tcp:readbuf"`
-	TCPReadBufSize  int      `goconf:"tcp:readbuf.size"`
-	TCPWriter       int      `goconf:"tcp:writer"`
-	TCPWriteBuf     int      `goconf:"tcp:writebuf"`
-	TCPWriteBufSize int      `goconf:"tcp:writebuf.size"`
+	TCPBind              []string `goconf:"tcp:bind:,"`
+	TCPSndbuf            int      `goconf:"tcp:sndbuf:memory"`
+	TCPRcvbuf            int      `goconf:"tcp:rcvbuf:memory"`
+	TCPKeepalive         bool     `goconf:"tcp:keepalive"`
+	TCPReader            int      `goconf:"tcp:reader"`
+	TCPReadBuf           int      `goconf:"tcp:readbuf"`
+	TCPReadBufSize       int      `goconf:"tcp:readbuf.size"`
+	TCPWriter            int      `goconf:"tcp:writer"`
+	TCPWriteBuf          int      `goconf:"tcp:writebuf"`
+	TCPWriteBufSize      int      `goconf:"tcp:writebuf.size"`
+	TCPTLSOpen           bool     `goconf:"tcp:tls.open"`
+	TCPTLSBind           []string `goconf:"tcp:tls.bind:,"`
+	TCPCertFile          []string `goconf:"tcp:cert.file:,"`
+	TCPPrivateFile       []string `goconf:"tcp:private.file:,"`
+	TCPSecureOpen        bool     `goconf:"tcp:secure.open"`
+	TCPSecureBind        []string `goconf:"tcp:secure.bind:,"`
+	TCPSecurePrivateFile string   `goconf:"tcp:secure.private.file"`
 	// websocket
 	WebsocketBind        []string `goconf:"websocket:bind:,"`
 	WebsocketTLSOpen     bool     `goconf:"websocket:tls.open"`
 	WebsocketTLSBind     []string `goconf:"websocket:tls.bind:,"`
-	WebsocketCertFile    string   `goconf:"websocket:cert.file"`
-	WebsocketPrivateFile string   `goconf:"websocket:private.file"`
+	WebsocketCertFile    []string `goconf:"websocket:cert.file:,"`
+	WebsocketPrivateFile []string `goconf:"websocket:private.file:,"`
 	// flash safe policy
 	FlashPolicyOpen bool     `goconf:"flash:policy.open"`
 	FlashPolicyBind []string `goconf:"flash:policy.bind:,"`
 	// proto section
 	HandshakeTimeout time.Duration `goconf:"proto:handshake.timeout:time"`
 	WriteTimeout     time.Duration `goconf:"proto:write.timeout:time"`
+	WriteCoalesce    time.Duration `goconf:"proto:write.coalesce:time"`
 	SvrProto         int           `goconf:"proto:svr.proto"`
 	CliProto         int           `goconf:"proto:cli.proto"`
+	FrameBuf         int           `goconf:"proto:frame.buf"`
+	FrameBufSize     int           `goconf:"proto:frame.buf.size"`
 	// timer
 	Timer     int `goconf:"timer:num"`
 	TimerSize int `goconf:"timer:size"`
@@ -80,13 +90,46 @@ type Config struct {
 	BucketRoom    int    `goconf:"bucket:room"`
 	RoutineAmount uint64 `goconf:"bucket:routine.amount"`
 	RoutineSize   int    `goconf:"bucket:routine.size"`
+	// topic
+	BucketTopicMax int `goconf:"bucket:topic.max"`
+	// epoll
+	EpollOpen    bool `goconf:"epoll:open"`
+	EpollNum     int  `goconf:"epoll:num"`
+	EpollWorkers int  `goconf:"epoll:workers"`
+	EpollQueue   int  `goconf:"epoll:queue"`
 	// push
 	RPCPushAddrs []string `goconf:"push:rpc.addrs:,"`
 	// logic
-	LogicAddrs []string `goconf:"logic:rpc.addrs:,"`
+	LogicAddrs            []string `goconf:"logic:rpc.addrs:,"`
+	LogicRoomHistory      int      `goconf:"logic:room.history"`
+	LogicRoomHistoryFetch int      `goconf:"logic:room.history.fetch"`
+	// rpc auth
+	RPCTLSOpen       bool   `goconf:"rpc.auth:tls.open"`
+	RPCTLSCert       string `goconf:"rpc.auth:tls.cert"`
+	RPCTLSKey        string `goconf:"rpc.auth:tls.key"`
+	RPCTLSCA         string `goconf:"rpc.auth:tls.ca"`
+	RPCTLSServerName string `goconf:"rpc.auth:tls.server.name"`
+	RPCSecret        string `goconf:"rpc.auth:secret"`
+	// limit
+	LimitChannelRate     int    `goconf:"limit:channel.rate"`
+	LimitChannelBurst    int    `goconf:"limit:channel.burst"`
+	LimitChannelAction   string `goconf:"limit:channel.action"`
+	LimitIPRate          int    `goconf:"limit:ip.rate"`
+	LimitIPBurst         int    `goconf:"limit:ip.burst"`
+	LimitHandshakeRate   int    `goconf:"limit:handshake.rate"`
+	LimitHandshakeBurst  int    `goconf:"limit:handshake.burst"`
+	LimitHandshakeAction string `goconf:"limit:handshake.action"`
 	// monitor
-	MonitorOpen  bool     `goconf:"monitor:open"`
-	MonitorAddrs []string `goconf:"monitor:addrs:,"`
+	MonitorOpen     bool     `goconf:"monitor:open"`
+	MonitorAddrs    []string `goconf:"monitor:addrs:,"`
+	MonitorAdmin    bool     `goconf:"monitor:admin"`
+	MonitorAdminKey string   `goconf:"monitor:admin.key"`
+	// trace
+	TraceSample   int           `goconf:"trace:sample"`
+	TraceURL      string        `goconf:"trace:exporter.url"`
+	TraceBatch    int           `goconf:"trace:exporter.batch"`
+	TraceInterval time.Duration `goconf:"trace:exporter.interval:time"`
+	TraceQueue    int           `goconf:"trace:exporter.queue"`
 	//gulu
 	GuluLoggerAppId int    `goconf:"gulu:gululogger.appid"`
 	GuluLoggerAddr  string `goconf:"gulu:gululogger.addr"`
@@ -107,13 +150,21 @@ func NewConfig() *Config {
 		TCPSndbuf:    1024,
 		TCPRcvbuf:    1024,
 		TCPKeepalive: false,
+		// tcp tls
+		TCPTLSOpen:           false,
+		TCPTLSBind:           []string{"0.0.0.0:8085"},
+		TCPCertFile:          []string{"../source/cert.pem"},
+		TCPPrivateFile:       []string{"../source/private.pem"},
+		TCPSecureOpen:        false,
+		TCPSecureBind:        []string{"0.0.0.0:8086"},
+		TCPSecurePrivateFile: "../source/secure.pem",
 		// websocket
 		WebsocketBind: []string{"0.0.0.0:8090"},
 		// websocket tls
 		WebsocketTLSOpen:     false,
 		WebsocketTLSBind:     []string{"0.0.0.0:8095"},
-		WebsocketCertFile:    "../source/cert.pem",
-		WebsocketPrivateFile: "../source/private.pem",
+		WebsocketCertFile:    []string{"../source/cert.pem"},
+		WebsocketPrivateFile: []string{"../source/private.pem"},
 		// flash safe policy
 		FlashPolicyOpen: false,
 		FlashPolicyBind: []string{"0.0.0.0:843"},
@@ -124,6 +175,8 @@ func NewConfig() *Config {
 		TCPWriteBuf:      1024,
 		TCPReadBufSize:   1024,
 		TCPWriteBufSize:  1024,
+		FrameBuf:         64,
+		FrameBufSize:     8192,
 		// timer
 		Timer:     runtime.NumCPU(),
 		TimerSize: 1000,
@@ -132,8 +185,23 @@ func NewConfig() *Config {
 		CliProto:      5,
 		SvrProto:      80,
 		BucketChannel: 1024,
+		// topic
+		BucketTopicMax: 64,
+		// epoll
+		EpollNum:     1,
+		EpollWorkers: runtime.NumCPU() * 8,
+		EpollQueue:   10240,
 		// push
 		RPCPushAddrs: []string{"localhost:8083"},
+		// logic
+		LogicRoomHistoryFetch: 64,
+		// limit
+		LimitChannelAction:   "close",
+		LimitHandshakeAction: "close",
+		// trace
+		TraceBatch:    512,
+		TraceInterval: 5 * time.Second,
+		TraceQueue:    10240,
 	}
 }
 
@@ -147,6 +215,10 @@ func InitConfig() (err error) {
 	if err := gconf.Unmarshal(Conf); err != nil {
 		return err
 	}
+	// the poller workers write, a stuck client must not pin one
+	if Conf.EpollOpen && Conf.WriteTimeout <= 0 {
+		return ErrPollerWriteTimeout
+	}
 	return nil
 }
 
@@ -0,0 +1,249 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	// one read task a time, rearmed after the reads would block
+	epollEvents  = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+	epollWaitMax = 128
+)
+
+// pollConn is a tcp connection served by the poller after the handshake,
+// the reads do not block and the close wakes the poller to clean up.
+type pollConn struct {
+	*net.TCPConn
+	rc     syscall.RawConn
+	fd     int
+	polled int32
+}
+
+// Read read the connection, it does not block after polled but returns
+// errWouldBlock if no data.
+func (c *pollConn) Read(b []byte) (n int, err error) {
+	if atomic.LoadInt32(&c.polled) == 0 {
+		return c.TCPConn.Read(b)
+	}
+	if rerr := c.rc.Read(func(fd uintptr) bool {
+		n, err = syscall.Read(int(fd), b)
+		// never wait in the runtime poller
+		return true
+	}); rerr != nil {
+		return 0, rerr
+	}
+	if err == syscall.EAGAIN {
+		return 0, errWouldBlock
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n == 0 && err == nil && len(b) > 0 {
+		err = io.EOF
+	}
+	return
+}
+
+// Close close the connection, after polled it only shuts down the
+// connection, the poller then closes it in the clean up.
+func (c *pollConn) Close() error {
+	if atomic.LoadInt32(&c.polled) == 0 {
+		return c.TCPConn.Close()
+	}
+	return c.rc.Control(func(fd uintptr) {
+		syscall.Shutdown(int(fd), syscall.SHUT_RDWR)
+	})
+}
+
+// Poller serve the connections after the handshake by epoll and a fixed
+// worker pool instead of the reader and dispatch goroutines of each.
+type Poller struct {
+	fds     []int // epoll fds
+	lock    sync.RWMutex
+	conns   map[int]*pollChannel
+	tasks   chan func()
+	bLock   sync.Mutex
+	backlog []func() // the writes over the full queue
+}
+
+// NewPoller new a poller with num epoll instances and the workers, queue
+// is the size of the task queue.
+func NewPoller(num, workers, queue int) (p *Poller, err error) {
+	var fd int
+	p = &Poller{conns: make(map[int]*pollChannel), tasks: make(chan func(), queue)}
+	for i := 0; i < num; i++ {
+		if fd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+			log.Error("syscall.EpollCreate1() error(%v)", err)
+			return
+		}
+		p.fds = append(p.fds, fd)
+		go p.pollproc(fd)
+	}
+	for i := 0; i < workers; i++ {
+		go p.workproc()
+	}
+	return
+}
+
+// Wrap wrap the tcp connection to be polled after the handshake, the others
+// are returned as they are.
+func (p *Poller) Wrap(conn net.Conn) net.Conn {
+	var (
+		err error
+		fd  int
+		c   = &pollConn{}
+		ok  bool
+	)
+	if c.TCPConn, ok = conn.(*net.TCPConn); !ok {
+		return conn
+	}
+	if c.rc, err = c.TCPConn.SyscallConn(); err != nil {
+		return conn
+	}
+	if err = c.rc.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return conn
+	}
+	c.fd = fd
+	return c
+}
+
+// Serve register the channel to the poller, ErrPollerConn if the
+// connection is not wrapped by the poller.
+func (p *Poller) Serve(pc *pollChannel) (err error) {
+	c, ok := pc.conn.(*pollConn)
+	if !ok {
+		return ErrPollerConn
+	}
+	pc.poller = p
+	p.lock.Lock()
+	p.conns[c.fd] = pc
+	p.lock.Unlock()
+	atomic.StoreInt32(&c.polled, 1)
+	if err = syscall.EpollCtl(p.epfd(c.fd), syscall.EPOLL_CTL_ADD, c.fd, &syscall.EpollEvent{Events: epollEvents, Fd: int32(c.fd)}); err != nil {
+		log.Error("syscall.EpollCtl(ADD, %d) error(%v)", c.fd, err)
+		atomic.StoreInt32(&c.polled, 0)
+		p.lock.Lock()
+		delete(p.conns, c.fd)
+		p.lock.Unlock()
+		return
+	}
+	pc.ch.notify.Store(pc.wake)
+	return
+}
+
+// epfd get the epoll of the connection.
+func (p *Poller) epfd(fd int) int {
+	return p.fds[fd%len(p.fds)]
+}
+
+// rearm wait the next read of the channel.
+func (p *Poller) rearm(pc *pollChannel) error {
+	c := pc.conn.(*pollConn)
+	return syscall.EpollCtl(p.epfd(c.fd), syscall.EPOLL_CTL_MOD, c.fd, &syscall.EpollEvent{Events: epollEvents, Fd: int32(c.fd)})
+}
+
+// del unregister the channel and close its connection.
+func (p *Poller) del(pc *pollChannel) {
+	c := pc.conn.(*pollConn)
+	syscall.EpollCtl(p.epfd(c.fd), syscall.EPOLL_CTL_DEL, c.fd, nil)
+	p.lock.Lock()
+	delete(p.conns, c.fd)
+	p.lock.Unlock()
+	c.TCPConn.Close()
+}
+
+// run run the read in a worker, the caller waits while the queue is full,
+// so the poller stops taking the events and the connections wait in the
+// kernel.
+func (p *Poller) run(f func()) {
+	select {
+	case p.tasks <- f:
+	default:
+		pollOverflow.Inc()
+		p.tasks <- f
+	}
+}
+
+// post run the write in a worker, it never blocks the pushers and the
+// workers, the write waits in the backlog if the queue is full. a channel
+// has one write at most by pollChannel.writing, so the backlog is bounded
+// by the connections.
+func (p *Poller) post(f func()) {
+	select {
+	case p.tasks <- f:
+	default:
+		pollOverflow.Inc()
+		p.bLock.Lock()
+		p.backlog = append(p.backlog, f)
+		p.bLock.Unlock()
+	}
+}
+
+// drain run the backlog writes, the backlog is only added while the queue
+// is full, so a worker always drains it after a task.
+func (p *Poller) drain() {
+	var f func()
+	for {
+		p.bLock.Lock()
+		if len(p.backlog) == 0 {
+			p.bLock.Unlock()
+			return
+		}
+		f = p.backlog[0]
+		p.backlog[0] = nil
+		p.backlog = p.backlog[1:]
+		p.bLock.Unlock()
+		f()
+	}
+}
+
+// Len get the polled connections.
+func (p *Poller) Len() (n int) {
+	p.lock.RLock()
+	n = len(p.conns)
+	p.lock.RUnlock()
+	return
+}
+
+func (p *Poller) pollproc(epfd int) {
+	var (
+		err    error
+		n, i   int
+		ok     bool
+		pc     *pollChannel
+		events = make([]syscall.EpollEvent, epollWaitMax)
+	)
+	for {
+		if n, err = syscall.EpollWait(epfd, events, -1); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Error("syscall.EpollWait(%d) error(%v)", epfd, err)
+			return
+		}
+		for i = 0; i < n; i++ {
+			p.lock.RLock()
+			pc, ok = p.conns[int(events[i].Fd)]
+			p.lock.RUnlock()
+			if ok {
+				p.run(pc.read)
+			}
+		}
+	}
+}
+
+func (p *Poller) workproc() {
+	for f := range p.tasks {
+		f()
+		p.drain()
+	}
+}
@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"goim/libs/bufio"
+	"goim/libs/define"
+	"goim/libs/proto"
+	"net"
+	"testing"
+	"time"
+)
+
+type testOperator struct{}
+
+func (testOperator) Operate(p *proto.Proto) error {
+	p.Operation++
+	return nil
+}
+
+func (testOperator) Connect(p *proto.Proto) (string, string, map[string]string, time.Duration, error) {
+	return "1", define.NoRoom, nil, time.Minute, nil
+}
+
+func (testOperator) Disconnect(key, rid string) error {
+	return nil
+}
+
+func TestPollerTCP(t *testing.T) {
+	var (
+		p   = new(proto.Proto)
+		st  = NewStat()
+		bs  = []*Bucket{NewBucket(BucketOptions{ChannelSize: 1, RoomSize: 1, RoutineAmount: 1, RoutineSize: 1})}
+		r   = NewRound(RoundOptions{Reader: 1, ReadBuf: 2, ReadBufSize: 2048, Writer: 1, WriteBuf: 2, WriteBufSize: 2048, Timer: 1, TimerSize: 10})
+		opt = ServerOptions{CliProto: 4, SvrProto: 4, HandshakeTimeout: time.Second, Epoll: EpollOptions{Open: true, Num: 1, Workers: 2, Queue: 16}}
+	)
+	server := NewServer(st, bs, r, testOperator{}, opt)
+	if server.poller == nil {
+		t.Fatal("NewServer() poller not started")
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		serveTCP(server, conn, 0, nil)
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rr, wr := bufio.NewReader(conn), bufio.NewWriter(conn)
+	// handshake, served by the goroutine
+	p.Operation = define.OP_AUTH
+	p.WriteTCP(wr)
+	wr.Flush()
+	if err = p.ReadTCP(rr); err != nil || p.Operation != define.OP_AUTH_REPLY {
+		t.Fatalf("auth reply op:%d error(%v)", p.Operation, err)
+	}
+	// the protos are read by the poller
+	p.Operation, p.Body = define.OP_HEARTBEAT, nil
+	p.WriteTCP(wr)
+	p.Operation, p.Body = define.OP_SEND_SMS, []byte("hi")
+	p.WriteTCP(wr)
+	wr.Flush()
+	for _, op := range []int32{define.OP_HEARTBEAT_REPLY, define.OP_SEND_SMS + 1} {
+		if err = p.ReadTCP(rr); err != nil || p.Operation != op {
+			t.Fatalf("reply op:%d, want %d error(%v)", p.Operation, op, err)
+		}
+	}
+	if n := server.poller.Len(); n != 1 {
+		t.Fatalf("server.poller.Len() got %d, want 1", n)
+	}
+	// the pushes are written by the poller
+	bs[0].Channel("1").Push(&proto.Proto{Operation: define.OP_SEND_SMS_REPLY, Body: []byte("push")})
+	if err = p.ReadTCP(rr); err != nil || string(p.Body) != "push" {
+		t.Fatalf("push got %s error(%v)", p.Body, err)
+	}
+	// closed by the client, cleaned up by the poller
+	conn.Close()
+	for i := 0; i < 100 && (server.poller.Len() != 0 || bs[0].Channel("1") != nil); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.poller.Len() != 0 || bs[0].Channel("1") != nil {
+		t.Error("the closed connection not cleaned up")
+	}
+}
+
+func TestPollerBacklog(t *testing.T) {
+	// no workers, started after the queue is full
+	p, err := NewPoller(1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		p.post(func() { done <- i })
+	}
+	if len(p.backlog) != 2 {
+		t.Fatalf("backlog got %d, want 2", len(p.backlog))
+	}
+	go p.workproc()
+	for i := 0; i < 3; i++ {
+		select {
+		case n := <-done:
+			if n != i {
+				t.Errorf("write got %d, want %d", n, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the backlog writes not run")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// Poller is not supported but on linux, the connections are served by
+// their own goroutines.
+type Poller struct{}
+
+// NewPoller returns ErrPollerUnsupported.
+func NewPoller(num, workers, queue int) (*Poller, error) {
+	return nil, ErrPollerUnsupported
+}
+
+// Wrap returns the connection as it is.
+func (p *Poller) Wrap(conn net.Conn) net.Conn {
+	return conn
+}
+
+// Serve returns ErrPollerUnsupported.
+func (p *Poller) Serve(pc *pollChannel) error {
+	return ErrPollerUnsupported
+}
+
+func (p *Poller) rearm(pc *pollChannel) error {
+	return ErrPollerUnsupported
+}
+
+func (p *Poller) del(pc *pollChannel) {
+	pc.conn.Close()
+}
+
+func (p *Poller) run(f func()) {
+	go f()
+}
+
+func (p *Poller) post(f func()) {
+	go f()
+}
+
+// Len returns 0.
+func (p *Poller) Len() int {
+	return 0
+}
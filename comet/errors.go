@@ -22,6 +22,24 @@ var (
 	ErrMPushMsgsArg = errors.New("rpc mpushmsgs arg error")
 	// room
 	ErrRoomDroped = errors.New("room droped")
+	ErrRoomFull   = errors.New("room full")
+	// topic
+	ErrTopic    = errors.New("topic must not empty and at most 128 bytes")
+	ErrTopicMax = errors.New("over the max topics of a channel")
 	// rpc
 	ErrLogic = errors.New("logic rpc is not available")
+	// tls
+	ErrCertificate = errors.New("tls certificate and private files not paired")
+	// limit
+	ErrHandshakeLimit = errors.New("handshake rate limited")
+	ErrChannelLimit   = errors.New("channel message rate limited")
+	// secure
+	ErrSecureAuth = errors.New("secure auth body not valid")
+	// poller
+	ErrPollerUnsupported  = errors.New("epoll is only supported on linux")
+	ErrPollerConn         = errors.New("connection can not be polled")
+	ErrPollerWriteTimeout = errors.New("epoll:open requires a positive proto:write.timeout")
+	errWouldBlock         = errors.New("read would block")
+	// operation
+	errAsyncReply = errors.New("reply pushed asynchronously")
 )
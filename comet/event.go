@@ -0,0 +1,303 @@
+package main
+
+import (
+	"goim/libs/bufio"
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"goim/libs/net/websocket"
+	"goim/libs/proto"
+	itime "goim/libs/time"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// pollChannel is a connection served by the poller workers after the
+// handshake, the reads are run when the connection is readable and the
+// writes when the channel is pushed, instead of the reader and dispatch
+// goroutines of the connection.
+type pollChannel struct {
+	server *Server
+	poller *Poller
+	conn   net.Conn
+	ch     *Channel
+	b      *Bucket
+	ws     *websocket.Conn // nil if tcp
+	key    string
+	rid    string
+	hb     time.Duration
+	tr     *itime.Timer
+	trd    *itime.TimerData
+	rp     *bytes.Pool
+	rb     *bytes.Buffer
+	wp     *bytes.Pool
+	wb     *bytes.Buffer
+	// the client protos ring is shared by the read and write tasks
+	cliLock sync.Mutex
+	// state
+	reading  int32
+	writing  int32
+	closed   int32
+	released int32
+}
+
+// read read and handle the buffered protos until the reads would block, the
+// channel is closed if failed.
+func (pc *pollChannel) read() {
+	var err error
+	if !atomic.CompareAndSwapInt32(&pc.reading, 0, 1) {
+		// a stale event, the running one reads all
+		return
+	}
+	for {
+		if pc.ws != nil {
+			err = pc.ws.PeekMessage()
+		} else {
+			err = proto.PeekTCP(&pc.ch.Reader)
+		}
+		if err != nil {
+			break
+		}
+		if err = pc.handle(); err != nil {
+			break
+		}
+	}
+	atomic.StoreInt32(&pc.reading, 0)
+	if err == errWouldBlock {
+		if err = pc.poller.rearm(pc); err == nil {
+			return
+		}
+	}
+	pc.close(err)
+}
+
+// handle read and handle a proto as the reader goroutine.
+func (pc *pollChannel) handle() (err error) {
+	var (
+		act int
+		p   *proto.Proto
+		ch  = pc.ch
+	)
+	pc.cliLock.Lock()
+	p, err = ch.CliProto.Set()
+	pc.cliLock.Unlock()
+	if err != nil {
+		return
+	}
+	if pc.ws != nil {
+		err = p.ReadWebsocket(pc.ws)
+	} else if err = p.ReadTCP(&ch.Reader); err == nil && ch.Secure != nil && len(p.Body) > 0 {
+		p.Body, err = ch.Secure.Decrypt(p.Body)
+	}
+	if err != nil {
+		return
+	}
+	if p.Operation == define.OP_HEARTBEAT {
+		pc.ch.Heartbeat(pc.tr, pc.hb)
+		if pc.ws == nil {
+			p.Body = nil
+		}
+		p.Operation = define.OP_HEARTBEAT_REPLY
+	} else if act = pc.server.limiter.Message(ch); act == limitPass {
+		if err = pc.server.operate(pc.b, ch, p); err == errAsyncReply {
+			return nil
+		} else if err != nil {
+			return
+		}
+	} else if act == limitDrop {
+		return
+	} else if act == limitReply {
+		p.Body = nil
+		p.Operation = define.OP_RATE_LIMIT_REPLY
+	} else {
+		return ErrChannelLimit
+	}
+	pc.cliLock.Lock()
+	ch.CliProto.SetAdv()
+	pc.cliLock.Unlock()
+	pc.wake()
+	return
+}
+
+// wake run the writes if not running, called after the channel pushed.
+func (pc *pollChannel) wake() {
+	if atomic.CompareAndSwapInt32(&pc.writing, 0, 1) {
+		pc.poller.post(pc.write)
+	}
+}
+
+// pending report whether the channel has protos to write.
+func (pc *pollChannel) pending() (ok bool) {
+	if len(pc.ch.signal) > 0 || atomic.LoadInt32(&pc.closed) == 1 {
+		return true
+	}
+	pc.cliLock.Lock()
+	ok = pc.ch.CliProto.rp != pc.ch.CliProto.wp
+	pc.cliLock.Unlock()
+	return
+}
+
+// write write the pending protos as the dispatch goroutine, until none.
+func (pc *pollChannel) write() {
+	for {
+		pc.flush()
+		atomic.StoreInt32(&pc.writing, 0)
+		if !pc.pending() || !atomic.CompareAndSwapInt32(&pc.writing, 0, 1) {
+			return
+		}
+	}
+}
+
+func (pc *pollChannel) flush() {
+	var (
+		err  error
+		kick bool
+		p    *proto.Proto
+		ch   = pc.ch
+		wr   = &ch.Writer
+	)
+	if atomic.LoadInt32(&pc.closed) == 1 {
+		pc.release()
+		return
+	}
+	// the queue is drained before flushed, coalesced already
+	if t := pc.server.Options.WriteTimeout; t > 0 {
+		pc.conn.SetWriteDeadline(time.Now().Add(t))
+	}
+	// client replies
+	for {
+		pc.cliLock.Lock()
+		p, err = ch.CliProto.Get()
+		pc.cliLock.Unlock()
+		if err != nil {
+			err = nil
+			break
+		}
+		if err = pc.writeProto(wr, p); err != nil {
+			goto failed
+		}
+		p.Body = nil // avoid memory leak
+		pc.cliLock.Lock()
+		ch.CliProto.GetAdv()
+		pc.cliLock.Unlock()
+	}
+	// server pushes
+	for {
+		select {
+		case p = <-ch.signal:
+		default:
+			goto flush
+		}
+		if p == proto.ProtoReady || p == proto.ProtoFinish {
+			continue
+		}
+		if p.Expired() {
+			p.Frame().Unref()
+			expiredDrop.Inc()
+			continue
+		}
+		err = pc.writeProto(wr, p)
+		p.Frame().Unref()
+		if err != nil {
+			goto failed
+		}
+		// kicked by the session policy, close after the reply flushed
+		if kick = p.Operation == define.OP_DISCONNECT_REPLY; kick {
+			break
+		}
+	}
+flush:
+	if pc.ws != nil {
+		err = pc.ws.Flush()
+	} else {
+		err = wr.Flush()
+	}
+	if err == nil && !kick {
+		return
+	}
+failed:
+	if stalled(err) {
+		if pc.ws != nil {
+			wsStalled.Inc()
+		} else {
+			tcpStalled.Inc()
+		}
+	}
+	if err != nil && err != io.EOF {
+		log.Error("key: %s poll dispatch error(%v)", pc.key, err)
+	}
+	// the read task cleans up
+	pc.conn.Close()
+}
+
+func (pc *pollChannel) writeProto(wr *bufio.Writer, p *proto.Proto) error {
+	if pc.ws != nil {
+		return writeWebsocket(pc.ws, p)
+	}
+	return writeTCP(pc.ch, wr, p)
+}
+
+// release discard the pushed protos and put back the writer buffer after
+// closed, the buffer once.
+func (pc *pollChannel) release() {
+	for {
+		select {
+		case p := <-pc.ch.signal:
+			p.Frame().Unref()
+		default:
+			if atomic.CompareAndSwapInt32(&pc.released, 0, 1) {
+				pc.wp.Put(pc.wb)
+			}
+			return
+		}
+	}
+}
+
+// close clean up the channel as the reader goroutine exits.
+func (pc *pollChannel) close(err error) {
+	var server = pc.server
+	if err != nil && err != io.EOF && err != websocket.ErrMessageClose {
+		log.Error("key: %s server %s failed error(%v)", pc.key, pc.ch.Proto, err)
+	}
+	pc.b.Del(pc.key)
+	pc.tr.Del(pc.trd)
+	pc.poller.del(pc)
+	pc.rp.Put(pc.rb)
+	atomic.StoreInt32(&pc.closed, 1)
+	pc.wake()
+	if err = server.operator.Disconnect(pc.key, pc.rid); err != nil {
+		log.Error("key: %s operator do disconnect error(%v)", pc.key, err)
+	}
+	if pc.ws != nil {
+		server.Stat.DecrWsOnline()
+	} else {
+		server.Stat.DecrTcpOnline()
+	}
+}
+
+// poll serve the channel by the poller after the handshake, false if the
+// poller is disabled or the connection can not be polled.
+func (server *Server) poll(pc *pollChannel) bool {
+	if server.poller == nil {
+		return false
+	}
+	// the handshake may buffer the first protos, checked before the poller
+	// reads
+	buffered := pc.ch.Reader.Buffered() > 0
+	pc.server = server
+	if err := server.poller.Serve(pc); err != nil {
+		return false
+	}
+	if buffered {
+		server.poller.run(pc.read)
+	}
+	// the pushes before polled
+	if pc.pending() {
+		pc.wake()
+	}
+	return true
+}
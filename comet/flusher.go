@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// flusher arm the write deadline of the dispatch batches, and coalesce the
+// queued server protos into one flush under load.
+type flusher struct {
+	conn     net.Conn
+	ch       *Channel
+	timeout  time.Duration // the write deadline of a batch, 0 if none
+	coalesce time.Duration // the flush delay at most, 0 if flush each
+	start    time.Time     // the first write not flushed, zero if none
+}
+
+func newFlusher(server *Server, conn net.Conn, ch *Channel) *flusher {
+	return &flusher{conn: conn, ch: ch, timeout: server.Options.WriteTimeout, coalesce: server.Options.WriteCoalesce}
+}
+
+// begin arm the write deadline before the first write of a batch.
+func (f *flusher) begin() {
+	if !f.start.IsZero() {
+		return
+	}
+	f.start = time.Now()
+	if f.timeout > 0 {
+		f.conn.SetWriteDeadline(f.start.Add(f.timeout))
+	}
+}
+
+// hold report whether the flush can wait for the queued protos.
+func (f *flusher) hold() bool {
+	return f.coalesce > 0 && len(f.ch.signal) > 0 && time.Since(f.start) < f.coalesce
+}
+
+// done end the batch after flushed.
+func (f *flusher) done() {
+	f.start = time.Time{}
+}
+
+// stalled report whether the write failed for the deadline, the stalled
+// connection is closed by the caller.
+func stalled(err error) bool {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+	return false
+}
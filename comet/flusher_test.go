@@ -0,0 +1,60 @@
+package main
+
+import (
+	"goim/libs/bufio"
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"goim/libs/proto"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFlusherHold(t *testing.T) {
+	ch := NewChannel(1, 2)
+	f := &flusher{ch: ch, coalesce: time.Hour}
+	f.begin()
+	if f.hold() {
+		t.Error("hold() with empty queue, want flush")
+	}
+	ch.Signal()
+	if !f.hold() {
+		t.Error("hold() with queued protos in the window, want hold")
+	}
+	f.done()
+	f.begin()
+	f.coalesce = 0
+	if f.hold() {
+		t.Error("hold() without coalesce, want flush")
+	}
+}
+
+func TestDispatchStalled(t *testing.T) {
+	var (
+		server       = &Server{Options: ServerOptions{WriteTimeout: 20 * time.Millisecond}}
+		ch           = NewChannel(1, 2)
+		conn, client = net.Pipe()
+		done         = make(chan struct{})
+		wp           = bytes.NewPool(1, 1024)
+		wb           = wp.Get()
+	)
+	ch.Writer.ResetBuffer(conn, wb.Bytes())
+	go func() {
+		server.dispatchTCP("1", conn, &ch.Writer, wp, wb, ch)
+		close(done)
+	}()
+	// the client never reads
+	ch.Push(&proto.Proto{Operation: define.OP_SEND_SMS_REPLY, Body: []byte("push")})
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if _, err := bufio.NewReader(client).Peek(1); err != io.EOF {
+		t.Errorf("stalled connection not closed, read error(%v)", err)
+	}
+	ch.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("dispatchTCP() not exited")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"goim/libs/bytes"
+	"goim/libs/proto"
+)
+
+var (
+	// framePool is the buffers of the shared broadcast frames, nil disables
+	// the sharing.
+	framePool *bytes.Pool
+)
+
+// InitFrame init the shared frame buffers, num 0 encodes the broadcasts for
+// every channel.
+func InitFrame(num, size int) {
+	if num > 0 && size > 0 {
+		framePool = bytes.NewPool(num, size)
+	}
+}
+
+// shareFrame encode the broadcast proto once for all the channels, the
+// returned frame holds a reference dropped by the caller after the
+// fanout, nil if disabled.
+func shareFrame(p *proto.Proto) (f *proto.Frame) {
+	if framePool == nil {
+		return
+	}
+	f = proto.NewFrame(p, framePool)
+	p.SetFrame(f)
+	return
+}
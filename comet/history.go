@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"goim/libs/proto"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// historyArg is the optional json body of OP_ROOM_HISTORY.
+type historyArg struct {
+	N     int   `json:"n"`     // the latest n messages, 0 all
+	Since int64 `json:"since"` // the messages after it, unix milliseconds
+}
+
+// history reply the room history of the channel to OP_ROOM_HISTORY, the
+// messages are packed as OP_ROOM_HISTORY_REPLY protos in a OP_RAW proto,
+// an empty history replies an empty OP_ROOM_HISTORY_REPLY. it is fetched
+// asynchronously not to block the reader and pushed as the reply, then
+// errAsyncReply is returned. the fetches are bounded by the join history's,
+// the requests over it reply an empty history.
+func (server *Server) history(ch *Channel, p *proto.Proto) (err error) {
+	var arg historyArg
+	if len(p.Body) > 0 {
+		if err = json.Unmarshal(p.Body, &arg); err != nil {
+			return
+		}
+	}
+	if ch.Room != nil {
+		var (
+			roomId = ch.Room.Id
+			reply  = &proto.Proto{Ver: p.Ver, Operation: define.OP_ROOM_HISTORY, SeqId: p.SeqId}
+		)
+		if server.fetch(func() { server.pushHistory(ch, roomId, arg, reply) }) {
+			return errAsyncReply
+		}
+	}
+	packHistory(p, nil)
+	return
+}
+
+// joinHistory push the latest n messages of the room after the channel
+// joined, fetched asynchronously not to delay the handshake. the concurrent
+// fetches are bounded, the joins over it are skipped and the clients may
+// ask it by OP_ROOM_HISTORY.
+func (server *Server) joinHistory(ch *Channel, n int) {
+	if n <= 0 || ch.Room == nil {
+		return
+	}
+	roomId := ch.Room.Id
+	server.fetch(func() { server.pushHistory(ch, roomId, historyArg{N: n}, new(proto.Proto)) })
+}
+
+// fetch run f in a goroutine if a fetch token is free, false if not.
+func (server *Server) fetch(f func()) bool {
+	if server.fetches == nil {
+		return false
+	}
+	select {
+	case server.fetches <- struct{}{}:
+	default:
+		limitHistory.Inc()
+		return false
+	}
+	go func() {
+		f()
+		<-server.fetches
+	}()
+	return true
+}
+
+// pushHistory push the messages of the room to the channel in p, the reply
+// of OP_ROOM_HISTORY is pushed even empty or failed, the join history only
+// if any.
+func (server *Server) pushHistory(ch *Channel, roomId string, arg historyArg, p *proto.Proto) {
+	var (
+		err  error
+		msgs []*proto.RoomHistoryMsg
+	)
+	// the history is optional, fails as empty
+	if msgs, err = roomHistory(roomId, arg.N, arg.Since); err != nil {
+		log.Error("key: %s roomHistory(%s) error(%v)", ch.Key, roomId, err)
+	}
+	if len(msgs) > 0 || p.Operation == define.OP_ROOM_HISTORY {
+		packHistory(p, msgs)
+		ch.Push(p)
+	}
+}
+
+func packHistory(p *proto.Proto, msgs []*proto.RoomHistoryMsg) {
+	ps := make([]proto.Proto, len(msgs))
+	for i, m := range msgs {
+		ps[i] = proto.Proto{Ver: p.Ver, Operation: define.OP_ROOM_HISTORY_REPLY, SeqId: p.SeqId, Body: m.Msg}
+	}
+	packRaw(p, ps, define.OP_ROOM_HISTORY_REPLY)
+}
+
+// packRaw pack the protos in p as a OP_RAW proto, or an empty proto of the
+// operation if none.
+func packRaw(p *proto.Proto, ps []proto.Proto, operation int32) {
+	if len(ps) == 0 {
+		p.Operation = operation
+		p.Body = nil
+		return
+	}
+	var (
+		size int
+		buf  *bytes.Writer
+	)
+	for i := range ps {
+		size += proto.RawHeaderSize + len(ps[i].Body)
+	}
+	buf = bytes.NewWriterSize(size)
+	for i := range ps {
+		ps[i].WriteTo(buf)
+	}
+	p.Operation = define.OP_RAW
+	p.Body = buf.Buffer()
+}
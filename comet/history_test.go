@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"goim/libs/bufio"
+	"goim/libs/define"
+	"goim/libs/proto"
+	"testing"
+)
+
+func TestPackHistory(t *testing.T) {
+	var (
+		p    = &proto.Proto{Ver: 1, Operation: define.OP_ROOM_HISTORY, SeqId: 7, Body: []byte(`{"n":2}`)}
+		msgs = []*proto.RoomHistoryMsg{{Msg: []byte("a")}, {Msg: []byte("bc")}}
+	)
+	packHistory(p, msgs)
+	if p.Operation != define.OP_RAW {
+		t.Fatalf("op: %d", p.Operation)
+	}
+	rr := bufio.NewReader(bytes.NewReader(p.Body))
+	for _, b := range []string{"a", "bc"} {
+		p1 := new(proto.Proto)
+		if err := p1.ReadTCP(rr); err != nil {
+			t.Fatal(err)
+		}
+		if p1.Operation != define.OP_ROOM_HISTORY_REPLY || p1.SeqId != 7 || string(p1.Body) != b {
+			t.Fatalf("proto: %v", p1)
+		}
+	}
+	// empty history
+	packHistory(p, nil)
+	if p.Operation != define.OP_ROOM_HISTORY_REPLY || p.Body != nil {
+		t.Fatalf("empty proto: %v", p)
+	}
+}
+
+func TestPackResend(t *testing.T) {
+	var (
+		p    = &proto.Proto{Ver: 1, Operation: define.OP_RESEND, Body: []byte(`{"since":3}`)}
+		msgs = []*proto.OutboxMsg{{Seq: 4, Msg: []byte("a")}, {Seq: 5, Msg: []byte("bc")}}
+	)
+	packResend(p, msgs)
+	rr := bufio.NewReader(bytes.NewReader(p.Body))
+	for i, b := range []string{"a", "bc"} {
+		p1 := new(proto.Proto)
+		if err := p1.ReadTCP(rr); err != nil {
+			t.Fatal(err)
+		}
+		if p1.Operation != define.OP_SEND_SMS_REPLY || p1.SeqId != int32(4+i) || string(p1.Body) != b {
+			t.Fatalf("proto: %v", p1)
+		}
+	}
+	packResend(p, nil)
+	if p.Operation != define.OP_RESEND_REPLY || p.Body != nil {
+		t.Fatalf("empty proto: %v", p)
+	}
+}
+
+func TestJoinHistoryFetches(t *testing.T) {
+	server := &Server{fetches: make(chan struct{}, 1)}
+	server.fetches <- struct{}{}
+	ch := NewChannel(1, 1)
+	ch.Room = NewRoom("1")
+	// the fetches are full, skipped without a logic rpc
+	server.joinHistory(ch, 20)
+	if len(server.fetches) != 1 {
+		t.Fatalf("fetches: %d", len(server.fetches))
+	}
+}
+
+func TestHistoryAsync(t *testing.T) {
+	server := &Server{fetches: make(chan struct{}, 1)}
+	ch := NewChannel(1, 1)
+	// not in a room, replied empty in place
+	p := &proto.Proto{Ver: 1, Operation: define.OP_ROOM_HISTORY, SeqId: 7}
+	if err := server.history(ch, p); err != nil || p.Operation != define.OP_ROOM_HISTORY_REPLY {
+		t.Fatalf("history() got %v %v", err, p)
+	}
+	// the fetches are full, replied empty in place
+	ch.Room = NewRoom("1")
+	server.fetches <- struct{}{}
+	p = &proto.Proto{Ver: 1, Operation: define.OP_ROOM_HISTORY, SeqId: 7, Body: []byte(`{"n":2}`)}
+	if err := server.history(ch, p); err != nil || p.Operation != define.OP_ROOM_HISTORY_REPLY || p.Body != nil {
+		t.Fatalf("history() full got %v %v", err, p)
+	}
+	if err := server.history(ch, &proto.Proto{Operation: define.OP_ROOM_HISTORY, Body: []byte("{")}); err == nil {
+		t.Error("history() bad body got nil error")
+	}
+	// a free token runs the fetch in a goroutine
+	<-server.fetches
+	done := make(chan struct{})
+	if !server.fetch(func() { close(done) }) {
+		t.Fatal("fetch() not run")
+	}
+	<-done
+}
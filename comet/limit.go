@@ -0,0 +1,113 @@
+package main
+
+import (
+	"goim/libs/ratelimit"
+	"net"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	// limit actions
+	limitPass  = 0
+	limitDrop  = 1
+	limitReply = 2
+	limitClose = 3
+)
+
+type LimitOptions struct {
+	ChannelRate     int // upstream messages per second per channel, 0 no limit
+	ChannelBurst    int
+	ChannelAction   string // drop, reply or close
+	IPRate          int    // new connections per second per ip, 0 no limit
+	IPBurst         int
+	HandshakeRate   int // handshakes per second per comet, 0 no limit
+	HandshakeBurst  int
+	HandshakeAction string // reply or close
+}
+
+// Limiter limit the upstream messages, the new connections and handshakes
+// by token buckets.
+type Limiter struct {
+	stat            *Stat
+	options         LimitOptions
+	channelAction   int
+	handshakeAction int
+	ips             *ratelimit.Buckets
+	handshake       *ratelimit.Bucket
+}
+
+// NewLimiter new a limiter, the limit of zero rate is disabled.
+func NewLimiter(st *Stat, options LimitOptions) *Limiter {
+	l := &Limiter{stat: st, options: options}
+	l.channelAction = limitAction(options.ChannelAction)
+	if l.handshakeAction = limitAction(options.HandshakeAction); l.handshakeAction == limitDrop {
+		// no proto to drop in handshake
+		l.handshakeAction = limitClose
+	}
+	if options.IPRate > 0 {
+		l.ips = ratelimit.NewBuckets(options.IPRate, options.IPBurst)
+	}
+	if options.HandshakeRate > 0 {
+		l.handshake = ratelimit.NewBucket(options.HandshakeRate, options.HandshakeBurst)
+	}
+	return l
+}
+
+func limitAction(action string) int {
+	switch action {
+	case "drop":
+		return limitDrop
+	case "reply":
+		return limitReply
+	case "close", "":
+		return limitClose
+	default:
+		log.Warn("unknown limit action: \"%s\", use close", action)
+		return limitClose
+	}
+}
+
+// Accept check the new connection limit of the remote ip, the connection
+// should be closed if not allowed.
+func (l *Limiter) Accept(addr net.Addr) bool {
+	if l.ips == nil {
+		return true
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if l.ips.Allow(ip) {
+		return true
+	}
+	l.stat.IncrLimitAccept()
+	return false
+}
+
+// Handshake check the handshake limit, return the action if not allowed.
+func (l *Limiter) Handshake() int {
+	if l.handshake == nil || l.handshake.Allow() {
+		return limitPass
+	}
+	l.stat.IncrLimitHandshake()
+	return l.handshakeAction
+}
+
+// NewChannel new a upstream message bucket for a channel, nil if no limit.
+func (l *Limiter) NewChannel() *ratelimit.Bucket {
+	if l.options.ChannelRate <= 0 {
+		return nil
+	}
+	return ratelimit.NewBucket(l.options.ChannelRate, l.options.ChannelBurst)
+}
+
+// Message check the upstream message limit of the channel, return the action
+// if not allowed.
+func (l *Limiter) Message(ch *Channel) int {
+	if ch.Limit == nil || ch.Limit.Allow() {
+		return limitPass
+	}
+	l.stat.IncrLimitMsg()
+	return l.channelAction
+}
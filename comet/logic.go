@@ -1,10 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
 	inet "goim/libs/net"
 	"goim/libs/net/xrpc"
 	"goim/libs/proto"
-	"strconv"
 	"time"
 
 	log "github.com/thinkboy/log4go"
@@ -18,6 +18,8 @@ var (
 	logicServicePing       = "RPC.Ping"
 	logicServiceConnect    = "RPC.Connect"
 	logicServiceDisconnect = "RPC.Disconnect"
+	logicServiceHistory    = "RPC.RoomHistory"
+	logicServiceResend     = "RPC.Resend"
 )
 
 func InitLogicRpc(addrs []string) (err error) {
@@ -25,15 +27,23 @@ func InitLogicRpc(addrs []string) (err error) {
 		bind          string
 		network, addr string
 		rpcOptions    []xrpc.ClientOptions
+		auth          = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret)
+		tlsCfg        *tls.Config
 	)
+	if tlsCfg, err = auth.ClientTLS(); err != nil {
+		log.Error("rpc auth ClientTLS() error(%v)", err)
+		return
+	}
 	for _, bind = range addrs {
 		if network, addr, err = inet.ParseNetwork(bind); err != nil {
 			log.Error("inet.ParseNetwork() error(%v)", err)
 			return
 		}
 		options := xrpc.ClientOptions{
-			Proto: network,
-			Addr:  addr,
+			Proto:  network,
+			Addr:   addr,
+			TLS:    tlsCfg,
+			Secret: auth.Secret,
 		}
 		rpcOptions = append(rpcOptions, options)
 	}
@@ -45,7 +55,7 @@ func InitLogicRpc(addrs []string) (err error) {
 	return
 }
 
-func connect(p *proto.Proto) (key string, rid int32, heartbeat time.Duration, err error) {
+func connect(p *proto.Proto) (key string, rid string, attrs map[string]string, heartbeat time.Duration, err error) {
 	var (
 		arg   = proto.ConnArg{Token: string(p.Body), Server: Conf.ServerId}
 		reply = proto.ConnReply{}
@@ -54,25 +64,58 @@ func connect(p *proto.Proto) (key string, rid int32, heartbeat time.Duration, er
 		guluLogger.Errorf("c.Call(\"%s\", \"%v\", &ret) error(%v)", logicServiceConnect, arg, err)
 		return
 	}
+	if reply.RoomFull {
+		err = ErrRoomFull
+		return
+	}
 
 	key = reply.Key
 	rid = reply.RoomId
-	guluLogger.Debug("connected! key is :" + key + "roomId is :" + strconv.Itoa(int(reply.RoomId)))
+	attrs = reply.Attrs
+	guluLogger.Debug("connected! key is :" + key + "roomId is :" + reply.RoomId)
 	//heartbeat = 1 * 60 * time.Second
 	heartbeat = 24 * 60 * 60 * time.Second //TODO:心跳时间改成24小时
 	return
 }
 
-func disconnect(key string, roomId int32) (has bool, err error) {
+func disconnect(key string, roomId string) (has bool, err error) {
 	var (
-		arg   = proto.DisconnArg{Key: key, RoomId: roomId}
+		arg   = proto.DisconnArg{Key: key, RoomId: roomId, Server: Conf.ServerId}
 		reply = proto.DisconnReply{}
 	)
 	if err = logicRpcClient.Call(logicServiceDisconnect, &arg, &reply); err != nil {
 		guluLogger.Errorf("c.Call(\"%s\", \"%v\", &ret) error(%v)", logicServiceConnect, arg, err)
 		return
 	}
-	guluLogger.Debug("disconnect! key is :" + key + "roomId is :" + strconv.Itoa(int(roomId)))
+	guluLogger.Debug("disconnect! key is :" + key + "roomId is :" + roomId)
 	has = reply.Has
 	return
 }
+
+// roomHistory get the latest messages of the room read by logic from the jobs.
+func roomHistory(roomId string, n int, since int64) (msgs []*proto.RoomHistoryMsg, err error) {
+	var (
+		arg   = proto.RoomHistoryArg{RoomId: roomId, N: n, Since: since}
+		reply = proto.RoomHistoryReply{}
+	)
+	if err = logicRpcClient.Call(logicServiceHistory, &arg, &reply); err != nil {
+		guluLogger.Errorf("c.Call(\"%s\", \"%v\", &ret) error(%v)", logicServiceHistory, arg, err)
+		return
+	}
+	msgs = reply.Msgs
+	return
+}
+
+// resendMsgs get the messages of the key's user after the message seq.
+func resendMsgs(key string, since int32) (msgs []*proto.OutboxMsg, err error) {
+	var (
+		arg   = proto.ResendArg{Key: key, Since: since}
+		reply = proto.ResendReply{}
+	)
+	if err = logicRpcClient.Call(logicServiceResend, &arg, &reply); err != nil {
+		guluLogger.Errorf("c.Call(\"%s\", \"%v\", &ret) error(%v)", logicServiceResend, arg, err)
+		return
+	}
+	msgs = reply.Msgs
+	return
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"goim/libs/perf"
+	"goim/libs/trace"
 	"infrastructure/loggingclient"
 	"runtime"
 
@@ -45,13 +46,21 @@ func main() {
 		DefaultWhitelist = wl
 	}
 	perf.Init(Conf.PprofBind)
+	trace.Init(trace.Options{
+		Service:  "goim-comet",
+		Sample:   Conf.TraceSample,
+		URL:      Conf.TraceURL,
+		Batch:    Conf.TraceBatch,
+		Interval: Conf.TraceInterval,
+		Queue:    Conf.TraceQueue,
+	})
 	// logic rpc
 	if err := InitLogicRpc(Conf.LogicAddrs); err != nil {
 		panic(err)
 	}
 	// start monitor
 	if Conf.MonitorOpen {
-		InitMonitor(Conf.MonitorAddrs)
+		InitMonitor(Conf.MonitorAddrs, Conf.MonitorAdmin, Conf.MonitorAdminKey)
 	}
 	// new stat
 	stat := NewStat()
@@ -63,6 +72,7 @@ func main() {
 			RoomSize:      Conf.BucketRoom,
 			RoutineAmount: Conf.RoutineAmount,
 			RoutineSize:   Conf.RoutineSize,
+			TopicMax:      Conf.BucketTopicMax,
 		})
 	}
 	round := NewRound(RoundOptions{
@@ -75,20 +85,53 @@ func main() {
 		Timer:        Conf.Timer,
 		TimerSize:    Conf.TimerSize,
 	})
+	InitFrame(Conf.FrameBuf, Conf.FrameBufSize)
 	operator := new(DefaultOperator)
 	DefaultServer = NewServer(stat, buckets, round, operator, ServerOptions{
 		CliProto:         Conf.CliProto,
 		SvrProto:         Conf.SvrProto,
 		HandshakeTimeout: Conf.HandshakeTimeout,
+		WriteTimeout:     Conf.WriteTimeout,
+		WriteCoalesce:    Conf.WriteCoalesce,
 		TCPKeepalive:     Conf.TCPKeepalive,
 		TCPRcvbuf:        Conf.TCPRcvbuf,
 		TCPSndbuf:        Conf.TCPSndbuf,
+		RoomHistory:      Conf.LogicRoomHistory,
+		RoomHistoryFetch: Conf.LogicRoomHistoryFetch,
+		Epoll: EpollOptions{
+			Open:    Conf.EpollOpen,
+			Num:     Conf.EpollNum,
+			Workers: Conf.EpollWorkers,
+			Queue:   Conf.EpollQueue,
+		},
+		Limit: LimitOptions{
+			ChannelRate:     Conf.LimitChannelRate,
+			ChannelBurst:    Conf.LimitChannelBurst,
+			ChannelAction:   Conf.LimitChannelAction,
+			IPRate:          Conf.LimitIPRate,
+			IPBurst:         Conf.LimitIPBurst,
+			HandshakeRate:   Conf.LimitHandshakeRate,
+			HandshakeBurst:  Conf.LimitHandshakeBurst,
+			HandshakeAction: Conf.LimitHandshakeAction,
+		},
 	})
 	// white list
 	// tcp comet
 	if err := InitTCP(Conf.TCPBind, Conf.MaxProc); err != nil {
 		panic(err)
 	}
+	// tcp tls comet
+	if Conf.TCPTLSOpen {
+		if err := InitTCPWithTLS(Conf.TCPTLSBind, Conf.TCPCertFile, Conf.TCPPrivateFile, Conf.MaxProc); err != nil {
+			panic(err)
+		}
+	}
+	// tcp secure comet
+	if Conf.TCPSecureOpen {
+		if err := InitTCPWithSecure(Conf.TCPSecureBind, Conf.TCPSecurePrivateFile, Conf.MaxProc); err != nil {
+			panic(err)
+		}
+	}
 	// websocket comet
 	if err := InitWebsocket(Conf.WebsocketBind, Conf.MaxProc); err != nil {
 		panic(err)
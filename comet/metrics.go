@@ -0,0 +1,71 @@
+package main
+
+import (
+	"goim/libs/metrics"
+	"time"
+)
+
+var (
+	onlineGauge  = metrics.NewGaugeVec("goim_comet_online", "online connections by protocol.", "proto")
+	pushCounter  = metrics.NewCounterVec("goim_comet_push_total", "pushed messages by type.", "type")
+	pushDuration = metrics.NewHistogramVec("goim_comet_push_duration_seconds", "push rpc latency by type.", nil, "type")
+	dropCounter  = metrics.NewCounterVec("goim_comet_drop_total", "dropped protos by reason.", "reason")
+	limitCounter = metrics.NewCounterVec("goim_comet_limit_total", "rate limited by kind.", "kind")
+	blockedVec   = metrics.NewCounterVec("goim_comet_room_blocked_total", "room broadcasts waited for a full bucket routine.")
+	overflowVec  = metrics.NewCounterVec("goim_comet_epoll_overflow_total", "epoll tasks over the full queue, the reads wait and the writes are backlogged.")
+	stalledVec   = metrics.NewCounterVec("goim_comet_write_stalled_total", "connections closed for the write deadline by protocol.", "proto")
+
+	tcpOnline       = onlineGauge.WithLabelValues("tcp")
+	wsOnline        = onlineGauge.WithLabelValues("websocket")
+	channelDrop     = dropCounter.WithLabelValues("channel_full")
+	ringDrop        = dropCounter.WithLabelValues("ring_full")
+	expiredDrop     = dropCounter.WithLabelValues("expired")
+	limitAccept     = limitCounter.WithLabelValues("accept")
+	limitHandshake  = limitCounter.WithLabelValues("handshake")
+	limitMsg        = limitCounter.WithLabelValues("message")
+	limitHistory    = limitCounter.WithLabelValues("room_history")
+	roomBlocked     = blockedVec.WithLabelValues()
+	pollOverflow    = overflowVec.WithLabelValues()
+	tcpStalled      = stalledVec.WithLabelValues("tcp")
+	wsStalled       = stalledVec.WithLabelValues("websocket")
+	metricPush      = "push"
+	metricBroadcast = "broadcast"
+	metricRoom      = "broadcast_room"
+	metricTopic     = "broadcast_topic"
+)
+
+func init() {
+	metrics.MustRegister(onlineGauge, pushCounter, pushDuration, dropCounter, limitCounter, blockedVec, overflowVec, stalledVec,
+		metrics.NewGaugeFunc("goim_comet_channels", "channels in all buckets.", func() float64 {
+			return float64(bucketsCount(func(b *Bucket) int { return b.ChannelCount() }))
+		}),
+		metrics.NewGaugeFunc("goim_comet_rooms", "rooms in all buckets.", func() float64 {
+			return float64(bucketsCount(func(b *Bucket) int { return b.RoomCount() }))
+		}),
+		metrics.NewGaugeFunc("goim_comet_polled", "connections served by epoll.", func() float64 {
+			if DefaultServer == nil || DefaultServer.poller == nil {
+				return 0
+			}
+			return float64(DefaultServer.poller.Len())
+		}),
+		metrics.NewGaugeFunc("goim_comet_topics", "topics in all buckets.", func() float64 {
+			return float64(bucketsCount(func(b *Bucket) int { return b.TopicCount() }))
+		}),
+	)
+}
+
+// bucketsCount sum the count of all buckets, 0 if server not started.
+func bucketsCount(f func(*Bucket) int) (n int) {
+	if DefaultServer == nil {
+		return
+	}
+	for _, b := range DefaultServer.Buckets {
+		n += f(b)
+	}
+	return
+}
+
+// observePush observe the push rpc latency since start.
+func observePush(typ string, start time.Time) {
+	pushDuration.WithLabelValues(typ).Observe(time.Since(start).Seconds())
+}
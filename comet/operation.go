@@ -11,15 +11,31 @@ import (
 type Operator interface {
 	// Operate process the common operation such as send message etc.
 	Operate(*proto.Proto) error
-	// Connect used for auth user and return a subkey, roomid, hearbeat.
-	Connect(*proto.Proto) (string, int32, time.Duration, error)
+	// Connect used for auth user and return a subkey, roomid, attributes,
+	// hearbeat.
+	Connect(*proto.Proto) (string, string, map[string]string, time.Duration, error)
 	// Disconnect used for revoke the subkey.
-	Disconnect(string, int32) error
+	Disconnect(string, string) error
 }
 
 type DefaultOperator struct {
 }
 
+// operate process the topic, room history and resend operations in comet,
+// or the common operations by the operator. errAsyncReply means the reply
+// is pushed later, the proto is not replied.
+func (server *Server) operate(b *Bucket, ch *Channel, p *proto.Proto) error {
+	switch p.Operation {
+	case define.OP_SUB, define.OP_UNSUB:
+		return server.topic(b, ch, p)
+	case define.OP_ROOM_HISTORY:
+		return server.history(ch, p)
+	case define.OP_RESEND:
+		return server.resend(ch, p)
+	}
+	return server.operator.Operate(p)
+}
+
 func (operator *DefaultOperator) Operate(p *proto.Proto) error {
 	var (
 		body []byte
@@ -39,12 +55,12 @@ func (operator *DefaultOperator) Operate(p *proto.Proto) error {
 	return nil
 }
 
-func (operator *DefaultOperator) Connect(p *proto.Proto) (key string, rid int32, heartbeat time.Duration, err error) {
-	key, rid, heartbeat, err = connect(p)
+func (operator *DefaultOperator) Connect(p *proto.Proto) (key string, rid string, attrs map[string]string, heartbeat time.Duration, err error) {
+	key, rid, attrs, heartbeat, err = connect(p)
 	return
 }
 
-func (operator *DefaultOperator) Disconnect(key string, rid int32) (err error) {
+func (operator *DefaultOperator) Disconnect(key string, rid string) (err error) {
 	var has bool
 	if has, err = disconnect(key, rid); err != nil {
 		return
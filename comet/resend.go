@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/define"
+	"goim/libs/proto"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// resendArg is the json body of OP_RESEND.
+type resendArg struct {
+	Since int32 `json:"since"` // the messages after the seq
+}
+
+// resend reply the kept messages of the user after the seq to OP_RESEND,
+// they are packed as the pushed OP_SEND_SMS_REPLY protos with their seqs in
+// a OP_RAW proto, none replies an empty OP_RESEND_REPLY.
+func (server *Server) resend(ch *Channel, p *proto.Proto) (err error) {
+	var (
+		arg  resendArg
+		msgs []*proto.OutboxMsg
+	)
+	if len(p.Body) > 0 {
+		if err = json.Unmarshal(p.Body, &arg); err != nil {
+			return
+		}
+	}
+	// the resend is optional, fails as none
+	if msgs, err = resendMsgs(ch.Key, arg.Since); err != nil {
+		log.Error("key: %s resendMsgs(%d) error(%v)", ch.Key, arg.Since, err)
+		err = nil
+	}
+	packResend(p, msgs)
+	return
+}
+
+func packResend(p *proto.Proto, msgs []*proto.OutboxMsg) {
+	ps := make([]proto.Proto, len(msgs))
+	for i, m := range msgs {
+		ps[i] = proto.Proto{Ver: p.Ver, Operation: define.OP_SEND_SMS_REPLY, SeqId: m.Seq, Body: m.Msg}
+	}
+	packRaw(p, ps, define.OP_RESEND_REPLY)
+}
@@ -58,6 +58,7 @@ func (r *Ring) GetAdv() {
 
 func (r *Ring) Set() (proto *proto.Proto, err error) {
 	if r.wp-r.rp >= r.num {
+		ringDrop.Inc()
 		return nil, ErrRingFull
 	}
 	proto = &r.data[r.wp&r.mask]
@@ -3,36 +3,90 @@ package main
 import (
 	"goim/libs/proto"
 	"sync"
+	"sync/atomic"
 )
 
+const (
+	// the max channels of a room shard, a join or leave copies one shard
+	roomShardSize = 256
+)
+
+// roomShard is a copy on write slice of the room channels.
+type roomShard struct {
+	members atomic.Value // []*Channel
+}
+
+func (s *roomShard) load() []*Channel {
+	return s.members.Load().([]*Channel)
+}
+
+// roomPos is the position of a channel in the room shards.
+type roomPos struct {
+	shard int
+	i     int
+}
+
+// Room is the channels of a room in a bucket. the members are split into
+// the copy on write shards of at most roomShardSize, the pushes iterate the
+// snapshots without lock, the joins and leaves are serialized and copy the
+// changed shard only.
 type Room struct {
-	Id     int32
-	rLock  sync.RWMutex
-	next   *Channel
+	Id     string
+	rLock  sync.Mutex   // protect the writes of shards, index and open
+	shards atomic.Value // []*roomShard, only appended
+	index  map[*Channel]roomPos
+	open   []int // the shards not full
 	drop   bool
 	Online int // dirty read is ok
 }
 
 // NewRoom new a room struct, store channel room info.
-func NewRoom(id int32) (r *Room) {
+func NewRoom(id string) (r *Room) {
 	r = new(Room)
 	r.Id = id
 	r.drop = false
-	r.next = nil
+	r.index = make(map[*Channel]roomPos)
+	r.shards.Store([]*roomShard(nil))
 	r.Online = 0
 	return
 }
 
+func (r *Room) loadShards() []*roomShard {
+	return r.shards.Load().([]*roomShard)
+}
+
+// Members get a snapshot of the room channels.
+func (r *Room) Members() (chs []*Channel) {
+	for _, s := range r.loadShards() {
+		chs = append(chs, s.load()...)
+	}
+	return
+}
+
 // Put put channel into the room.
 func (r *Room) Put(ch *Channel) (err error) {
 	r.rLock.Lock()
 	if !r.drop {
-		if r.next != nil {
-			r.next.Prev = ch
+		shards := r.loadShards()
+		if len(r.open) == 0 {
+			s := new(roomShard)
+			s.members.Store([]*Channel(nil))
+			grown := make([]*roomShard, len(shards), len(shards)+1)
+			copy(grown, shards)
+			r.open = append(r.open, len(shards))
+			shards = append(grown, s)
+			r.shards.Store(shards)
+		}
+		n := r.open[len(r.open)-1]
+		s := shards[n]
+		old := s.load()
+		chs := make([]*Channel, len(old), len(old)+1)
+		copy(chs, old)
+		r.index[ch] = roomPos{shard: n, i: len(chs)}
+		s.members.Store(append(chs, ch))
+		if len(chs)+1 == roomShardSize {
+			r.open = r.open[:len(r.open)-1]
 		}
-		ch.Next = r.next
-		ch.Prev = nil
-		r.next = ch // insert to header
 		r.Online++
 	} else {
 		err = ErrRoomDroped
@@ -44,17 +98,23 @@ func (r *Room) Put(ch *Channel) (err error) {
 // Del delete channel from the room.
 func (r *Room) Del(ch *Channel) bool {
 	r.rLock.Lock()
-	if ch.Next != nil {
-		// if not footer
-		ch.Next.Prev = ch.Prev
-	}
-	if ch.Prev != nil {
-		// if not header
-		ch.Prev.Next = ch.Next
-	} else {
-		r.next = ch.Next
+	if pos, ok := r.index[ch]; ok {
+		s := r.loadShards()[pos.shard]
+		old := s.load()
+		chs := make([]*Channel, len(old)-1)
+		copy(chs, old[:len(old)-1])
+		if last := old[len(old)-1]; last != ch {
+			// move the last to the hole
+			chs[pos.i] = last
+			r.index[last] = pos
+		}
+		delete(r.index, ch)
+		s.members.Store(chs)
+		if len(old) == roomShardSize {
+			r.open = append(r.open, pos.shard)
+		}
+		r.Online--
 	}
-	r.Online--
 	r.drop = (r.Online == 0)
 	r.rLock.Unlock()
 	return r.drop
@@ -62,19 +122,17 @@ func (r *Room) Del(ch *Channel) bool {
 
 // Push push msg to the room, if chan full discard it.
 func (r *Room) Push(p *proto.Proto) {
-	r.rLock.RLock()
-	for ch := r.next; ch != nil; ch = ch.Next {
-		ch.Push(p)
+	for _, s := range r.loadShards() {
+		for _, ch := range s.load() {
+			ch.Push(p)
+		}
 	}
-	r.rLock.RUnlock()
 	return
 }
 
 // Close close the room.
 func (r *Room) Close() {
-	r.rLock.RLock()
-	for ch := r.next; ch != nil; ch = ch.Next {
+	for _, ch := range r.Members() {
 		ch.Close()
 	}
-	r.rLock.RUnlock()
 }
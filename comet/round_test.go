@@ -5,7 +5,7 @@ import (
 )
 
 func TestRound(t *testing.T) {
-	r := NewRound(10, 10, 2, 10)
+	r := NewRound(RoundOptions{Reader: 10, ReadBuf: 10, ReadBufSize: 10, Writer: 10, WriteBuf: 10, WriteBufSize: 10, Timer: 2, TimerSize: 10})
 	t0 := r.Timer(0)
 	if t0 == nil {
 		t.FailNow()
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"goim/libs/filter"
 	inet "goim/libs/net"
+	"goim/libs/net/xrpc"
 	"goim/libs/proto"
+	"goim/libs/trace"
 	"net"
 	"net/rpc"
+	"sync/atomic"
+	"time"
 )
 
 func InitRPCPush(addrs []string) (err error) {
 	var (
 		bind          string
 		network, addr string
+		options       xrpc.ServerOptions
 		c             = &PushRPC{}
 	)
+	if options, err = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret).ServerOptions(); err != nil {
+		guluLogger.Errorf("rpc auth ServerOptions() error(%v)", err)
+		return
+	}
 	rpc.Register(c)
 	for _, bind = range addrs {
 		if network, addr, err = inet.ParseNetwork(bind); err != nil {
@@ -20,12 +30,12 @@ func InitRPCPush(addrs []string) (err error) {
 			return
 		}
 		guluLogger.Infof("start rpc listen: \"%s\"", bind)
-		go rpcListen(network, addr)
+		go rpcListen(network, addr, options)
 	}
 	return
 }
 
-func rpcListen(network, addr string) {
+func rpcListen(network, addr string, options xrpc.ServerOptions) {
 	l, err := net.Listen(network, addr)
 	if err != nil {
 		guluLogger.Errorf("net.Listen(\"%s\", \"%s\") error(%v)", network, addr, err)
@@ -38,7 +48,7 @@ func rpcListen(network, addr string) {
 			guluLogger.Errorf("listener.Close() error(%v)", err)
 		}
 	}()
-	rpc.Accept(l)
+	xrpc.Accept(l, options)
 }
 
 // Push RPC
@@ -55,6 +65,7 @@ func (this *PushRPC) PushMsg(arg *proto.PushMsgArg, reply *proto.NoReply) (err e
 		bucket  *Bucket
 		channel *Channel
 	)
+	defer observePush(metricPush, time.Now())
 	if arg == nil {
 		err = ErrPushMsgArg
 		return
@@ -75,16 +86,29 @@ func (this *PushRPC) MPushMsg(arg *proto.MPushMsgArg, reply *proto.MPushMsgReply
 		channel *Channel
 		key     string
 		n       int
+		found   int
 	)
+	defer observePush(metricPush, time.Now())
 	reply.Index = -1
 	if arg == nil {
 		err = ErrMPushMsgArg
 		return
 	}
+	span := trace.Start(arg.TraceId, "comet.mpush")
+	defer span.Finish()
 	for n, key = range arg.Keys {
 		bucket = DefaultServer.Bucket(key)
 		if channel = bucket.Channel(key); channel != nil {
-			if err = channel.Push(&arg.P); err != nil {
+			found++
+			p := &arg.P
+			if len(arg.Seqs) == len(arg.Keys) {
+				// the message seq of the user
+				p = new(proto.Proto)
+				*p = arg.P
+				p.SeqId = arg.Seqs[n]
+			}
+			if err = channel.Push(p); err != nil {
+				span.Error(err)
 				return
 			}
 			reply.Index = int32(n)
@@ -92,6 +116,8 @@ func (this *PushRPC) MPushMsg(arg *proto.MPushMsgArg, reply *proto.MPushMsgReply
 			DefaultServer.Stat.IncrPushMsg()
 		}
 	}
+	span.Set("keys", len(arg.Keys))
+	span.Set("found", found)
 	return
 }
 
@@ -103,6 +129,7 @@ func (this *PushRPC) MPushMsgs(arg *proto.MPushMsgsArg, reply *proto.MPushMsgsRe
 		n       int32
 		PMArg   *proto.PushMsgArg
 	)
+	defer observePush(metricPush, time.Now())
 	reply.Index = -1
 	if arg == nil {
 		err = ErrMPushMsgsArg
@@ -125,10 +152,28 @@ func (this *PushRPC) MPushMsgs(arg *proto.MPushMsgsArg, reply *proto.MPushMsgsRe
 
 // Broadcast broadcast msg to all user.
 func (this *PushRPC) Broadcast(arg *proto.BoardcastArg, reply *proto.NoReply) (err error) {
-	var bucket *Bucket
+	var (
+		bucket *Bucket
+		f      *filter.Expr
+		frame  *proto.Frame
+	)
+	span := trace.Start(arg.TraceId, "comet.broadcast")
+	defer span.Finish()
+	if f, err = filter.Parse(arg.Filter); err != nil {
+		span.Error(err)
+		guluLogger.Errorf("filter.Parse(\"%s\") error(%v)", arg.Filter, err)
+		return
+	}
+	span.Set("filter", arg.Filter)
+	frame = shareFrame(&arg.P)
 	for _, bucket = range DefaultServer.Buckets {
-		go bucket.Broadcast(&arg.P)
+		frame.Ref()
+		go func(b *Bucket) {
+			b.Broadcast(&arg.P, f)
+			frame.Unref()
+		}(bucket)
 	}
+	frame.Unref()
 	// increase broadcast stat
 	DefaultServer.Stat.IncrBroadcastMsg()
 	return
@@ -136,20 +181,105 @@ func (this *PushRPC) Broadcast(arg *proto.BoardcastArg, reply *proto.NoReply) (e
 
 // Broadcast broadcast msg to specified room.
 func (this *PushRPC) BroadcastRoom(arg *proto.BoardcastRoomArg, reply *proto.NoReply) (err error) {
-	var bucket *Bucket
+	var (
+		bucket  *Bucket
+		blocked []*Bucket
+		frame   *proto.Frame
+		traceId string
+		spans   []*trace.Span
+	)
+	defer observePush(metricRoom, time.Now())
+	for _, traceId = range arg.TraceIds {
+		if span := trace.Start(traceId, "comet.broadcast_room"); span != nil {
+			span.Set("room", arg.RoomId)
+			spans = append(spans, span)
+		}
+	}
+	// only the buckets holding the room, a full bucket routine does not
+	// delay the others, the room routines release the frame
+	frame = shareFrame(&arg.P)
 	for _, bucket = range DefaultServer.Buckets {
-		bucket.BroadcastRoom(arg)
+		if bucket.Room(arg.RoomId) == nil {
+			continue
+		}
+		frame.Ref()
+		if !bucket.BroadcastRoom(arg, false) {
+			blocked = append(blocked, bucket)
+		}
+	}
+	for _, bucket = range blocked {
+		roomBlocked.Inc()
+		bucket.BroadcastRoom(arg, true)
+	}
+	frame.Unref()
+	for _, span := range spans {
+		span.Finish()
 	}
 	// increase broadcast stat
 	DefaultServer.Stat.IncrBroadcastRoomMsg()
 	return
 }
 
+// Stat get the online and speed stat.
+func (this *PushRPC) Stat(arg *proto.NoArg, reply *proto.CometStatReply) (err error) {
+	var (
+		bucket *Bucket
+		stat   = DefaultServer.Stat
+	)
+	reply.TcpOnline = atomic.LoadInt64(&stat.TcpOnline)
+	reply.WsOnline = atomic.LoadInt64(&stat.WsOnline)
+	reply.SpeedMsgSecond = stat.SpeedMsgSecond
+	for _, bucket = range DefaultServer.Buckets {
+		reply.Channels += bucket.ChannelCount()
+		reply.Rooms += bucket.RoomCount()
+	}
+	return
+}
+
+// BroadcastTopic broadcast msg to the channels subscribed the topic.
+func (this *PushRPC) BroadcastTopic(arg *proto.BoardcastTopicArg, reply *proto.NoReply) (err error) {
+	var (
+		bucket *Bucket
+		frame  *proto.Frame
+	)
+	defer observePush(metricTopic, time.Now())
+	span := trace.Start(arg.TraceId, "comet.broadcast_topic")
+	span.Set("topic", arg.Topic)
+	defer span.Finish()
+	frame = shareFrame(&arg.P)
+	for _, bucket = range DefaultServer.Buckets {
+		frame.Ref()
+		go func(b *Bucket) {
+			b.BroadcastTopic(arg.Topic, &arg.P)
+			frame.Unref()
+		}(bucket)
+	}
+	frame.Unref()
+	// increase broadcast stat
+	DefaultServer.Stat.IncrBroadcastTopicMsg()
+	return
+}
+
+// Topics get all subscribed topics.
+func (this *PushRPC) Topics(arg *proto.NoArg, reply *proto.TopicsReply) (err error) {
+	var (
+		topic  string
+		bucket *Bucket
+	)
+	reply.Topics = make(map[string]struct{})
+	for _, bucket = range DefaultServer.Buckets {
+		for topic = range bucket.Topics() {
+			reply.Topics[topic] = struct{}{}
+		}
+	}
+	return
+}
+
 func (this *PushRPC) Rooms(arg *proto.NoArg, reply *proto.RoomsReply) (err error) {
 	var (
-		roomId  int32
+		roomId  string
 		bucket  *Bucket
-		roomIds = make(map[int32]struct{})
+		roomIds = make(map[string]struct{})
 	)
 	for _, bucket = range DefaultServer.Buckets {
 		for roomId, _ = range bucket.Rooms() {
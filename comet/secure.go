@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/rsa"
+	"goim/libs/bufio"
+	"goim/libs/crypto/aes"
+	irsa "goim/libs/crypto/rsa"
+	"goim/libs/define"
+	"goim/libs/encoding/binary"
+	"goim/libs/proto"
+	"io/ioutil"
+	"net"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	// aes-256-gcm session key
+	secureKeySize = 32
+)
+
+var (
+	securePrivateKey *rsa.PrivateKey // secure listener rsa private key
+)
+
+// Secure is a channel secure session, the proto bodies in both directions are
+// sealed by aes-gcm with the session key negotiated in OP_AUTH.
+//
+// the OP_AUTH body is: rsa(pkcs1v15) encrypted session key (the rsa key size
+// bytes) | nonce(12) | aes-gcm sealed token. every body after that is:
+// nonce(12) | aes-gcm sealed body, empty body is not sealed.
+type Secure struct {
+	aead cipher.AEAD
+}
+
+// NewSecure decrypt the session key and the token from the OP_AUTH body.
+func NewSecure(pri *rsa.PrivateKey, body []byte) (s *Secure, token []byte, err error) {
+	var (
+		k   = (pri.N.BitLen() + 7) / 8
+		key = make([]byte, secureKeySize)
+	)
+	if len(body) <= k {
+		err = ErrSecureAuth
+		return
+	}
+	// a bad padding leaves a random key, then the token can't be opened, so
+	// no difference is leaked to the client
+	if err = irsa.DecryptSessionKey(body[:k], pri, key); err != nil {
+		return
+	}
+	s = new(Secure)
+	if s.aead, err = aes.NewGCM(key); err != nil {
+		return
+	}
+	if token, err = s.Decrypt(body[k:]); err != nil {
+		err = ErrSecureAuth
+	}
+	return
+}
+
+// Decrypt open a client proto body in place.
+func (s *Secure) Decrypt(body []byte) ([]byte, error) {
+	return aes.GCMDecrypt(s.aead, body)
+}
+
+// Encrypt seal a server proto body.
+func (s *Secure) Encrypt(body []byte) ([]byte, error) {
+	return aes.GCMEncrypt(s.aead, body)
+}
+
+// WriteTCP write the proto with the body sealed, a OP_RAW proto concats many
+// packs in the body, each of them is sealed and written.
+func (s *Secure) WriteTCP(wr *bufio.Writer, p *proto.Proto) (err error) {
+	var (
+		packLen   int32
+		headerLen int16
+		buf       = p.Body
+		p1        proto.Proto
+	)
+	if p.Operation != define.OP_RAW {
+		return s.writeTCP(wr, p)
+	}
+	for len(buf) >= proto.RawHeaderSize {
+		packLen = binary.BigEndian.Int32(buf[proto.PackOffset:proto.HeaderOffset])
+		headerLen = binary.BigEndian.Int16(buf[proto.HeaderOffset:proto.VerOffset])
+		if packLen < int32(headerLen) || int(packLen) > len(buf) {
+			return proto.ErrProtoPackLen
+		}
+		p1.Ver = binary.BigEndian.Int16(buf[proto.VerOffset:proto.OperationOffset])
+		p1.Operation = binary.BigEndian.Int32(buf[proto.OperationOffset:proto.SeqIdOffset])
+		p1.SeqId = binary.BigEndian.Int32(buf[proto.SeqIdOffset:])
+		p1.Body = buf[headerLen:packLen]
+		if err = s.writeTCP(wr, &p1); err != nil {
+			return
+		}
+		buf = buf[packLen:]
+	}
+	return
+}
+
+func (s *Secure) writeTCP(wr *bufio.Writer, p *proto.Proto) (err error) {
+	var (
+		p1   = *p
+		body []byte
+	)
+	if len(p.Body) > 0 {
+		if body, err = s.Encrypt(p.Body); err != nil {
+			return
+		}
+		p1.Body = body
+	}
+	return p1.WriteTCP(wr)
+}
+
+// writeTCP write the proto to the channel, the body is sealed if the channel
+// is in a secure session, the shared frame is written as it is otherwise.
+func writeTCP(ch *Channel, wr *bufio.Writer, p *proto.Proto) (err error) {
+	if ch.Secure != nil {
+		return ch.Secure.WriteTCP(wr, p)
+	}
+	if f := p.Frame(); f != nil {
+		_, err = wr.WriteRaw(f.TCP())
+		return
+	}
+	return p.WriteTCP(wr)
+}
+
+// InitTCPWithSecure listen all tcp secure.bind and start accept connections,
+// the clients must do the secure handshake in OP_AUTH.
+func InitTCPWithSecure(addrs []string, privateFile string, accept int) (err error) {
+	var (
+		bind     string
+		listener *net.TCPListener
+		addr     *net.TCPAddr
+		pem      []byte
+	)
+	if pem, err = ioutil.ReadFile(privateFile); err != nil {
+		log.Error("ioutil.ReadFile(\"%s\") error(%v)", privateFile, err)
+		return
+	}
+	if securePrivateKey, err = irsa.PrivateKey(pem); err != nil {
+		log.Error("rsa.PrivateKey(\"%s\") error(%v)", privateFile, err)
+		return
+	}
+	for _, bind = range addrs {
+		if addr, err = net.ResolveTCPAddr("tcp4", bind); err != nil {
+			log.Error("net.ResolveTCPAddr(\"tcp4\", \"%s\") error(%v)", bind, err)
+			return
+		}
+		if listener, err = net.ListenTCP("tcp4", addr); err != nil {
+			log.Error("net.ListenTCP(\"tcp4\", \"%s\") error(%v)", bind, err)
+			return
+		}
+		log.Info("start tcp secure listen: \"%s\"", bind)
+		// split N core accept
+		for i := 0; i < accept; i++ {
+			go acceptTCP(DefaultServer, listener, nil, securePrivateKey)
+		}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"goim/libs/bufio"
+	ibytes "goim/libs/bytes"
+	"goim/libs/crypto/aes"
+	"goim/libs/define"
+	"goim/libs/proto"
+	"testing"
+)
+
+func TestSecure(t *testing.T) {
+	pri, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// client side handshake
+	key := make([]byte, secureKeySize)
+	rand.Read(key)
+	ek, err := rsa.EncryptPKCS1v15(rand.Reader, &pri.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := aes.NewGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	et, err := aes.GCMEncrypt(aead, []byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, token, err := NewSecure(pri, append(ek, et...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(token) != "token" {
+		t.Fatalf("token: %s", token)
+	}
+	// bad token
+	et[len(et)-1] ^= 1
+	if _, _, err = NewSecure(pri, append(ek, et...)); err != ErrSecureAuth {
+		t.Fatalf("bad token error(%v)", err)
+	}
+	// server write a raw proto concat two packs, the client read them sealed
+	var (
+		raw = ibytes.NewWriterSize(64)
+		buf = new(bytes.Buffer)
+		wr  = bufio.NewWriter(buf)
+		p   = &proto.Proto{Ver: 1, Operation: define.OP_SEND_SMS_REPLY, Body: []byte("a")}
+	)
+	p.WriteTo(raw)
+	p.Body = []byte("bc")
+	p.WriteTo(raw)
+	if err = s.WriteTCP(wr, &proto.Proto{Operation: define.OP_RAW, Body: raw.Buffer()}); err != nil {
+		t.Fatal(err)
+	}
+	wr.Flush()
+	rr := bufio.NewReader(buf)
+	for _, b := range []string{"a", "bc"} {
+		if err = p.ReadTCP(rr); err != nil {
+			t.Fatal(err)
+		}
+		if p.Operation != define.OP_SEND_SMS_REPLY {
+			t.Fatalf("op: %d", p.Operation)
+		}
+		if p.Body, err = aes.GCMDecrypt(aead, p.Body); err != nil {
+			t.Fatal(err)
+		}
+		if string(p.Body) != b {
+			t.Fatalf("body: %s", p.Body)
+		}
+	}
+}
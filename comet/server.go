@@ -3,6 +3,8 @@ package main
 import (
 	"goim/libs/hash/cityhash"
 	"time"
+
+	log "github.com/thinkboy/log4go"
 )
 
 var (
@@ -14,9 +16,22 @@ type ServerOptions struct {
 	CliProto         int
 	SvrProto         int
 	HandshakeTimeout time.Duration
+	WriteTimeout     time.Duration // the write deadline of each flush
+	WriteCoalesce    time.Duration // the flush delay at most to coalesce the queued protos
 	TCPKeepalive     bool
 	TCPRcvbuf        int
 	TCPSndbuf        int
+	Limit            LimitOptions
+	RoomHistory      int // messages of the room history pushed after joined
+	RoomHistoryFetch int // the concurrent fetches of the joined room history
+	Epoll            EpollOptions
+}
+
+type EpollOptions struct {
+	Open    bool
+	Num     int // epoll instances
+	Workers int
+	Queue   int // the tasks queue size
 }
 
 type Server struct {
@@ -25,6 +40,9 @@ type Server struct {
 	bucketIdx uint32
 	round     *Round // accept round store
 	operator  Operator
+	limiter   *Limiter
+	poller    *Poller       // serve the connections after the handshake, nil if not
+	fetches   chan struct{} // the tokens of the room history fetches
 	Options   ServerOptions
 }
 
@@ -36,7 +54,18 @@ func NewServer(st *Stat, b []*Bucket, r *Round, o Operator, options ServerOption
 	s.bucketIdx = uint32(len(b))
 	s.round = r
 	s.operator = o
+	s.limiter = NewLimiter(st, options.Limit)
 	s.Options = options
+	if options.RoomHistoryFetch > 0 {
+		s.fetches = make(chan struct{}, options.RoomHistoryFetch)
+	}
+	if options.Epoll.Open {
+		var err error
+		if s.poller, err = NewPoller(options.Epoll.Num, options.Epoll.Workers, options.Epoll.Queue); err != nil {
+			log.Error("NewPoller() error(%v), serve the connections by goroutines", err)
+			s.poller = nil
+		}
+	}
 	return s
 }
 
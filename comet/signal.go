@@ -33,4 +33,5 @@ func reload() {
 		return
 	}
 	Conf = newConf
+	reloadCertificates()
 }
@@ -10,12 +10,17 @@ type Stat struct {
 	TcpOnline int64 `json:"tcp_online"`
 	WsOnline  int64 `json:"websocket_online"`
 	// messages
-	AllMsg           uint64 `json:"all_msg"`
-	PushMsg          uint64 `json:"push_msg"`
-	BroadcastMsg     uint64 `json:"broadcast_msg"`
-	BroadcastRoomMsg uint64 `json:"broadcast_room_msg"`
+	AllMsg            uint64 `json:"all_msg"`
+	PushMsg           uint64 `json:"push_msg"`
+	BroadcastMsg      uint64 `json:"broadcast_msg"`
+	BroadcastRoomMsg  uint64 `json:"broadcast_room_msg"`
+	BroadcastTopicMsg uint64 `json:"broadcast_topic_msg"`
 	// speed
 	SpeedMsgSecond uint64 `json:"speed_msg_second"`
+	// limit
+	LimitAccept    uint64 `json:"limit_accept"`
+	LimitHandshake uint64 `json:"limit_handshake"`
+	LimitMsg       uint64 `json:"limit_msg"`
 	// buckets
 	BucketChannels map[int]int `json:"bucket_channels"`
 	BucketRooms    map[int]int `json:"bucket_rooms"`
@@ -33,8 +38,8 @@ func (s *Stat) Info() *Stat {
 		bucket *Bucket
 	)
 	for idx, bucket = range DefaultServer.Buckets {
-		s.BucketChannels[idx] = bucket.RoomCount()
-		s.BucketRooms[idx] = bucket.ChannelCount()
+		s.BucketChannels[idx] = bucket.ChannelCount()
+		s.BucketRooms[idx] = bucket.RoomCount()
 	}
 	return s
 }
@@ -46,6 +51,10 @@ func (s *Stat) Reset() {
 	atomic.StoreUint64(&s.PushMsg, 0)
 	atomic.StoreUint64(&s.BroadcastMsg, 0)
 	atomic.StoreUint64(&s.BroadcastRoomMsg, 0)
+	atomic.StoreUint64(&s.BroadcastTopicMsg, 0)
+	atomic.StoreUint64(&s.LimitAccept, 0)
+	atomic.StoreUint64(&s.LimitHandshake, 0)
+	atomic.StoreUint64(&s.LimitMsg, 0)
 }
 
 func (s *Stat) procSpeed() {
@@ -62,31 +71,59 @@ func (s *Stat) procSpeed() {
 
 func (s *Stat) IncrTcpOnline() {
 	atomic.AddInt64(&s.TcpOnline, 1)
+	tcpOnline.Inc()
 }
 
 func (s *Stat) DecrTcpOnline() {
 	atomic.AddInt64(&s.TcpOnline, -1)
+	tcpOnline.Dec()
 }
 
 func (s *Stat) IncrWsOnline() {
 	atomic.AddInt64(&s.WsOnline, 1)
+	wsOnline.Inc()
 }
 
 func (s *Stat) DecrWsOnline() {
 	atomic.AddInt64(&s.WsOnline, -1)
+	wsOnline.Dec()
 }
 
 func (s *Stat) IncrPushMsg() {
 	atomic.AddUint64(&s.PushMsg, 1)
 	atomic.AddUint64(&s.AllMsg, 1)
+	pushCounter.WithLabelValues(metricPush).Inc()
 }
 
 func (s *Stat) IncrBroadcastMsg() {
 	atomic.AddUint64(&s.BroadcastMsg, 1)
 	atomic.AddUint64(&s.AllMsg, 1)
+	pushCounter.WithLabelValues(metricBroadcast).Inc()
 }
 
 func (s *Stat) IncrBroadcastRoomMsg() {
 	atomic.AddUint64(&s.BroadcastRoomMsg, 1)
 	atomic.AddUint64(&s.AllMsg, 1)
+	pushCounter.WithLabelValues(metricRoom).Inc()
+}
+
+func (s *Stat) IncrBroadcastTopicMsg() {
+	atomic.AddUint64(&s.BroadcastTopicMsg, 1)
+	atomic.AddUint64(&s.AllMsg, 1)
+	pushCounter.WithLabelValues(metricTopic).Inc()
+}
+
+func (s *Stat) IncrLimitAccept() {
+	atomic.AddUint64(&s.LimitAccept, 1)
+	limitAccept.Inc()
+}
+
+func (s *Stat) IncrLimitHandshake() {
+	atomic.AddUint64(&s.LimitHandshake, 1)
+	limitHandshake.Inc()
+}
+
+func (s *Stat) IncrLimitMsg() {
+	atomic.AddUint64(&s.LimitMsg, 1)
+	limitMsg.Inc()
 }
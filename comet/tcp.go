@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"goim/libs/bufio"
 	"goim/libs/bytes"
 	"goim/libs/define"
@@ -32,7 +34,39 @@ func InitTCP(addrs []string, accept int) (err error) {
 		log.Info("start tcp listen: \"%s\"", bind)
 		// split N core accept
 		for i := 0; i < accept; i++ {
-			go acceptTCP(DefaultServer, listener)
+			go acceptTCP(DefaultServer, listener, nil, nil)
+		}
+	}
+	return
+}
+
+// InitTCPWithTLS listen all tcp tls.bind and start accept connections, the
+// certificates are selected by SNI and can be reloaded by SIGHUP.
+func InitTCPWithTLS(addrs []string, certFiles, privateFiles []string, accept int) (err error) {
+	var (
+		bind     string
+		listener *net.TCPListener
+		addr     *net.TCPAddr
+		tlsCfg   *tls.Config
+	)
+	if tcpCerts, err = NewCertificates(certFiles, privateFiles); err != nil {
+		log.Error("NewCertificates(%v, %v) error(%v)", certFiles, privateFiles, err)
+		return
+	}
+	tlsCfg = tcpCerts.Config()
+	for _, bind = range addrs {
+		if addr, err = net.ResolveTCPAddr("tcp4", bind); err != nil {
+			log.Error("net.ResolveTCPAddr(\"tcp4\", \"%s\") error(%v)", bind, err)
+			return
+		}
+		if listener, err = net.ListenTCP("tcp4", addr); err != nil {
+			log.Error("net.ListenTCP(\"tcp4\", \"%s\") error(%v)", bind, err)
+			return
+		}
+		log.Info("start tcp tls listen: \"%s\"", bind)
+		// split N core accept
+		for i := 0; i < accept; i++ {
+			go acceptTCP(DefaultServer, listener, tlsCfg, nil)
 		}
 	}
 	return
@@ -40,8 +74,10 @@ func InitTCP(addrs []string, accept int) (err error) {
 
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.  Accept blocks; the caller typically
-// invokes it in a go statement.
-func acceptTCP(server *Server, lis *net.TCPListener) {
+// invokes it in a go statement. if tlsCfg not nil, the connection is served
+// over tls, the handshake is done on the first read. if pri not nil, the
+// connection must do the secure handshake.
+func acceptTCP(server *Server, lis *net.TCPListener, tlsCfg *tls.Config, pri *rsa.PrivateKey) {
 	var (
 		conn *net.TCPConn
 		err  error
@@ -53,6 +89,10 @@ func acceptTCP(server *Server, lis *net.TCPListener) {
 			log.Error("listener.Accept(\"%s\") error(%v)", lis.Addr().String(), err)
 			return
 		}
+		if !server.limiter.Accept(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
 		if err = conn.SetKeepAlive(server.Options.TCPKeepalive); err != nil {
 			log.Error("conn.SetKeepAlive() error(%v)", err)
 			return
@@ -65,14 +105,18 @@ func acceptTCP(server *Server, lis *net.TCPListener) {
 			log.Error("conn.SetWriteBuffer() error(%v)", err)
 			return
 		}
-		go serveTCP(server, conn, r)
+		if tlsCfg != nil {
+			go serveTCP(server, tls.Server(conn, tlsCfg), r, pri)
+		} else {
+			go serveTCP(server, conn, r, pri)
+		}
 		if r++; r == maxInt {
 			r = 0
 		}
 	}
 }
 
-func serveTCP(server *Server, conn *net.TCPConn, r int) {
+func serveTCP(server *Server, conn net.Conn, r int, pri *rsa.PrivateKey) {
 	var (
 		// timer
 		tr = server.round.Timer(r)
@@ -85,16 +129,17 @@ func serveTCP(server *Server, conn *net.TCPConn, r int) {
 	if Debug {
 		log.Debug("start tcp serve \"%s\" with \"%s\"", lAddr, rAddr)
 	}
-	server.serveTCP(conn, rp, wp, tr)
+	server.serveTCP(conn, rp, wp, tr, pri)
 }
 
 // TODO linger close?
-func (server *Server) serveTCP(conn *net.TCPConn, rp, wp *bytes.Pool, tr *itime.Timer) {
+func (server *Server) serveTCP(conn net.Conn, rp, wp *bytes.Pool, tr *itime.Timer, pri *rsa.PrivateKey) {
 	var (
 		err   error
 		key   string
-		rid   int32
+		rid   string
 		white bool
+		act   int
 		hb    time.Duration // heartbeat
 		p     *proto.Proto
 		b     *Bucket
@@ -105,16 +150,21 @@ func (server *Server) serveTCP(conn *net.TCPConn, rp, wp *bytes.Pool, tr *itime.
 		rr    = &ch.Reader
 		wr    = &ch.Writer
 	)
+	if server.poller != nil {
+		conn = server.poller.Wrap(conn)
+	}
 	ch.Reader.ResetBuffer(conn, rb.Bytes())
 	ch.Writer.ResetBuffer(conn, wb.Bytes())
 	// handshake
 	trd = tr.Add(server.Options.HandshakeTimeout, func() {
 		conn.Close()
 	})
+	ch.Accept("tcp", conn, trd)
 	// must not setadv, only used in auth
 	if p, err = ch.CliProto.Set(); err == nil {
-		if key, rid, hb, err = server.authTCP(rr, wr, p); err == nil {
+		if key, rid, ch.Attrs, hb, ch.Secure, err = server.authTCP(rr, wr, p, pri); err == nil {
 			b = server.Bucket(key)
+			ch.Key = key
 			err = b.Put(key, rid, ch)
 		}
 	}
@@ -127,15 +177,21 @@ func (server *Server) serveTCP(conn *net.TCPConn, rp, wp *bytes.Pool, tr *itime.
 		return
 	}
 	trd.Key = key
-	tr.Set(trd, hb)
+	ch.Heartbeat(tr, hb)
+	ch.Limit = server.limiter.NewChannel()
 	white = DefaultWhitelist.Contains(key)
 	if white {
-		DefaultWhitelist.Log.Printf("key: %s[%d] auth\n", key, rid)
+		DefaultWhitelist.Log.Printf("key: %s[%s] auth\n", key, rid)
 	}
 	// increase tcp stat
 	server.Stat.IncrTcpOnline()
-	// hanshake ok start dispatch goroutine
+	// hanshake ok, served by the poller or the dispatch and reader goroutines
+	if server.poll(&pollChannel{conn: conn, ch: ch, b: b, key: key, rid: rid, hb: hb, tr: tr, trd: trd, rp: rp, rb: rb, wp: wp, wb: wb}) {
+		server.joinHistory(ch, server.Options.RoomHistory)
+		return
+	}
 	go server.dispatchTCP(key, conn, wr, wp, wb, ch)
+	server.joinHistory(ch, server.Options.RoomHistory)
 	for {
 		if p, err = ch.CliProto.Set(); err != nil {
 			break
@@ -146,20 +202,36 @@ func (server *Server) serveTCP(conn *net.TCPConn, rp, wp *bytes.Pool, tr *itime.
 		if err = p.ReadTCP(rr); err != nil {
 			break
 		}
+		if ch.Secure != nil && len(p.Body) > 0 {
+			if p.Body, err = ch.Secure.Decrypt(p.Body); err != nil {
+				break
+			}
+		}
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s read proto:%v\n", key, p)
 		}
 		if p.Operation == define.OP_HEARTBEAT {
-			tr.Set(trd, hb)
+			ch.Heartbeat(tr, hb)
 			p.Body = nil
 			p.Operation = define.OP_HEARTBEAT_REPLY
 			if Debug {
 				log.Debug("key: %s receive heartbeat", key)
 			}
-		} else {
-			if err = server.operator.Operate(p); err != nil {
+		} else if act = server.limiter.Message(ch); act == limitPass {
+			if err = server.operate(b, ch, p); err == errAsyncReply {
+				err = nil
+				continue
+			} else if err != nil {
 				break
 			}
+		} else if act == limitDrop {
+			continue
+		} else if act == limitReply {
+			p.Body = nil
+			p.Operation = define.OP_RATE_LIMIT_REPLY
+		} else {
+			err = ErrChannelLimit
+			break
 		}
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s process proto:%v\n", key, p)
@@ -198,11 +270,13 @@ func (server *Server) serveTCP(conn *net.TCPConn, rp, wp *bytes.Pool, tr *itime.
 // dispatch accepts connections on the listener and serves requests
 // for each incoming connection.  dispatch blocks; the caller typically
 // invokes it in a go statement.
-func (server *Server) dispatchTCP(key string, conn *net.TCPConn, wr *bufio.Writer, wp *bytes.Pool, wb *bytes.Buffer, ch *Channel) {
+func (server *Server) dispatchTCP(key string, conn net.Conn, wr *bufio.Writer, wp *bytes.Pool, wb *bytes.Buffer, ch *Channel) {
 	var (
 		err    error
 		finish bool
+		kick   bool
 		white  = DefaultWhitelist.Contains(key)
+		f      = newFlusher(server, conn, ch)
 	)
 	if Debug {
 		log.Debug("key: %s start dispatch tcp goroutine", key)
@@ -229,6 +303,7 @@ func (server *Server) dispatchTCP(key string, conn *net.TCPConn, wr *bufio.Write
 			finish = true
 			goto failed
 		case proto.ProtoReady:
+			f.begin()
 			// fetch message from svrbox(client send)
 			for {
 				if p, err = ch.CliProto.Get(); err != nil {
@@ -238,7 +313,7 @@ func (server *Server) dispatchTCP(key string, conn *net.TCPConn, wr *bufio.Write
 				if white {
 					DefaultWhitelist.Log.Printf("key: %s start write client proto%v\n", key, p)
 				}
-				if err = p.WriteTCP(wr); err != nil {
+				if err = writeTCP(ch, wr, p); err != nil {
 					goto failed
 				}
 				if white {
@@ -251,10 +326,21 @@ func (server *Server) dispatchTCP(key string, conn *net.TCPConn, wr *bufio.Write
 			if white {
 				DefaultWhitelist.Log.Printf("key: %s start write server proto%v\n", key, p)
 			}
+			// expired in the channel queue
+			if p.Expired() {
+				p.Frame().Unref()
+				expiredDrop.Inc()
+				break
+			}
 			// server send
-			if err = p.WriteTCP(wr); err != nil {
+			f.begin()
+			err = writeTCP(ch, wr, p)
+			p.Frame().Unref()
+			if err != nil {
 				goto failed
 			}
+			// kicked by the session policy, close after the reply flushed
+			kick = p.Operation == define.OP_DISCONNECT_REPLY
 			if white {
 				DefaultWhitelist.Log.Printf("key: %s write server proto%v\n", key, p)
 			}
@@ -262,10 +348,14 @@ func (server *Server) dispatchTCP(key string, conn *net.TCPConn, wr *bufio.Write
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s start flush \n", key)
 		}
-		// only hungry flush response
-		if err = wr.Flush(); err != nil {
+		// only hungry flush response, or coalesce the queued protos
+		if !kick && f.hold() {
+			continue
+		}
+		if err = wr.Flush(); err != nil || kick {
 			break
 		}
+		f.done()
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s flush\n", key)
 		}
@@ -275,13 +365,18 @@ failed:
 		DefaultWhitelist.Log.Printf("key: %s dispatch tcp error(%v)\n", key, err)
 	}
 	if err != nil {
+		if stalled(err) {
+			tcpStalled.Inc()
+		}
 		log.Error("key: %s dispatch tcp error(%v)", key, err)
 	}
 	conn.Close()
 	wp.Put(wb)
 	// must ensure all channel message discard, for reader won't blocking Signal
 	for !finish {
-		finish = (ch.Ready() == proto.ProtoFinish)
+		p := ch.Ready()
+		p.Frame().Unref()
+		finish = (p == proto.ProtoFinish)
 	}
 	if Debug {
 		log.Debug("key: %s dispatch goroutine exit", key)
@@ -290,7 +385,9 @@ failed:
 }
 
 // auth for goim handshake with client, use rsa & aes.
-func (server *Server) authTCP(rr *bufio.Reader, wr *bufio.Writer, p *proto.Proto) (key string, rid int32, heartbeat time.Duration, err error) {
+// if pri not nil, the body carries the rsa encrypted session key and the
+// sealed token, a secure session is returned.
+func (server *Server) authTCP(rr *bufio.Reader, wr *bufio.Writer, p *proto.Proto, pri *rsa.PrivateKey) (key string, rid string, attrs map[string]string, heartbeat time.Duration, secure *Secure, err error) {
 	if err = p.ReadTCP(rr); err != nil {
 		return
 	}
@@ -299,7 +396,30 @@ func (server *Server) authTCP(rr *bufio.Reader, wr *bufio.Writer, p *proto.Proto
 		err = ErrOperation
 		return
 	}
-	if key, rid, heartbeat, err = server.operator.Connect(p); err != nil {
+	if act := server.limiter.Handshake(); act != limitPass {
+		if act == limitReply {
+			p.Body = nil
+			p.Operation = define.OP_RATE_LIMIT_REPLY
+			if err = p.WriteTCP(wr); err == nil {
+				wr.Flush()
+			}
+		}
+		err = ErrHandshakeLimit
+		return
+	}
+	if pri != nil {
+		if secure, p.Body, err = NewSecure(pri, p.Body); err != nil {
+			return
+		}
+	}
+	if key, rid, attrs, heartbeat, err = server.operator.Connect(p); err != nil {
+		if err == ErrRoomFull {
+			p.Body = nil
+			p.Operation = define.OP_ROOM_FULL_REPLY
+			if p.WriteTCP(wr) == nil {
+				wr.Flush()
+			}
+		}
 		return
 	}
 	p.Body = nil
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/define"
+	"goim/libs/proto"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	topicMaxSize = 128
+)
+
+// Sub subscribe the channel to the topics.
+func (b *Bucket) Sub(ch *Channel, topics []string) (err error) {
+	var (
+		topic string
+		chs   map[*Channel]struct{}
+		ok    bool
+	)
+	b.cLock.Lock()
+	if ch.Topics == nil {
+		ch.Topics = make(map[string]struct{}, len(topics))
+	}
+	for _, topic = range topics {
+		if _, ok = ch.Topics[topic]; ok {
+			continue
+		}
+		if len(ch.Topics) >= b.boptions.TopicMax {
+			err = ErrTopicMax
+			break
+		}
+		if chs, ok = b.topics[topic]; !ok {
+			chs = make(map[*Channel]struct{})
+			b.topics[topic] = chs
+		}
+		chs[ch] = struct{}{}
+		ch.Topics[topic] = struct{}{}
+	}
+	b.cLock.Unlock()
+	return
+}
+
+// Unsub unsubscribe the channel from the topics.
+func (b *Bucket) Unsub(ch *Channel, topics []string) {
+	var topic string
+	b.cLock.Lock()
+	for _, topic = range topics {
+		b.unsub(ch, topic)
+	}
+	b.cLock.Unlock()
+}
+
+// unsub unsubscribe a topic, must be called with the lock.
+func (b *Bucket) unsub(ch *Channel, topic string) {
+	if chs, ok := b.topics[topic]; ok {
+		if delete(chs, ch); len(chs) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+	delete(ch.Topics, topic)
+}
+
+// BroadcastTopic push msgs to the channels subscribed the topic.
+func (b *Bucket) BroadcastTopic(topic string, p *proto.Proto) {
+	var ch *Channel
+	b.cLock.RLock()
+	for ch = range b.topics[topic] {
+		// ignore error
+		ch.Push(p)
+	}
+	b.cLock.RUnlock()
+}
+
+// Topics get all topics in the bucket.
+func (b *Bucket) Topics() (res map[string]struct{}) {
+	var topic string
+	b.cLock.RLock()
+	res = make(map[string]struct{}, len(b.topics))
+	for topic = range b.topics {
+		res[topic] = struct{}{}
+	}
+	b.cLock.RUnlock()
+	return
+}
+
+// TopicCount topic count in the bucket
+func (b *Bucket) TopicCount() (n int) {
+	b.cLock.RLock()
+	n = len(b.topics)
+	b.cLock.RUnlock()
+	return
+}
+
+// topic process OP_SUB and OP_UNSUB, the body is a json array of the
+// topics, the reply body is the topics of the channel.
+func (server *Server) topic(b *Bucket, ch *Channel, p *proto.Proto) (err error) {
+	var topics []string
+	if err = json.Unmarshal(p.Body, &topics); err != nil {
+		return
+	}
+	for _, topic := range topics {
+		if topic == "" || len(topic) > topicMaxSize {
+			return ErrTopic
+		}
+	}
+	if p.Operation == define.OP_SUB {
+		// over the max topics is not a connection error, replies the
+		// subscribed topics.
+		if serr := b.Sub(ch, topics); serr != nil {
+			log.Warn("key: %s sub topics error(%v)", ch.Key, serr)
+		}
+		p.Operation = define.OP_SUB_REPLY
+	} else {
+		b.Unsub(ch, topics)
+		p.Operation = define.OP_UNSUB_REPLY
+	}
+	p.Body, err = json.Marshal(b.ChannelTopics(ch))
+	return
+}
+
+// ChannelTopics get the topics of the channel.
+func (b *Bucket) ChannelTopics(ch *Channel) (topics []string) {
+	b.cLock.RLock()
+	topics = make([]string, 0, len(ch.Topics))
+	for topic := range ch.Topics {
+		topics = append(topics, topic)
+	}
+	b.cLock.RUnlock()
+	return
+}
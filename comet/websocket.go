@@ -42,18 +42,19 @@ func InitWebsocket(addrs []string, accept int) (err error) {
 	return
 }
 
-func InitWebsocketWithTLS(addrs []string, certFile, privateFile string, accept int) (err error) {
+// InitWebsocketWithTLS listen all websocket tls.bind and start accept
+// connections, the certificates are selected by SNI and can be reloaded by
+// SIGHUP.
+func InitWebsocketWithTLS(addrs []string, certFiles, privateFiles []string, accept int) (err error) {
 	var (
 		bind     string
 		listener net.Listener
-		cert     tls.Certificate
 	)
-	cert, err = tls.LoadX509KeyPair(certFile, privateFile)
-	if err != nil {
-		log.Error("Error loading certificate. ", err)
+	if websocketCerts, err = NewCertificates(certFiles, privateFiles); err != nil {
+		log.Error("NewCertificates(%v, %v) error(%v)", certFiles, privateFiles, err)
 		return
 	}
-	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	tlsCfg := websocketCerts.Config()
 	for _, bind = range addrs {
 		if listener, err = tls.Listen("tcp4", bind, tlsCfg); err != nil {
 			log.Error("net.ListenTCP(\"tcp4\", \"%s\") error(%v)", bind, err)
@@ -83,6 +84,10 @@ func acceptWebsocket(server *Server, lis *net.TCPListener) {
 			guluLogger.Errorf("listener.Accept(\"%s\") error(%v)", lis.Addr().String(), err)
 			return
 		}
+		if !server.limiter.Accept(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
 		if err = conn.SetKeepAlive(server.Options.TCPKeepalive); err != nil {
 			guluLogger.Errorf("conn.SetKeepAlive() error(%v)", err)
 			return
@@ -117,6 +122,10 @@ func acceptWebsocketWithTLS(server *Server, lis net.Listener) {
 			log.Error("listener.Accept(\"%s\") error(%v)", lis.Addr().String(), err)
 			return
 		}
+		if !server.limiter.Accept(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
 		go serveWebsocket(server, conn, r)
 		if r++; r == maxInt {
 			r = 0
@@ -145,8 +154,9 @@ func (server *Server) serveWebsocket(conn net.Conn, rp, wp *bytes.Pool, tr *itim
 	var (
 		err    error
 		key    string
-		roomId int32
+		roomId string
 		white  bool
+		act    int
 		hb     time.Duration // heartbeat
 		p      *proto.Proto
 		b      *Bucket
@@ -161,12 +171,16 @@ func (server *Server) serveWebsocket(conn net.Conn, rp, wp *bytes.Pool, tr *itim
 
 	guluLogger.Debug("serveWebsocket is start")
 
+	if server.poller != nil {
+		conn = server.poller.Wrap(conn)
+	}
 	// reader
 	ch.Reader.ResetBuffer(conn, rb.Bytes())
 	// handshake
 	trd = tr.Add(server.Options.HandshakeTimeout, func() {
 		conn.Close()
 	})
+	ch.Accept("websocket", conn, trd)
 	// websocket
 	if req, err = websocket.ReadRequest(rr); err != nil || req.RequestURI != "/sub" {
 		conn.Close()
@@ -192,8 +206,9 @@ func (server *Server) serveWebsocket(conn net.Conn, rp, wp *bytes.Pool, tr *itim
 	}
 	// must not setadv, only used in auth
 	if p, err = ch.CliProto.Set(); err == nil {
-		if key, roomId, hb, err = server.authWebsocket(ws, p); err == nil {
+		if key, roomId, ch.Attrs, hb, err = server.authWebsocket(ws, p); err == nil {
 			b = server.Bucket(key)
+			ch.Key = key
 			err = b.Put(key, roomId, ch)
 		}
 	}
@@ -212,15 +227,21 @@ func (server *Server) serveWebsocket(conn net.Conn, rp, wp *bytes.Pool, tr *itim
 		return
 	}
 	trd.Key = key
-	tr.Set(trd, hb)
+	ch.Heartbeat(tr, hb)
+	ch.Limit = server.limiter.NewChannel()
 	white = DefaultWhitelist.Contains(key)
 	if white {
-		DefaultWhitelist.Log.Printf("key: %s[%d] auth\n", key, roomId)
+		DefaultWhitelist.Log.Printf("key: %s[%s] auth\n", key, roomId)
 	}
 	// increase ws stat
 	server.Stat.IncrWsOnline()
-	// hanshake ok start dispatch goroutine
+	// hanshake ok, served by the poller or the dispatch and reader goroutines
+	if server.poll(&pollChannel{conn: conn, ch: ch, b: b, ws: ws, key: key, rid: roomId, hb: hb, tr: tr, trd: trd, rp: rp, rb: rb, wp: wp, wb: wb}) {
+		server.joinHistory(ch, server.Options.RoomHistory)
+		return
+	}
 	go server.dispatchWebsocket(key, ws, wp, wb, ch)
+	server.joinHistory(ch, server.Options.RoomHistory)
 	for {
 		if p, err = ch.CliProto.Set(); err != nil {
 			break
@@ -235,15 +256,26 @@ func (server *Server) serveWebsocket(conn net.Conn, rp, wp *bytes.Pool, tr *itim
 			DefaultWhitelist.Log.Printf("key: %s read proto:%v\n", key, p)
 		}
 		if p.Operation == define.OP_HEARTBEAT {
-			tr.Set(trd, hb)
+			ch.Heartbeat(tr, hb)
 			p.Operation = define.OP_HEARTBEAT_REPLY
 			if Debug {
 				guluLogger.Debugf("key: %s receive heartbeat", key)
 			}
-		} else {
-			if err = server.operator.Operate(p); err != nil {
+		} else if act = server.limiter.Message(ch); act == limitPass {
+			if err = server.operate(b, ch, p); err == errAsyncReply {
+				err = nil
+				continue
+			} else if err != nil {
 				break
 			}
+		} else if act == limitDrop {
+			continue
+		} else if act == limitReply {
+			p.Body = nil
+			p.Operation = define.OP_RATE_LIMIT_REPLY
+		} else {
+			err = ErrChannelLimit
+			break
 		}
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s process proto:%v\n", key, p)
@@ -286,7 +318,9 @@ func (server *Server) dispatchWebsocket(key string, ws *websocket.Conn, wp *byte
 	var (
 		err    error
 		finish bool
+		kick   bool
 		white  = DefaultWhitelist.Contains(key)
+		f      = newFlusher(server, ch.Conn, ch)
 	)
 	if Debug {
 		guluLogger.Debugf("key: %s start dispatch tcp goroutine", key)
@@ -313,6 +347,7 @@ func (server *Server) dispatchWebsocket(key string, ws *websocket.Conn, wp *byte
 			finish = true
 			goto failed
 		case proto.ProtoReady:
+			f.begin()
 			// fetch message from svrbox(client send)
 			for {
 				if p, err = ch.CliProto.Get(); err != nil {
@@ -335,10 +370,21 @@ func (server *Server) dispatchWebsocket(key string, ws *websocket.Conn, wp *byte
 			if white {
 				DefaultWhitelist.Log.Printf("key: %s start write server proto%v\n", key, p)
 			}
+			// expired in the channel queue
+			if p.Expired() {
+				p.Frame().Unref()
+				expiredDrop.Inc()
+				break
+			}
 			// server send
-			if err = p.WriteWebsocket(ws); err != nil {
+			f.begin()
+			err = writeWebsocket(ws, p)
+			p.Frame().Unref()
+			if err != nil {
 				goto failed
 			}
+			// kicked by the session policy, close after the reply flushed
+			kick = p.Operation == define.OP_DISCONNECT_REPLY
 			if white {
 				DefaultWhitelist.Log.Printf("key: %s write server proto%v\n", key, p)
 			}
@@ -346,10 +392,14 @@ func (server *Server) dispatchWebsocket(key string, ws *websocket.Conn, wp *byte
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s start flush \n", key)
 		}
-		// only hungry flush response
-		if err = ws.Flush(); err != nil {
+		// only hungry flush response, or coalesce the queued protos
+		if !kick && f.hold() {
+			continue
+		}
+		if err = ws.Flush(); err != nil || kick {
 			break
 		}
+		f.done()
 		if white {
 			DefaultWhitelist.Log.Printf("key: %s flush\n", key)
 		}
@@ -358,6 +408,9 @@ failed:
 	if white {
 		DefaultWhitelist.Log.Printf("key: %s dispatch tcp error(%v)\n", key, err)
 	}
+	if stalled(err) {
+		wsStalled.Inc()
+	}
 	if err != nil && err != io.EOF && err != websocket.ErrMessageClose {
 		guluLogger.Errorf("key: %s dispatch tcp error(%v)", key, err)
 	}
@@ -365,7 +418,9 @@ failed:
 	wp.Put(wb)
 	// must ensure all channel message discard, for reader won't blocking Signal
 	for !finish {
-		finish = (ch.Ready() == proto.ProtoFinish)
+		p := ch.Ready()
+		p.Frame().Unref()
+		finish = (p == proto.ProtoFinish)
 	}
 	if Debug {
 		guluLogger.Debugf("key: %s dispatch goroutine exit", key)
@@ -373,8 +428,17 @@ failed:
 	return
 }
 
+// writeWebsocket write the proto to the websocket, the shared frame is
+// written as it is.
+func writeWebsocket(ws *websocket.Conn, p *proto.Proto) error {
+	if f := p.Frame(); f != nil {
+		return ws.WriteRaw(f.Websocket())
+	}
+	return p.WriteWebsocket(ws)
+}
+
 // auth for goim handshake with client, use rsa & aes.
-func (server *Server) authWebsocket(ws *websocket.Conn, p *proto.Proto) (key string, rid int32, heartbeat time.Duration, err error) {
+func (server *Server) authWebsocket(ws *websocket.Conn, p *proto.Proto) (key string, rid string, attrs map[string]string, heartbeat time.Duration, err error) {
 	msg, _ := json.Marshal(p)
 	guluLogger.Debugf("authWebsocket proto.Proto is: %s", string(msg))
 
@@ -386,7 +450,25 @@ func (server *Server) authWebsocket(ws *websocket.Conn, p *proto.Proto) (key str
 	// 	err = ErrOperation
 	// 	return
 	// }
-	if key, rid, heartbeat, err = server.operator.Connect(p); err != nil {
+	if act := server.limiter.Handshake(); act != limitPass {
+		if act == limitReply {
+			p.Body = nil
+			p.Operation = define.OP_RATE_LIMIT_REPLY
+			if err = p.WriteWebsocket(ws); err == nil {
+				ws.Flush()
+			}
+		}
+		err = ErrHandshakeLimit
+		return
+	}
+	if key, rid, attrs, heartbeat, err = server.operator.Connect(p); err != nil {
+		if err == ErrRoomFull {
+			p.Body = nil
+			p.Operation = define.OP_ROOM_FULL_REPLY
+			if p.WriteWebsocket(ws) == nil {
+				ws.Flush()
+			}
+		}
 		return
 	}
 	p.Body = nil
@@ -2,17 +2,33 @@ package bytes
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type Buffer struct {
 	buf  []byte
 	next *Buffer // next free buffer
+	pool *Pool   // put back by the last Unref, nil if not pooled
+	ref  int32
 }
 
 func (b *Buffer) Bytes() []byte {
 	return b.buf
 }
 
+// Ref add a reference to the buffer shared by multiple holders.
+func (b *Buffer) Ref() {
+	atomic.AddInt32(&b.ref, 1)
+}
+
+// Unref drop a reference, the buffer is put back to its pool after the last
+// reference dropped, the holders must not use it after their Unref.
+func (b *Buffer) Unref() {
+	if atomic.AddInt32(&b.ref, -1) == 0 && b.pool != nil {
+		b.pool.Put(b)
+	}
+}
+
 // Pool is a buffer pool.
 type Pool struct {
 	lock sync.Mutex
@@ -77,6 +93,19 @@ func (p *Pool) Get() (b *Buffer) {
 	return
 }
 
+// GetRef get a buffer of at least n bytes referenced once, released by
+// Unref. a buffer out of the pool is allocated if n is over the pool size.
+func (p *Pool) GetRef(n int) (b *Buffer) {
+	if n > p.size {
+		b = &Buffer{buf: make([]byte, n)}
+	} else {
+		b = p.Get()
+		b.pool = p
+	}
+	b.ref = 1
+	return
+}
+
 // Put put back a memory buffer to free.
 func (p *Pool) Put(b *Buffer) {
 	p.lock.Lock()
@@ -19,3 +19,21 @@ func TestBuffer(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestBufferRef(t *testing.T) {
+	p := NewPool(1, 10)
+	b := p.GetRef(10)
+	b.Ref()
+	b.Unref()
+	if p.free != nil {
+		t.Fatal("buffer put back with a reference")
+	}
+	b.Unref()
+	if p.free != b {
+		t.Fatal("buffer not put back after the last reference")
+	}
+	if b = p.GetRef(11); b.pool != nil || len(b.Bytes()) != 11 {
+		t.Errorf("GetRef(11) over the pool size got %d bytes pooled %v", len(b.Bytes()), b.pool != nil)
+	}
+	b.Unref()
+}
@@ -43,3 +43,37 @@ func BenchmarkAES(b *testing.B) {
 	}
 }
 */
+
+func TestGCM(t *testing.T) {
+	aead, err := NewGCM([]byte("11111111111111111111111111111111"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	msg := "hello goim"
+	c, err := GCMEncrypt(aead, []byte(msg))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(c) != aead.NonceSize()+len(msg)+aead.Overhead() {
+		t.FailNow()
+	}
+	o, err := GCMDecrypt(aead, c)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if string(o) != msg {
+		t.FailNow()
+	}
+	// tamper
+	c, _ = GCMEncrypt(aead, []byte(msg))
+	c[len(c)-1] ^= 1
+	if _, err = GCMDecrypt(aead, c); err == nil {
+		t.FailNow()
+	}
+	if _, err = GCMDecrypt(aead, c[:aead.NonceSize()]); err != ErrGCMSize {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,45 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrGCMSize = errors.New("input smaller than nonce and tag")
+)
+
+// NewGCM new an aes gcm aead by the key, the key must be 16, 24 or 32 bytes.
+func NewGCM(key []byte) (aead cipher.AEAD, err error) {
+	var b cipher.Block
+	if b, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	aead, err = cipher.NewGCM(b)
+	return
+}
+
+// GCMEncrypt seal the src with a random nonce, the nonce is prepended to the
+// returned cipher text.
+func GCMEncrypt(aead cipher.AEAD, src []byte) (dst []byte, err error) {
+	ns := aead.NonceSize()
+	dst = make([]byte, ns, ns+len(src)+aead.Overhead())
+	if _, err = rand.Read(dst); err != nil {
+		return
+	}
+	dst = aead.Seal(dst, dst, src, nil)
+	return
+}
+
+// GCMDecrypt open the src which is nonce prepended cipher text.
+func GCMDecrypt(aead cipher.AEAD, src []byte) (dst []byte, err error) {
+	ns := aead.NonceSize()
+	if len(src) < ns+aead.Overhead() {
+		return nil, ErrGCMSize
+	}
+	// use same buf
+	dst, err = aead.Open(src[ns:ns], src[:ns], src[ns:], nil)
+	return
+}
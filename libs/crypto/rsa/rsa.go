@@ -41,3 +41,12 @@ func Encrypt(orig []byte, pub *rsa.PublicKey) ([]byte, error) {
 func Decrypt(cipher []byte, pri *rsa.PrivateKey) ([]byte, error) {
 	return rsa.DecryptPKCS1v15(nil, pri, cipher)
 }
+
+// DecryptSessionKey decrypt a session key into key, the key is left random if
+// the cipher is invalid, so the padding error won't leak to the peer.
+func DecryptSessionKey(cipher []byte, pri *rsa.PrivateKey, key []byte) error {
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	return rsa.DecryptPKCS1v15SessionKey(nil, pri, cipher, key)
+}
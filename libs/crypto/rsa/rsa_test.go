@@ -69,3 +69,31 @@ func BenchmarkRSA(b *testing.B) {
 		}
 	}
 }
+
+func TestSessionKey(t *testing.T) {
+	pri, err := PrivateKey([]byte(priKey))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	pub, err := PublicKey([]byte(pubKey))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// the test key is tiny, only 1 byte fits in the padding
+	msg := []byte("a")
+	cipher, err := Encrypt(msg, pub)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	key := make([]byte, len(msg))
+	if err = DecryptSessionKey(cipher, pri, key); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if string(key) != string(msg) {
+		t.FailNow()
+	}
+}
@@ -2,7 +2,9 @@ package define
 
 // Kafka message type Commands
 const (
-	KAFKA_MESSAGE_MULTI          = "multiple"       //multi-userid push
-	KAFKA_MESSAGE_BROADCAST      = "broadcast"      //broadcast push
-	KAFKA_MESSAGE_BROADCAST_ROOM = "broadcast_room" //broadcast room push
+	KAFKA_MESSAGE_MULTI           = "multiple"        //multi-userid push
+	KAFKA_MESSAGE_BROADCAST       = "broadcast"       //broadcast push
+	KAFKA_MESSAGE_BROADCAST_ROOM  = "broadcast_room"  //broadcast room push
+	KAFKA_MESSAGE_BROADCAST_TOPIC = "broadcast_topic" //broadcast topic push
+	KAFKA_MESSAGE_KICK            = "kick"            //kick sessions by OP_DISCONNECT_REPLY
 )
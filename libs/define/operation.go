@@ -25,6 +25,21 @@ const (
 	// proto
 	OP_PROTO_READY  = int32(13)
 	OP_PROTO_FINISH = int32(14)
+	// rate limit
+	OP_RATE_LIMIT_REPLY = int32(15)
+	// topic
+	OP_SUB         = int32(16)
+	OP_SUB_REPLY   = int32(17)
+	OP_UNSUB       = int32(18)
+	OP_UNSUB_REPLY = int32(19)
+	// room history
+	OP_ROOM_HISTORY       = int32(20)
+	OP_ROOM_HISTORY_REPLY = int32(21)
+	// resend the user messages after a seq
+	OP_RESEND       = int32(22)
+	OP_RESEND_REPLY = int32(23)
+	// the handshake refused for the room is full
+	OP_ROOM_FULL_REPLY = int32(24)
 
 	// for test
 	OP_TEST       = int32(254)
@@ -1,5 +1,5 @@
 package define
 
 const (
-	NoRoom = -1
+	NoRoom = ""
 )
@@ -0,0 +1,300 @@
+// Package filter is the targeting expression of the conditional broadcast,
+// it is evaluated by comet against the attributes of a connection.
+//
+//	platform == ios && version >= 5
+//	region in (cn, hk) || !(locale == "en-US")
+//
+// The values are bare words or quoted strings, ">", ">=", "<" and "<=" compare
+// the dotted numeric versions segment by segment (5.10 > 5.9), their value
+// must be a version and an attribute not a version doesn't match. A missing
+// attribute is the empty string.
+package filter
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrSyntax  = errors.New("filter syntax error")
+	ErrTooLong = errors.New("filter too long")
+)
+
+const (
+	maxSize = 1024
+)
+
+// Expr is a parsed filter expression.
+type Expr struct {
+	node node
+	expr string
+}
+
+// Parse parse the filter expression, an empty expression matches all.
+func Parse(s string) (e *Expr, err error) {
+	var (
+		n node
+		p *parser
+	)
+	if len(s) > maxSize {
+		err = ErrTooLong
+		return
+	}
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	p = &parser{lex: &lexer{s: s}}
+	p.next()
+	if n, err = p.or(); err != nil {
+		return
+	}
+	if p.tok.typ != tokEOF {
+		err = ErrSyntax
+		return
+	}
+	e = &Expr{node: n, expr: s}
+	return
+}
+
+// Match evaluate the expression by the attributes, a nil expression matches
+// all.
+func (e *Expr) Match(attrs map[string]string) bool {
+	if e == nil {
+		return true
+	}
+	return e.node.match(attrs)
+}
+
+// String return the source expression.
+func (e *Expr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.expr
+}
+
+type node interface {
+	match(attrs map[string]string) bool
+}
+
+type andNode struct{ l, r node }
+
+func (n *andNode) match(attrs map[string]string) bool {
+	return n.l.match(attrs) && n.r.match(attrs)
+}
+
+type orNode struct{ l, r node }
+
+func (n *orNode) match(attrs map[string]string) bool {
+	return n.l.match(attrs) || n.r.match(attrs)
+}
+
+type notNode struct{ n node }
+
+func (n *notNode) match(attrs map[string]string) bool {
+	return !n.n.match(attrs)
+}
+
+type cmpNode struct {
+	key    string
+	op     string
+	values []string
+}
+
+func (n *cmpNode) match(attrs map[string]string) bool {
+	v := attrs[n.key]
+	switch n.op {
+	case "==":
+		return v == n.values[0]
+	case "!=":
+		return v != n.values[0]
+	case "in":
+		for _, value := range n.values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	r, ok := compare(v, n.values[0])
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
+
+// version parse the dotted numeric version, not ok if any segment is not a
+// non-negative integer.
+func version(s string) (segs []int, ok bool) {
+	for _, seg := range strings.Split(s, ".") {
+		x, err := strconv.Atoi(seg)
+		if err != nil || x < 0 || seg[0] == '+' {
+			return nil, false
+		}
+		segs = append(segs, x)
+	}
+	return segs, true
+}
+
+// compare compare the dotted numeric versions, the missing segments are 0,
+// not ok if any is not a version.
+func compare(a, b string) (r int, ok bool) {
+	as, ok := version(a)
+	if !ok {
+		return
+	}
+	bs, ok := version(b)
+	if !ok {
+		return
+	}
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if x != y {
+			if x > y {
+				return 1, true
+			}
+			return -1, true
+		}
+	}
+	return 0, true
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+// or = and { "||" and }
+func (p *parser) or() (n node, err error) {
+	var r node
+	if n, err = p.and(); err != nil {
+		return
+	}
+	for p.tok.typ == tokOr {
+		p.next()
+		if r, err = p.and(); err != nil {
+			return
+		}
+		n = &orNode{l: n, r: r}
+	}
+	return
+}
+
+// and = unary { "&&" unary }
+func (p *parser) and() (n node, err error) {
+	var r node
+	if n, err = p.unary(); err != nil {
+		return
+	}
+	for p.tok.typ == tokAnd {
+		p.next()
+		if r, err = p.unary(); err != nil {
+			return
+		}
+		n = &andNode{l: n, r: r}
+	}
+	return
+}
+
+// unary = "!" unary | "(" or ")" | cmp
+func (p *parser) unary() (n node, err error) {
+	switch p.tok.typ {
+	case tokNot:
+		p.next()
+		if n, err = p.unary(); err != nil {
+			return
+		}
+		n = &notNode{n: n}
+	case tokLParen:
+		p.next()
+		if n, err = p.or(); err != nil {
+			return
+		}
+		if p.tok.typ != tokRParen {
+			err = ErrSyntax
+			return
+		}
+		p.next()
+	default:
+		n, err = p.cmp()
+	}
+	return
+}
+
+// cmp = word op value | word "in" "(" value { "," value } ")"
+func (p *parser) cmp() (n node, err error) {
+	var c = new(cmpNode)
+	if p.tok.typ != tokWord {
+		err = ErrSyntax
+		return
+	}
+	c.key = p.tok.val
+	p.next()
+	switch {
+	case p.tok.typ == tokOp:
+		c.op = p.tok.val
+		p.next()
+		if p.tok.typ != tokWord && p.tok.typ != tokString {
+			err = ErrSyntax
+			return
+		}
+		c.values = []string{p.tok.val}
+		if c.op != "==" && c.op != "!=" {
+			// the order is of the versions only
+			if _, ok := version(c.values[0]); !ok {
+				err = ErrSyntax
+				return
+			}
+		}
+		p.next()
+	case p.tok.typ == tokWord && p.tok.val == "in":
+		c.op = "in"
+		p.next()
+		if p.tok.typ != tokLParen {
+			err = ErrSyntax
+			return
+		}
+		for {
+			p.next()
+			if p.tok.typ != tokWord && p.tok.typ != tokString {
+				err = ErrSyntax
+				return
+			}
+			c.values = append(c.values, p.tok.val)
+			if p.next(); p.tok.typ == tokRParen {
+				break
+			}
+			if p.tok.typ != tokComma {
+				err = ErrSyntax
+				return
+			}
+		}
+		p.next()
+	default:
+		err = ErrSyntax
+		return
+	}
+	n = c
+	return
+}
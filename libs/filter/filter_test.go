@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{
+		"platform == ios",
+		"platform == ios && version >= 5",
+		"(platform == ios || platform == android) && !(region in (cn, hk))",
+		`locale == "en US" || locale != "zh\"CN"`,
+	} {
+		if _, err := Parse(s); err != nil {
+			t.Errorf("Parse(%s) error(%v)", s, err)
+		}
+	}
+	for _, s := range []string{
+		"platform",
+		"platform ==",
+		"platform == ios &&",
+		"(platform == ios",
+		"region in (cn,",
+		"region in cn",
+		"platform = ios",
+		`locale == "en`,
+		"platform == ios)",
+		"version >= beta",
+		"version < 5.x",
+		`version > ""`,
+	} {
+		if _, err := Parse(s); err != ErrSyntax {
+			t.Errorf("Parse(%s) error(%v), want ErrSyntax", s, err)
+		}
+	}
+	if e, err := Parse(" "); err != nil || e != nil {
+		t.Errorf("Parse(empty) got %v error(%v)", e, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	var (
+		ios5  = map[string]string{"platform": "ios", "version": "5.2.1", "region": "cn"}
+		ios4  = map[string]string{"platform": "ios", "version": "4.9"}
+		and10 = map[string]string{"platform": "android", "version": "10.0", "region": "hk"}
+		none  = map[string]string{}
+	)
+	for _, c := range []struct {
+		expr  string
+		attrs map[string]string
+		match bool
+	}{
+		{"platform == ios && version >= 5", ios5, true},
+		{"platform == ios && version >= 5", ios4, false},
+		{"platform == ios && version >= 5", and10, false},
+		{"version >= 5", and10, true},
+		{"version > 5.2.1", ios5, false},
+		{"version < 5.10", ios5, true},
+		{"version <= 4.9.0", ios4, true},
+		{"version >= 5", none, false},
+		{"region in (cn, hk)", ios5, true},
+		{"region in (cn, hk)", ios4, false},
+		{"!(region in (cn, hk))", ios4, true},
+		{"platform == ios || region == hk && version > 99", and10, false},
+		{"(platform == ios || region == hk) && version > 9", and10, true},
+		{"platform != ios", none, true},
+		{`platform == ""`, none, true},
+		{"version >= 5", map[string]string{"version": "beta"}, false},
+		{"version < 5", map[string]string{"version": "beta"}, false},
+		{"version <= 5", map[string]string{"version": "4.x"}, false},
+		{"!(version >= 5)", map[string]string{"version": "beta"}, true},
+	} {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%s) error(%v)", c.expr, err)
+		}
+		if m := e.Match(c.attrs); m != c.match {
+			t.Errorf("Parse(%s).Match(%v) got %t, want %t", c.expr, c.attrs, m, c.match)
+		}
+	}
+	var e *Expr
+	if !e.Match(none) {
+		t.Error("nil expr not match all")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		r    int
+		ok   bool
+	}{
+		{"5", "5.0.0", 0, true},
+		{"5.10", "5.9", 1, true},
+		{"4.9", "5", -1, true},
+		{"beta", "5", 0, false},
+		{"5", "beta", 0, false},
+		{"5.x", "5", 0, false},
+		{"5..1", "5", 0, false},
+		{"-1", "5", 0, false},
+		{"", "5", 0, false},
+	} {
+		if r, ok := compare(c.a, c.b); r != c.r || ok != c.ok {
+			t.Errorf("compare(%s, %s) got %d %t, want %d %t", c.a, c.b, r, ok, c.r, c.ok)
+		}
+	}
+}
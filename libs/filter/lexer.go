@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	tokEOF = iota
+	tokError
+	tokWord
+	tokString
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	typ int
+	val string
+}
+
+type lexer struct {
+	s   string
+	pos int
+}
+
+func isWord(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-' || c == ':'
+}
+
+func (l *lexer) next() (t token) {
+	for l.pos < len(l.s) && (l.s[l.pos] == ' ' || l.s[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos >= len(l.s) {
+		return token{typ: tokEOF}
+	}
+	s := l.s[l.pos:]
+	switch {
+	case strings.HasPrefix(s, "&&"):
+		l.pos += 2
+		return token{typ: tokAnd}
+	case strings.HasPrefix(s, "||"):
+		l.pos += 2
+		return token{typ: tokOr}
+	case strings.HasPrefix(s, "=="), strings.HasPrefix(s, "!="), strings.HasPrefix(s, ">="), strings.HasPrefix(s, "<="):
+		l.pos += 2
+		return token{typ: tokOp, val: s[:2]}
+	}
+	switch c := s[0]; {
+	case c == '>' || c == '<':
+		l.pos++
+		return token{typ: tokOp, val: s[:1]}
+	case c == '!':
+		l.pos++
+		return token{typ: tokNot}
+	case c == '(':
+		l.pos++
+		return token{typ: tokLParen}
+	case c == ')':
+		l.pos++
+		return token{typ: tokRParen}
+	case c == ',':
+		l.pos++
+		return token{typ: tokComma}
+	case c == '"':
+		// quoted string, the go escapes are allowed
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					break
+				}
+				l.pos += i + 1
+				return token{typ: tokString, val: v}
+			}
+		}
+	case isWord(c):
+		i := 1
+		for i < len(s) && isWord(s[i]) {
+			i++
+		}
+		l.pos += i
+		return token{typ: tokWord, val: s[:i]}
+	}
+	l.pos = len(l.s)
+	return token{typ: tokError}
+}
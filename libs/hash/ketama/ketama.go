@@ -73,3 +73,23 @@ func (h *HashRing) Hash(s string) string {
 
 	return h.ticks[i].node
 }
+
+// Shares returns the fraction of the hash space assigned to each node,
+// must be called after Bake.
+func (h *HashRing) Shares() (shares map[string]float64) {
+	const space = float64(1 << 32)
+	shares = make(map[string]float64)
+	if h.length == 0 {
+		return
+	} else if h.length == 1 {
+		shares[h.ticks[0].node] = 1
+		return
+	}
+	prev := h.ticks[h.length-1].hash
+	for i := 0; i < h.length; i++ {
+		// a tick owns the keys between the previous tick and itself
+		shares[h.ticks[i].node] += float64(uint32(h.ticks[i].hash-prev)) / space
+		prev = h.ticks[i].hash
+	}
+	return
+}
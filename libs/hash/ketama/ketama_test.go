@@ -17,3 +17,20 @@ func Benchmark_Hash(b *testing.B) {
 		ring.Hash(strconv.Itoa(i))
 	}
 }
+
+func TestShares(t *testing.T) {
+	ring := NewRing(Base)
+	ring.AddNode("node1", 1)
+	ring.AddNode("node2", 2)
+	ring.Bake()
+	shares := ring.Shares()
+	if len(shares) != 2 {
+		t.Fatalf("ring.Shares() got %v", shares)
+	}
+	if sum := shares["node1"] + shares["node2"]; sum < 0.999 || sum > 1.001 {
+		t.Errorf("sum of shares %f, want 1", sum)
+	}
+	if shares["node2"] <= shares["node1"] {
+		t.Errorf("node2 share %f not greater than node1 %f", shares["node2"], shares["node1"])
+	}
+}
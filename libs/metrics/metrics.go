@@ -0,0 +1,271 @@
+// Package metrics is a small prometheus text exposition of labeled counters,
+// gauges and histograms.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	labelSep = "\xff"
+)
+
+var (
+	// DefBuckets is the default histogram buckets, in seconds.
+	DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// value is a float64 updated atomically.
+type value struct {
+	bits uint64
+}
+
+func (v *value) Add(f float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		if atomic.CompareAndSwapUint64(&v.bits, old, math.Float64bits(math.Float64frombits(old)+f)) {
+			return
+		}
+	}
+}
+
+func (v *value) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// vec is the children of a metric by label values.
+type vec struct {
+	name     string
+	help     string
+	typ      string
+	labels   []string
+	lock     sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+	new      func() interface{}
+}
+
+func newVec(name, help, typ string, labels []string, new func() interface{}) *vec {
+	return &vec{name: name, help: help, typ: typ, labels: labels, children: make(map[string]interface{}), values: make(map[string][]string), new: new}
+}
+
+func (v *vec) Name() string {
+	return v.name
+}
+
+// with get or create the child of the label values, the number of values
+// must be same as the labels.
+func (v *vec) with(vs []string) interface{} {
+	if len(vs) != len(v.labels) {
+		panic("metrics: " + v.name + " label values not match labels")
+	}
+	key := strings.Join(vs, labelSep)
+	v.lock.RLock()
+	c, ok := v.children[key]
+	v.lock.RUnlock()
+	if ok {
+		return c
+	}
+	v.lock.Lock()
+	if c, ok = v.children[key]; !ok {
+		c = v.new()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), vs...)
+	}
+	v.lock.Unlock()
+	return c
+}
+
+// each call f with the children sorted by label values.
+func (v *vec) each(f func(vs []string, c interface{})) {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f(v.values[k], v.children[k])
+	}
+	v.lock.RUnlock()
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add add a non-negative delta.
+func (c *Counter) Add(f float64) {
+	if f < 0 {
+		return
+	}
+	c.v.Add(f)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.Get()
+}
+
+// CounterVec is counters partitioned by labels.
+type CounterVec struct {
+	*vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels, func() interface{} { return new(Counter) })}
+}
+
+func (c *CounterVec) WithLabelValues(vs ...string) *Counter {
+	return c.with(vs).(*Counter)
+}
+
+func (c *CounterVec) write(w *writer) {
+	w.header(c.vec)
+	c.each(func(vs []string, m interface{}) {
+		w.sample(c.name, c.labels, vs, "", "", m.(*Counter).Value())
+	})
+}
+
+// Gauge is a value can go up and down.
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.Set(f)
+}
+
+func (g *Gauge) Add(f float64) {
+	g.v.Add(f)
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.Get()
+}
+
+// GaugeVec is gauges partitioned by labels.
+type GaugeVec struct {
+	*vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels, func() interface{} { return new(Gauge) })}
+}
+
+func (g *GaugeVec) WithLabelValues(vs ...string) *Gauge {
+	return g.with(vs).(*Gauge)
+}
+
+func (g *GaugeVec) write(w *writer) {
+	w.header(g.vec)
+	g.each(func(vs []string, m interface{}) {
+		w.sample(g.name, g.labels, vs, "", "", m.(*Gauge).Value())
+	})
+}
+
+// GaugeFunc is a gauge of which value is got by the func when collected.
+type GaugeFunc struct {
+	*vec
+	f func() float64
+}
+
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	return &GaugeFunc{vec: newVec(name, help, "gauge", nil, nil), f: f}
+}
+
+func (g *GaugeFunc) write(w *writer) {
+	w.header(g.vec)
+	w.sample(g.name, nil, nil, "", "", g.f())
+}
+
+// Histogram counts the observations in buckets.
+type Histogram struct {
+	upper  []float64
+	counts []uint64
+	count  uint64
+	sum    value
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(f float64) {
+	i := sort.SearchFloat64s(h.upper, f)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	h.sum.Add(f)
+	atomic.AddUint64(&h.count, 1)
+}
+
+// HistogramVec is histograms partitioned by labels.
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec new a histogram vec, the buckets must be sorted, nil means
+// DefBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{vec: newVec(name, help, "histogram", labels, func() interface{} { return newHistogram(buckets) }), buckets: buckets}
+}
+
+func (h *HistogramVec) WithLabelValues(vs ...string) *Histogram {
+	return h.with(vs).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *writer) {
+	w.header(h.vec)
+	h.each(func(vs []string, m interface{}) {
+		var (
+			hi  = m.(*Histogram)
+			cum uint64
+		)
+		for i, upper := range hi.upper {
+			cum += atomic.LoadUint64(&hi.counts[i])
+			w.sample(h.name+"_bucket", h.labels, vs, "le", formatFloat(upper), float64(cum))
+		}
+		count := atomic.LoadUint64(&hi.count)
+		w.sample(h.name+"_bucket", h.labels, vs, "le", "+Inf", float64(count))
+		w.sample(h.name+"_sum", h.labels, vs, "", "", hi.sum.Get())
+		w.sample(h.name+"_count", h.labels, vs, "", "", float64(count))
+	})
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
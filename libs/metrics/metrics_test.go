@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var (
+		r   = NewRegistry()
+		c   = NewCounterVec("test_push_total", "pushes by type.", "type")
+		g   = NewGaugeVec("test_online", "online by proto.", "proto")
+		h   = NewHistogramVec("test_latency_seconds", "latency.", []float64{0.1, 1}, "method")
+		f   = NewGaugeFunc("test_rooms", "rooms.", func() float64 { return 3 })
+		buf bytes.Buffer
+	)
+	r.MustRegister(c, g, h, f)
+	c.WithLabelValues("room").Inc()
+	c.WithLabelValues("room").Add(2)
+	c.WithLabelValues("push").Inc()
+	g.WithLabelValues("tcp").Inc()
+	g.WithLabelValues("tcp").Inc()
+	g.WithLabelValues("tcp").Dec()
+	g.WithLabelValues("ws\"1\"").Set(5)
+	h.WithLabelValues("Push").Observe(0.05)
+	h.WithLabelValues("Push").Observe(0.5)
+	h.WithLabelValues("Push").Observe(5)
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP test_latency_seconds latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{method="Push",le="0.1"} 1
+test_latency_seconds_bucket{method="Push",le="1"} 2
+test_latency_seconds_bucket{method="Push",le="+Inf"} 3
+test_latency_seconds_sum{method="Push"} 5.55
+test_latency_seconds_count{method="Push"} 3
+# HELP test_online online by proto.
+# TYPE test_online gauge
+test_online{proto="tcp"} 1
+test_online{proto="ws\"1\""} 5
+# HELP test_push_total pushes by type.
+# TYPE test_push_total counter
+test_push_total{type="push"} 1
+test_push_total{type="room"} 3
+# HELP test_rooms rooms.
+# TYPE test_rooms gauge
+test_rooms 3
+`
+	if buf.String() != expect {
+		t.Fatalf("got:\n%s\nexpect:\n%s", buf.String(), expect)
+	}
+	// handler
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Header().Get("Content-Type") != contentType || w.Body.String() != expect {
+		t.Fatalf("handler: %s", w.Body.String())
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister(NewCounterVec("test_total", "test."))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate not panic")
+		}
+	}()
+	r.MustRegister(NewCounterVec("test_total", "test."))
+}
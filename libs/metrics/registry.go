@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// DefaultRegistry is the registry of the process, exposed by Handler.
+	DefaultRegistry = NewRegistry()
+
+	helpEscaper  = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+)
+
+// Collector is a metric can be registered, such as CounterVec.
+type Collector interface {
+	Name() string
+	write(w *writer)
+}
+
+// Registry is a set of collectors by name.
+type Registry struct {
+	lock       sync.RWMutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// MustRegister register the collectors, panic if the name registered.
+func (r *Registry) MustRegister(cs ...Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, c := range cs {
+		if _, ok := r.collectors[c.Name()]; ok {
+			panic("metrics: " + c.Name() + " already registered")
+		}
+		r.collectors[c.Name()] = c
+	}
+}
+
+// WriteTo write all the collectors in text format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	var (
+		buf   bytes.Buffer
+		wr    = &writer{bufio.NewWriter(&buf)}
+		names []string
+	)
+	r.lock.RLock()
+	names = make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.collectors[name].write(wr)
+	}
+	r.lock.RUnlock()
+	if err = wr.Flush(); err != nil {
+		return
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler return the http handler of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if _, err := r.WriteTo(w); err != nil {
+			log.Error("metrics write error(%v)", err)
+		}
+	})
+}
+
+// MustRegister register the collectors to the DefaultRegistry.
+func MustRegister(cs ...Collector) {
+	DefaultRegistry.MustRegister(cs...)
+}
+
+// Handler return the http handler of the DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+type writer struct {
+	*bufio.Writer
+}
+
+func (w *writer) header(v *vec) {
+	w.WriteString("# HELP ")
+	w.WriteString(v.name)
+	w.WriteByte(' ')
+	w.WriteString(helpEscaper.Replace(v.help))
+	w.WriteString("\n# TYPE ")
+	w.WriteString(v.name)
+	w.WriteByte(' ')
+	w.WriteString(v.typ)
+	w.WriteByte('\n')
+}
+
+// sample write a sample line, the extra label is appended if not empty.
+func (w *writer) sample(name string, labels, vs []string, extra, extraValue string, f float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.label(l, vs[i])
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.label(extra, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(f))
+	w.WriteByte('\n')
+}
+
+func (w *writer) label(name, value string) {
+	w.WriteString(name)
+	w.WriteString("=\"")
+	w.WriteString(labelEscaper.Replace(value))
+	w.WriteByte('"')
+}
@@ -22,6 +22,7 @@ const (
 
 	continuationFrame        = 0
 	continuationFrameMaxRead = 100
+	maxPeekLen               = 1 << 30
 )
 
 // The message types are defined in RFC 6455, section 11.8.
@@ -106,6 +107,35 @@ func (c *Conn) WriteHeader(msgType int, length int) (err error) {
 	return
 }
 
+// HeaderSize get the frame header size of the payload length.
+func HeaderSize(length int) int {
+	switch {
+	case length <= 125:
+		return 2
+	case length < 65536:
+		return 4
+	default:
+		return 10
+	}
+}
+
+// PutHeader encode the frame header of the payload length into b, which
+// must be HeaderSize(length) bytes at least, returns the header size.
+func PutHeader(b []byte, msgType int, length int) (n int) {
+	b[0] = finBit | byte(msgType)
+	switch n = HeaderSize(length); n {
+	case 2:
+		b[1] = byte(length)
+	case 4:
+		b[1] = 126
+		binary.BigEndian.PutUint16(b[2:], uint16(length))
+	default:
+		b[1] = 127
+		binary.BigEndian.PutUint64(b[2:], uint64(length))
+	}
+	return
+}
+
 // write body
 func (c *Conn) WriteBody(b []byte) (err error) {
 	if len(b) > 0 {
@@ -114,6 +144,14 @@ func (c *Conn) WriteBody(b []byte) (err error) {
 	return
 }
 
+// write the encoded frames, without copy if the writer buffer is empty
+func (c *Conn) WriteRaw(b []byte) (err error) {
+	if len(b) > 0 {
+		_, err = c.w.WriteRaw(b)
+	}
+	return
+}
+
 // write peek
 func (c *Conn) Peek(n int) ([]byte, error) {
 	return c.w.Peek(n)
@@ -177,6 +215,53 @@ func (c *Conn) ReadMessage() (op int, payload []byte, err error) {
 	return
 }
 
+// PeekMessage check the frames of a whole message are buffered without
+// advancing the reader, so the following ReadMessage does not block, the
+// error is the reader's if not.
+func (c *Conn) PeekMessage() (err error) {
+	var (
+		b          []byte
+		off        int
+		n          int
+		payloadLen uint64
+	)
+	for i := 0; i <= continuationFrameMaxRead; i++ {
+		if b, err = c.r.Peek(off + 2); err != nil {
+			return
+		}
+		fin, op, mask := b[off]&finBit != 0, int(b[off]&opBit), b[off+1]&maskBit != 0
+		switch payloadLen, n = uint64(b[off+1]&lenBit), 2; payloadLen {
+		case 126:
+			if b, err = c.r.Peek(off + 4); err != nil {
+				return
+			}
+			payloadLen, n = uint64(binary.BigEndian.Uint16(b[off+2:])), 4
+		case 127:
+			if b, err = c.r.Peek(off + 10); err != nil {
+				return
+			}
+			payloadLen, n = binary.BigEndian.Uint64(b[off+2:]), 10
+		}
+		if mask {
+			n += 4
+		}
+		// ErrBufferFull if too large to buffer
+		if payloadLen > maxPeekLen {
+			return bufio.ErrBufferFull
+		}
+		if off += n + int(payloadLen); off < 0 {
+			return bufio.ErrBufferFull
+		}
+		if _, err = c.r.Peek(off); err != nil {
+			return
+		}
+		if fin || (op != continuationFrame && op != TextMessage && op != BinaryMessage) {
+			return
+		}
+	}
+	return
+}
+
 // read a frame
 func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
 	var (
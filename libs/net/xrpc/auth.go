@@ -0,0 +1,168 @@
+package xrpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const (
+	authTimeout   = 5 * time.Second
+	authNonceSize = 32
+)
+
+var (
+	ErrAuthSecret = errors.New("rpc secret auth failed")
+	ErrAuthCA     = errors.New("rpc tls ca file has no certificate")
+
+	authClientLabel = []byte("goim rpc client")
+	authServerLabel = []byte("goim rpc server")
+)
+
+// AuthOptions is the auth configuration of the internal rpc links, both
+// tls and secret are optional. if TLSCA is set, the peer must present a
+// certificate signed by it (mutual tls).
+type AuthOptions struct {
+	TLSOpen       bool
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	TLSServerName string
+	Secret        string
+}
+
+// NewAuthOptions new the auth options by the rpc.auth config of a service.
+func NewAuthOptions(tlsOpen bool, tlsCert, tlsKey, tlsCA, tlsServerName, secret string) *AuthOptions {
+	return &AuthOptions{
+		TLSOpen:       tlsOpen,
+		TLSCert:       tlsCert,
+		TLSKey:        tlsKey,
+		TLSCA:         tlsCA,
+		TLSServerName: tlsServerName,
+		Secret:        secret,
+	}
+}
+
+// ServerOptions return the rpc server options.
+func (o *AuthOptions) ServerOptions() (options ServerOptions, err error) {
+	options.Secret = o.Secret
+	if !o.TLSOpen {
+		return
+	}
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(o.TLSCert, o.TLSKey); err != nil {
+		return
+	}
+	options.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if o.TLSCA != "" {
+		if options.TLS.ClientCAs, err = loadCA(o.TLSCA); err != nil {
+			return
+		}
+		options.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
+
+// ClientTLS return the rpc client tls config, nil if tls not open.
+func (o *AuthOptions) ClientTLS() (config *tls.Config, err error) {
+	if !o.TLSOpen {
+		return
+	}
+	config = &tls.Config{ServerName: o.TLSServerName}
+	if o.TLSCert != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(o.TLSCert, o.TLSKey); err != nil {
+			return
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.TLSCA != "" {
+		config.RootCAs, err = loadCA(o.TLSCA)
+	}
+	return
+}
+
+func loadCA(file string) (pool *x509.CertPool, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(file); err != nil {
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		err = ErrAuthCA
+	}
+	return
+}
+
+func authMac(secret string, label, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(label)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// serverAuth do the secret challenge with client, both side prove they know
+// the secret without send it.
+// server -> client: nonceS
+// client -> server: mac(client, nonceS) + nonceC
+// server -> client: mac(server, nonceC)
+func serverAuth(conn net.Conn, secret string) (err error) {
+	var (
+		nonce = make([]byte, authNonceSize)
+		buf   = make([]byte, sha256.Size+authNonceSize)
+	)
+	if err = conn.SetDeadline(time.Now().Add(authTimeout)); err != nil {
+		return
+	}
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	if _, err = conn.Write(nonce); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	if !hmac.Equal(buf[:sha256.Size], authMac(secret, authClientLabel, nonce)) {
+		return ErrAuthSecret
+	}
+	if _, err = conn.Write(authMac(secret, authServerLabel, buf[sha256.Size:])); err != nil {
+		return
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// clientAuth do the secret challenge with server.
+func clientAuth(conn net.Conn, secret string) (err error) {
+	var (
+		nonce = make([]byte, authNonceSize)
+		buf   = make([]byte, sha256.Size+authNonceSize)
+	)
+	if err = conn.SetDeadline(time.Now().Add(authTimeout)); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(conn, nonce); err != nil {
+		return
+	}
+	copy(buf, authMac(secret, authClientLabel, nonce))
+	if _, err = rand.Read(buf[sha256.Size:]); err != nil {
+		return
+	}
+	if _, err = conn.Write(buf); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(conn, nonce); err != nil {
+		return
+	}
+	if !hmac.Equal(nonce, authMac(secret, authServerLabel, buf[sha256.Size:])) {
+		return ErrAuthSecret
+	}
+	return conn.SetDeadline(time.Time{})
+}
@@ -0,0 +1,32 @@
+package xrpc
+
+import (
+	"net"
+	"testing"
+)
+
+func testAuth(serverSecret, clientSecret string) (serr, cerr error) {
+	s, c := net.Pipe()
+	defer s.Close()
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() {
+		err := serverAuth(s, serverSecret)
+		if err != nil {
+			s.Close()
+		}
+		done <- err
+	}()
+	cerr = clientAuth(c, clientSecret)
+	serr = <-done
+	return
+}
+
+func TestSecretAuth(t *testing.T) {
+	if serr, cerr := testAuth("secret", "secret"); serr != nil || cerr != nil {
+		t.Errorf("same secret auth failed, server error(%v), client error(%v)", serr, cerr)
+	}
+	if serr, cerr := testAuth("secret", "guess"); serr != ErrAuthSecret || cerr == nil {
+		t.Errorf("wrong secret auth passed, server error(%v), client error(%v)", serr, cerr)
+	}
+}
@@ -1,6 +1,7 @@
 package xrpc
 
 import (
+	"crypto/tls"
 	"errors"
 	"goim/libs/proto"
 	"net"
@@ -23,8 +24,10 @@ var (
 
 // Rpc client options.
 type ClientOptions struct {
-	Proto string
-	Addr  string
+	Proto  string
+	Addr   string
+	TLS    *tls.Config // optional, tls handshake after dial
+	Secret string      // optional, secret auth handshake after dial
 }
 
 // Client is rpc client.
@@ -49,9 +52,27 @@ func (c *Client) dial() (err error) {
 	conn, err = net.DialTimeout(c.options.Proto, c.options.Addr, dialTimeout)
 	if err != nil {
 		log.Error("net.Dial(%s, %s), error(%v)", c.options.Proto, c.options.Addr, err)
-	} else {
-		c.Client = rpc.NewClient(conn)
+		return
+	}
+	if c.options.TLS != nil {
+		tc := tls.Client(conn, c.options.TLS)
+		tc.SetDeadline(time.Now().Add(authTimeout))
+		if err = tc.Handshake(); err != nil {
+			log.Error("rpc tls handshake(%s, %s) error(%v)", c.options.Proto, c.options.Addr, err)
+			conn.Close()
+			return
+		}
+		tc.SetDeadline(time.Time{})
+		conn = tc
 	}
+	if c.options.Secret != "" {
+		if err = clientAuth(conn, c.options.Secret); err != nil {
+			log.Error("rpc secret auth(%s, %s) error(%v)", c.options.Proto, c.options.Addr, err)
+			conn.Close()
+			return
+		}
+	}
+	c.Client = rpc.NewClient(conn)
 	return
 }
 
@@ -59,14 +80,22 @@ func (c *Client) dial() (err error) {
 func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) (err error) {
 	if c.Client == nil {
 		err = ErrRpc
+		callErrors.WithLabelValues(serviceMethod, "unavailable").Inc()
 		return
 	}
+	start := time.Now()
 	select {
 	case call := <-c.Client.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done:
 		err = call.Error
 	case <-time.After(callTimeout):
 		err = ErrRpcTimeout
 	}
+	callDuration.WithLabelValues(serviceMethod).Observe(time.Since(start).Seconds())
+	if err == ErrRpcTimeout {
+		callErrors.WithLabelValues(serviceMethod, "timeout").Inc()
+	} else if err != nil {
+		callErrors.WithLabelValues(serviceMethod, "error").Inc()
+	}
 	return
 }
 
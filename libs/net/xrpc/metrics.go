@@ -0,0 +1,14 @@
+package xrpc
+
+import (
+	"goim/libs/metrics"
+)
+
+var (
+	callDuration = metrics.NewHistogramVec("goim_rpc_client_duration_seconds", "rpc client call latency by method.", nil, "method")
+	callErrors   = metrics.NewCounterVec("goim_rpc_client_errors_total", "rpc client call errors by method and error.", "method", "error")
+)
+
+func init() {
+	metrics.MustRegister(callDuration, callErrors)
+}
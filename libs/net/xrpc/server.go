@@ -0,0 +1,54 @@
+package xrpc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/rpc"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// Rpc server options.
+type ServerOptions struct {
+	TLS    *tls.Config
+	Secret string
+}
+
+// Accept accepts connections on the listener and serves requests for each
+// incoming connection by the rpc.DefaultServer, after the tls and secret
+// handshake if set. the rejected peers are logged. Accept blocks until the
+// listener returns a non-nil error.
+func Accept(lis net.Listener, options ServerOptions) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Error("listener.Accept(\"%s\") error(%v)", lis.Addr().String(), err)
+			return
+		}
+		go serveConn(conn, options)
+	}
+}
+
+func serveConn(conn net.Conn, options ServerOptions) {
+	var err error
+	if options.TLS != nil {
+		tc := tls.Server(conn, options.TLS)
+		tc.SetDeadline(time.Now().Add(authTimeout))
+		if err = tc.Handshake(); err != nil {
+			log.Error("rpc reject peer: \"%s\" tls handshake error(%v)", conn.RemoteAddr().String(), err)
+			conn.Close()
+			return
+		}
+		tc.SetDeadline(time.Time{})
+		conn = tc
+	}
+	if options.Secret != "" {
+		if err = serverAuth(conn, options.Secret); err != nil {
+			log.Error("rpc reject peer: \"%s\" secret auth error(%v)", conn.RemoteAddr().String(), err)
+			conn.Close()
+			return
+		}
+	}
+	rpc.ServeConn(conn)
+}
@@ -21,8 +21,10 @@ type PushMsgsReply struct {
 }
 
 type MPushMsgArg struct {
-	Keys []string
-	P    Proto
+	Keys    []string
+	Seqs    []int32 // the message seq of each key as the proto seq, optional
+	P       Proto
+	TraceId string
 }
 
 type MPushMsgReply struct {
@@ -38,14 +40,35 @@ type MPushMsgsReply struct {
 }
 
 type BoardcastArg struct {
-	P Proto
+	P       Proto
+	TraceId string
+	Filter  string // targeting filter expression, empty for all
 }
 
 type BoardcastRoomArg struct {
-	RoomId int32
-	P      Proto
+	RoomId   string
+	P        Proto
+	TraceIds []string // trace ids of the batched messages
+}
+
+type BoardcastTopicArg struct {
+	Topic   string
+	P       Proto
+	TraceId string
+}
+
+type TopicsReply struct {
+	Topics map[string]struct{}
 }
 
 type RoomsReply struct {
-	RoomIds map[int32]struct{}
+	RoomIds map[string]struct{}
+}
+
+type CometStatReply struct {
+	TcpOnline      int64
+	WsOnline       int64
+	Channels       int
+	Rooms          int
+	SpeedMsgSecond uint64
 }
@@ -0,0 +1,103 @@
+package proto
+
+import (
+	"encoding/json"
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"goim/libs/encoding/binary"
+	"goim/libs/net/websocket"
+)
+
+// Frame is a pushed proto encoded once for tcp and websocket, shared by
+// reference by all the channels it is pushed to, so a broadcast does not
+// encode the same header for every channel. the encoded bytes must not be
+// modified, the buffer is put back to the pool after the last Unref.
+type Frame struct {
+	buf *bytes.Buffer
+	tcp []byte
+	ws  []byte
+}
+
+// NewFrame encode the proto into a buffer of the pool referenced once, the
+// websocket frames are the text messages written by WriteWebsocket.
+func NewFrame(p *Proto, pool *bytes.Pool) (f *Frame) {
+	var (
+		n      int
+		tcpLen int
+		wsLen  int
+		buf    []byte
+		msgs   []json.RawMessage
+	)
+	if p.Operation == define.OP_RAW {
+		msgs = p.packBodyasArray()
+		for _, m := range msgs {
+			wsLen += websocket.HeaderSize(len(m)) + len(m)
+		}
+	} else {
+		tcpLen = RawHeaderSize + len(p.Body)
+		wsLen = websocket.HeaderSize(tcpLen) + tcpLen
+	}
+	f = &Frame{buf: pool.GetRef(tcpLen + wsLen)}
+	buf = f.buf.Bytes()
+	if p.Operation == define.OP_RAW {
+		// job concact protos into the tcp frames already
+		f.tcp = p.Body
+		for _, m := range msgs {
+			n += websocket.PutHeader(buf[n:], websocket.TextMessage, len(m))
+			n += copy(buf[n:], m)
+		}
+		f.ws = buf[:n]
+		return
+	}
+	f.tcp = buf[:tcpLen]
+	binary.BigEndian.PutInt32(f.tcp[PackOffset:], int32(tcpLen))
+	binary.BigEndian.PutInt16(f.tcp[HeaderOffset:], int16(RawHeaderSize))
+	binary.BigEndian.PutInt16(f.tcp[VerOffset:], p.Ver)
+	binary.BigEndian.PutInt32(f.tcp[OperationOffset:], p.Operation)
+	binary.BigEndian.PutInt32(f.tcp[SeqIdOffset:], p.SeqId)
+	copy(f.tcp[RawHeaderSize:], p.Body)
+	// the text message keeps the header room without the header
+	n = tcpLen + websocket.PutHeader(buf[tcpLen:], websocket.TextMessage, tcpLen)
+	for i := 0; i < RawHeaderSize; i++ {
+		buf[n+i] = 0
+	}
+	n += RawHeaderSize
+	n += copy(buf[n:], p.Body)
+	f.ws = buf[tcpLen:n]
+	return
+}
+
+// TCP get the encoded tcp frame.
+func (f *Frame) TCP() []byte {
+	return f.tcp
+}
+
+// Websocket get the encoded websocket frames.
+func (f *Frame) Websocket() []byte {
+	return f.ws
+}
+
+// Ref add a reference to the frame, nil ignores.
+func (f *Frame) Ref() {
+	if f != nil {
+		f.buf.Ref()
+	}
+}
+
+// Unref drop a reference to the frame, nil ignores.
+func (f *Frame) Unref() {
+	if f != nil {
+		f.buf.Unref()
+	}
+}
+
+// Frame get the shared frame of the proto, nil if not encoded.
+func (p *Proto) Frame() *Frame {
+	return p.frame
+}
+
+// SetFrame set the shared frame of the proto, it must be encoded from the
+// proto.
+func (p *Proto) SetFrame(f *Frame) {
+	p.frame = f
+}
@@ -3,9 +3,15 @@ package proto
 // TODO optimize struct after replace kafka
 type KafkaMsg struct {
 	OP       string   `json:"op"`
-	RoomId   int32    `json:"roomid,omitempty"`
+	RoomId   RoomId   `json:"roomid,omitempty"`
+	Topic    string   `json:"topic,omitempty"`
 	ServerId int32    `json:"server,omitempty"`
 	SubKeys  []string `json:"subkeys,omitempty"`
+	Seqs     []int32  `json:"seqs,omitempty"` // the message seq of each sub key
 	Msg      []byte   `json:"msg"`
 	Ensure   bool     `json:"ensure,omitempty"`
+	Time     int64    `json:"time,omitempty"`   // produced unix time in milliseconds
+	TraceId  string   `json:"trace,omitempty"`  // push trace id
+	Filter   string   `json:"filter,omitempty"` // broadcast filter expression
+	Expire   int64    `json:"expire,omitempty"` // deadline in unix milliseconds, 0 never
 }
@@ -6,15 +6,45 @@ type ConnArg struct {
 }
 
 type ConnReply struct {
-	Key    string
-	RoomId int32
+	Key      string
+	RoomId   string
+	Attrs    map[string]string // platform, version, locale, region etc.
+	RoomFull bool              // refused for the room is full
 }
 
 type DisconnArg struct {
 	Key    string
-	RoomId int32
+	RoomId string
+	Server int32
 }
 
 type DisconnReply struct {
 	Has bool
 }
+
+type RoomHistoryArg struct {
+	RoomId string
+	N      int   // the latest n messages, 0 all
+	Since  int64 // the messages after it, unix milliseconds
+}
+
+// RoomHistoryMsg is a message kept in the room history.
+type RoomHistoryMsg struct {
+	Msg    []byte `json:"msg"`
+	Time   int64  `json:"time"`             // unix milliseconds
+	Expire int64  `json:"expire,omitempty"` // unix milliseconds, 0 never
+}
+
+type RoomHistoryReply struct {
+	Msgs []*RoomHistoryMsg
+}
+
+// ResendArg get the messages of the key's user after the seq.
+type ResendArg struct {
+	Key   string
+	Since int32
+}
+
+type ResendReply struct {
+	Msgs []*OutboxMsg
+}
@@ -11,6 +11,7 @@ import (
 	"goim/libs/net/websocket"
 
 	"strconv"
+	"time"
 
 	log "github.com/thinkboy/log4go"
 )
@@ -62,12 +63,20 @@ type Proto struct {
 	Operation int32           `json:"op"`   // operation for request
 	SeqId     int32           `json:"seq"`  // sequence number chosen by client
 	Body      json.RawMessage `json:"body"` // binary body bytes(json.RawMessage is []byte)
+	Expire    int64           `json:"-"`    // deadline in unix milliseconds, 0 never, not on the wire
+
+	frame *Frame // encoded once for the broadcasts, nil if not
 }
 
 func (p *Proto) Reset() {
 	*p = emptyProto
 }
 
+// Expired report whether the proto is past its deadline.
+func (p *Proto) Expired() bool {
+	return p.Expire > 0 && time.Now().UnixNano()/int64(time.Millisecond) > p.Expire
+}
+
 func (p *Proto) String() string {
 	return fmt.Sprintf("\n-------- proto --------\nver: %d\nop: %d\nseq: %d\nbody: %v\n-----------------------", p.Ver, p.Operation, p.SeqId, p.Body)
 }
@@ -116,6 +125,24 @@ func (p *Proto) ReadTCP(rr *bufio.Reader) (err error) {
 	return
 }
 
+// PeekTCP check a whole proto is buffered without advancing the reader, so
+// the following ReadTCP does not block, the error is the reader's if not.
+func PeekTCP(rr *bufio.Reader) (err error) {
+	var (
+		buf     []byte
+		packLen int32
+	)
+	if buf, err = rr.Peek(PackSize); err != nil {
+		return
+	}
+	if packLen = binary.BigEndian.Int32(buf[PackOffset:]); packLen < RawHeaderSize || packLen > MaxPackSize {
+		// invalid, ReadTCP returns the error
+		packLen = RawHeaderSize
+	}
+	_, err = rr.Peek(int(packLen))
+	return
+}
+
 func (p *Proto) WriteTCP(wr *bufio.Writer) (err error) {
 	var (
 		buf     []byte
@@ -0,0 +1,61 @@
+package proto
+
+import (
+	gbytes "bytes"
+	"goim/libs/bufio"
+	"goim/libs/bytes"
+	"goim/libs/define"
+	"testing"
+	"time"
+)
+
+func TestProtoExpired(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, c := range []struct {
+		expire  int64
+		expired bool
+	}{
+		{0, false},
+		{now - 1000, true},
+		{now + 60000, false},
+	} {
+		p := &Proto{Expire: c.expire}
+		if p.Expired() != c.expired {
+			t.Errorf("Proto{Expire: %d}.Expired() got %t, want %t", c.expire, !c.expired, c.expired)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	var (
+		out  gbytes.Buffer
+		pool = bytes.NewPool(1, 64)
+		p    = &Proto{Ver: 1, Operation: 5, SeqId: 7, Body: []byte(`{"a":1}`)}
+		wr   = bufio.NewWriterSize(&out, 128)
+	)
+	f := NewFrame(p, pool)
+	p.WriteTCP(wr)
+	wr.Flush()
+	if !gbytes.Equal(f.TCP(), out.Bytes()) {
+		t.Errorf("f.TCP() got %v, want %v", f.TCP(), out.Bytes())
+	}
+	ws := append([]byte{0x81, byte(RawHeaderSize + len(p.Body))}, make([]byte, RawHeaderSize)...)
+	if ws = append(ws, p.Body...); !gbytes.Equal(f.Websocket(), ws) {
+		t.Errorf("f.Websocket() got %v, want %v", f.Websocket(), ws)
+	}
+	f.Unref()
+	// raw, the concact tcp frames
+	out.Reset()
+	p.WriteTCP(wr)
+	p.WriteTCP(wr)
+	wr.Flush()
+	raw := &Proto{Operation: define.OP_RAW, Body: out.Bytes()}
+	if f = NewFrame(raw, pool); !gbytes.Equal(f.TCP(), raw.Body) {
+		t.Errorf("raw f.TCP() got %v, want %v", f.TCP(), raw.Body)
+	}
+	ws = append([]byte{0x81, byte(len(p.Body))}, p.Body...)
+	if ws = append(ws, ws...); !gbytes.Equal(f.Websocket(), ws) {
+		t.Errorf("raw f.Websocket() got %v, want %v", f.Websocket(), ws)
+	}
+	f.Unref()
+}
@@ -0,0 +1,45 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"goim/libs/define"
+	"strconv"
+)
+
+// oldNoRoom is the int32 room id of no room of the old clients.
+const oldNoRoom = "-1"
+
+// RoomId is a room id in json, the old clients and producers use int32
+// room ids, so a number is also accepted and an id of an int32 number is
+// marshaled as a number, the number -1 is no room.
+type RoomId string
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r RoomId) MarshalJSON() ([]byte, error) {
+	if i, err := strconv.ParseInt(string(r), 10, 32); err == nil && strconv.FormatInt(i, 10) == string(r) {
+		return []byte(r), nil
+	}
+	return json.Marshal(string(r))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *RoomId) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if len(b) > 0 && b[0] == '"' {
+		if err = json.Unmarshal(b, &s); err == nil {
+			*r = RoomId(s)
+		}
+		return
+	}
+	if bytes.Equal(b, []byte("null")) {
+		return
+	}
+	var i json.Number
+	if err = json.Unmarshal(b, &i); err == nil {
+		if *r = RoomId(i.String()); *r == oldNoRoom {
+			*r = define.NoRoom
+		}
+	}
+	return
+}
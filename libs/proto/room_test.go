@@ -0,0 +1,46 @@
+package proto
+
+import (
+	"encoding/json"
+	"goim/libs/define"
+	"testing"
+)
+
+func TestRoomId(t *testing.T) {
+	var m struct {
+		RoomId RoomId `json:"roomid"`
+	}
+	for in, want := range map[string]RoomId{
+		`{"roomid":1}`:          "1",
+		`{"roomid":-1}`:         define.NoRoom,
+		`{"roomid":"-1"}`:       "-1",
+		`{"roomid":"abc"}`:      "abc",
+		`{"roomid":"1"}`:        "1",
+		`{"roomid":null}`:       "",
+		`{"roomid":"a\"b"}`:     "a\"b",
+		`{"roomid":4294967296}`: "4294967296",
+	} {
+		m.RoomId = ""
+		if err := json.Unmarshal([]byte(in), &m); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error(%v)", in, err)
+		}
+		if m.RoomId != want {
+			t.Errorf("json.Unmarshal(%s) got %q, want %q", in, m.RoomId, want)
+		}
+	}
+	for id, want := range map[RoomId]string{
+		"1":          `{"roomid":1}`,
+		"01":         `{"roomid":"01"}`,
+		"abc":        `{"roomid":"abc"}`,
+		"4294967296": `{"roomid":"4294967296"}`,
+	} {
+		m.RoomId = id
+		b, err := json.Marshal(&m)
+		if err != nil {
+			t.Fatalf("json.Marshal(%q) error(%v)", id, err)
+		}
+		if string(b) != want {
+			t.Errorf("json.Marshal(%q) got %s, want %s", id, b, want)
+		}
+	}
+}
@@ -1,19 +1,21 @@
 package proto
 
 type PutArg struct {
-	UserId int64
-	Server int32
-	RoomId int32
+	UserId   int64
+	Server   int32
+	RoomId   string
+	Platform string // for the session policy
 }
 
 type PutReply struct {
-	Seq int32
+	Seq   int32
+	Kicks map[int32]int32 // seq:server evicted by the session policy
 }
 
 type DelArg struct {
 	UserId int64
 	Seq    int32
-	RoomId int32
+	RoomId string
 }
 
 type DelReply struct {
@@ -52,7 +54,7 @@ type CountReply struct {
 }
 
 type RoomCountArg struct {
-	RoomId int32
+	RoomId string
 }
 
 type RoomCountReply struct {
@@ -60,7 +62,7 @@ type RoomCountReply struct {
 }
 
 type AllRoomCountReply struct {
-	Counter map[int32]int32
+	Counter map[string]int32
 }
 
 type AllServerCountReply struct {
@@ -79,7 +81,7 @@ type UserCountReply struct {
 //作者：徐明祥
 //日期：2017-01-08
 type CleanRoomCountArg struct {
-	RoomId int32
+	RoomId string
 }
 
 //描述：清空房间人数响应
@@ -87,3 +89,90 @@ type CleanRoomCountArg struct {
 //日期：2017-01-08
 type CleanRoomCountReply struct {
 }
+
+type RouterStatReply struct {
+	Sessions int32 // sessions of all servers
+	Users    int
+	Rooms    int
+}
+
+// RoomMeta is the metadata of a room, Max is the max members of the room,
+// 0 is unlimited.
+type RoomMeta struct {
+	RoomId  string
+	Name    string
+	Owner   int64
+	Max     int32
+	Created int64 // unix seconds
+}
+
+type RoomArg struct {
+	RoomId string
+}
+
+type RoomReply struct {
+	Meta    *RoomMeta // nil if not set
+	Members int32
+}
+
+// RoomJoinArg join or leave a channel of the user on the comet to the room.
+type RoomJoinArg struct {
+	RoomId string
+	UserId int64
+	Server int32
+}
+
+type RoomJoinReply struct {
+	Full bool // refused for the room is full
+}
+
+type RoomUsersReply struct {
+	UserIds []int64
+}
+
+// RoomMember is the channels of the user on the comet in the room.
+type RoomMember struct {
+	RoomId string
+	UserId int64
+	Server int32
+	Count  int32
+}
+
+type RoomMembersReply struct {
+	Members []*RoomMember
+}
+
+// RoomSyncArg reset the members of the rooms owned by the router.
+type RoomSyncArg struct {
+	Members []*RoomMember
+}
+
+// MPushArg allocate the message seqs of the users and keep the message in
+// their outboxes.
+type MPushArg struct {
+	UserIds []int64
+	Msg     []byte
+	Expire  int64 // unix milliseconds, 0 never
+}
+
+type MPushReply struct {
+	UserIds  []int64
+	Sessions []*GetReply
+	MsgSeqs  []int32 // the message seq of each user
+}
+
+// OutboxMsg is a message kept in the outbox of a user.
+type OutboxMsg struct {
+	Seq    int32
+	Msg    []byte
+	Expire int64 // unix milliseconds, 0 never
+}
+
+type OutboxArg struct {
+	UserId int64
+	Since  int32 // the messages after the seq
+}
+
+type OutboxReply struct {
+	Msgs []*OutboxMsg
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket, rate tokens are put in every second and at most
+// burst tokens are kept.
+type Bucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket new a full token bucket.
+func NewBucket(rate, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow take a token from the bucket, return false if no token left.
+func (b *Bucket) Allow() bool {
+	return b.allow(time.Now())
+}
+
+func (b *Bucket) allow(now time.Time) (ok bool) {
+	b.lock.Lock()
+	b.fill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+	b.lock.Unlock()
+	return
+}
+
+// fill put the tokens in since last fill, must hold the lock.
+func (b *Bucket) fill(now time.Time) {
+	if d := now.Sub(b.last); d > 0 {
+		if b.tokens += d.Seconds() * b.rate; b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// full check the bucket is refilled, an idle full bucket is same as a new one.
+func (b *Bucket) full(now time.Time) (ok bool) {
+	b.lock.Lock()
+	b.fill(now)
+	ok = b.tokens >= b.burst
+	b.lock.Unlock()
+	return
+}
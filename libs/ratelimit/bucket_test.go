@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(10, 2)
+	now := b.last
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatal("burst not allowed")
+	}
+	if b.allow(now) {
+		t.Fatal("over burst allowed")
+	}
+	// 100ms put one token
+	now = now.Add(100 * time.Millisecond)
+	if !b.allow(now) {
+		t.Fatal("refilled token not allowed")
+	}
+	if b.allow(now) {
+		t.Fatal("over rate allowed")
+	}
+	// never over burst
+	now = now.Add(time.Hour)
+	if !b.allow(now) || !b.allow(now) || b.allow(now) {
+		t.Fatal("burst not kept")
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	bs := NewBuckets(1, 1)
+	now := time.Now()
+	if !bs.allow("a", now) || bs.allow("a", now) {
+		t.Fatal("key a limit error")
+	}
+	if !bs.allow("b", now) {
+		t.Fatal("key b limit error")
+	}
+	if bs.Len() != 2 {
+		t.Fatalf("len: %d", bs.Len())
+	}
+	// the refilled buckets are swept
+	now = now.Add(sweepInterval)
+	if !bs.allow("c", now) {
+		t.Fatal("key c limit error")
+	}
+	if bs.Len() != 1 {
+		t.Fatalf("len: %d", bs.Len())
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	sweepInterval = time.Minute
+)
+
+// Buckets is a set of token buckets by key, such as the client ip.
+// the full buckets are swept periodically.
+type Buckets struct {
+	lock    sync.Mutex
+	rate    int
+	burst   int
+	buckets map[string]*Bucket
+	sweep   time.Time
+}
+
+// NewBuckets new a buckets, each key has a bucket of rate and burst.
+func NewBuckets(rate, burst int) *Buckets {
+	return &Buckets{rate: rate, burst: burst, buckets: make(map[string]*Bucket), sweep: time.Now()}
+}
+
+// Allow take a token from the key bucket, return false if no token left.
+func (bs *Buckets) Allow(key string) bool {
+	return bs.allow(key, time.Now())
+}
+
+func (bs *Buckets) allow(key string, now time.Time) bool {
+	var (
+		ok bool
+		b  *Bucket
+	)
+	bs.lock.Lock()
+	if now.Sub(bs.sweep) >= sweepInterval {
+		for k, b := range bs.buckets {
+			if b.full(now) {
+				delete(bs.buckets, k)
+			}
+		}
+		bs.sweep = now
+	}
+	if b, ok = bs.buckets[key]; !ok {
+		b = NewBucket(bs.rate, bs.burst)
+		b.last = now
+		bs.buckets[key] = b
+	}
+	bs.lock.Unlock()
+	return b.allow(now)
+}
+
+// Len return the number of buckets.
+func (bs *Buckets) Len() (n int) {
+	bs.lock.Lock()
+	n = len(bs.buckets)
+	bs.lock.Unlock()
+	return
+}
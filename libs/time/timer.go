@@ -109,6 +109,19 @@ func (t *Timer) Add(expire itime.Duration, fn func()) (td *TimerData) {
 	return
 }
 
+// AddKey is Add with the key set before the timer data is in the heap, so
+// the key never races with the expire.
+func (t *Timer) AddKey(key string, expire itime.Duration, fn func()) (td *TimerData) {
+	t.lock.Lock()
+	td = t.get()
+	td.Key = key
+	td.expire = itime.Now().Add(expire)
+	td.fn = fn
+	t.add(td)
+	t.lock.Unlock()
+	return
+}
+
 // Del removes the element at index i from the heap.
 // The complexity is O(log(n)) where n = h.Len().
 func (t *Timer) Del(td *TimerData) {
@@ -0,0 +1,206 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	exportTimeout = 5 * time.Second
+	// otlp span kind and status code
+	spanKindInternal = 1
+	statusCodeOk     = 1
+	statusCodeError  = 2
+)
+
+// Exporter export the spans to an otlp/http json traces endpoint in batch.
+type Exporter struct {
+	options Options
+	client  *http.Client
+	spans   chan *Span
+	dropped uint64
+}
+
+// NewExporter new an exporter and start the export goroutine.
+func NewExporter(options Options) *Exporter {
+	if options.Batch <= 0 {
+		options.Batch = 512
+	}
+	if options.Interval <= 0 {
+		options.Interval = 5 * time.Second
+	}
+	if options.Queue <= 0 {
+		options.Queue = 10240
+	}
+	e := &Exporter{
+		options: options,
+		client:  &http.Client{Timeout: exportTimeout},
+		spans:   make(chan *Span, options.Queue),
+	}
+	go e.proc()
+	return e
+}
+
+// Export queue the span, drop it if the queue full.
+func (e *Exporter) Export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Dropped return the number of the dropped spans.
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+func (e *Exporter) proc() {
+	var (
+		s      *Span
+		spans  = make([]*Span, 0, e.options.Batch)
+		ticker = time.NewTicker(e.options.Interval)
+	)
+	for {
+		select {
+		case s = <-e.spans:
+			if spans = append(spans, s); len(spans) < e.options.Batch {
+				continue
+			}
+		case <-ticker.C:
+			if len(spans) == 0 {
+				continue
+			}
+		}
+		if err := e.post(spans); err != nil {
+			log.Error("trace export %d spans to \"%s\" error(%v)", len(spans), e.options.URL, err)
+		}
+		spans = spans[:0]
+	}
+}
+
+func (e *Exporter) post(spans []*Span) (err error) {
+	var (
+		body []byte
+		resp *http.Response
+	)
+	if body, err = json.Marshal(e.encode(spans)); err != nil {
+		return
+	}
+	if resp, err = e.client.Post(e.options.URL, "application/json", bytes.NewReader(body)); err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return
+}
+
+// otlp json, see opentelemetry-proto trace/v1/trace.proto.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func newValue(v interface{}) (o otlpValue) {
+	var s string
+	switch t := v.(type) {
+	case string:
+		o.StringValue = &t
+	case bool:
+		o.BoolValue = &t
+	case int:
+		s = strconv.FormatInt(int64(t), 10)
+		o.IntValue = &s
+	case int32:
+		s = strconv.FormatInt(int64(t), 10)
+		o.IntValue = &s
+	case int64:
+		s = strconv.FormatInt(t, 10)
+		o.IntValue = &s
+	default:
+		s = fmt.Sprint(t)
+		o.StringValue = &s
+	}
+	return
+}
+
+func (e *Exporter) encode(spans []*Span) *otlpTraces {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceId:           s.traceId,
+			SpanId:            s.spanId,
+			ParentSpanId:      s.parentId,
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: statusCodeOk},
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: a.key, Value: newValue(a.value)})
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: statusCodeError, Message: s.err.Error()}
+		}
+		ss = append(ss, o)
+	}
+	return &otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{{Key: "service.name", Value: newValue(e.options.Service)}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "goim"}, Spans: ss}},
+	}}}
+}
@@ -0,0 +1,188 @@
+// Package trace is a minimal push tracing, the trace id is generated at the
+// entry and carried by the messages, every hop logs and exports its spans in
+// the opentelemetry otlp/http json format.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	traceIdSize = 16
+	spanIdSize  = 8
+)
+
+var (
+	tracer atomic.Value // *Tracer
+)
+
+func init() {
+	tracer.Store(&Tracer{})
+}
+
+type Options struct {
+	Service  string        // service name, such as goim-logic
+	Sample   int           // sample one of every Sample traces, 0 means no trace
+	URL      string        // otlp/http json traces endpoint, empty only logs
+	Batch    int           // spans per export request
+	Interval time.Duration // max export interval
+	Queue    int           // spans waiting for export, the spans over are dropped
+}
+
+// Tracer create the spans and export the sampled ones.
+type Tracer struct {
+	options  Options
+	exporter *Exporter
+}
+
+// Init init the process tracer.
+func Init(options Options) {
+	t := &Tracer{options: options}
+	if options.Sample > 0 && options.URL != "" {
+		t.exporter = NewExporter(options)
+	}
+	tracer.Store(t)
+}
+
+func current() *Tracer {
+	return tracer.Load().(*Tracer)
+}
+
+// NewTraceId new a random trace id of 32 hex chars, empty if trace closed.
+func NewTraceId() string {
+	if current().options.Sample <= 0 {
+		return ""
+	}
+	return randomId(traceIdSize)
+}
+
+// ValidTraceId check the trace id is 32 hex chars and not all zero.
+func ValidTraceId(id string) bool {
+	if len(id) != traceIdSize*2 {
+		return false
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Sampled check the trace is sampled, all the hops get the same result by
+// the trace id if the same sample set.
+func Sampled(traceId string) bool {
+	sample := current().options.Sample
+	if sample <= 0 || len(traceId) < 16 {
+		return false
+	}
+	b, err := hex.DecodeString(traceId[:16])
+	if err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint64(b)%uint64(sample) == 0
+}
+
+func randomId(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Error("rand.Read() error(%v)", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Span is a hop of a trace, all the methods are safe on a nil span.
+type Span struct {
+	traceId  string
+	spanId   string
+	parentId string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []attr
+	err      error
+	sampled  bool
+}
+
+type attr struct {
+	key   string
+	value interface{}
+}
+
+// Start start a root span of the hop, return nil if the trace id is empty.
+// the span is not exported if the trace not sampled.
+func Start(traceId, name string) *Span {
+	if traceId == "" {
+		return nil
+	}
+	return &Span{traceId: traceId, spanId: randomId(spanIdSize), name: name, start: time.Now(), sampled: Sampled(traceId)}
+}
+
+// Child start a child span.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	c := Start(s.traceId, name)
+	c.parentId = s.spanId
+	return c
+}
+
+// TraceId return the trace id, empty if nil.
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+	return s.traceId
+}
+
+// Set set an attribute, the value is string, bool or integer, others are
+// formatted as string.
+func (s *Span) Set(key string, value interface{}) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.attrs = append(s.attrs, attr{key: key, value: value})
+}
+
+// Error mark the span failed.
+func (s *Span) Error(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err
+}
+
+// Finish end the span, log and export it if sampled.
+func (s *Span) Finish() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.end = time.Now()
+	log.Info("trace: %s span: %s parent: %s name: %s time: %fs attrs: %s error(%v)", s.traceId, s.spanId, s.parentId, s.name, s.end.Sub(s.start).Seconds(), s.attrsString(), s.err)
+	if e := current().exporter; e != nil {
+		e.Export(s)
+	}
+}
+
+func (s *Span) attrsString() string {
+	var b []byte
+	for i, a := range s.attrs {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, fmt.Sprintf("%s=%v", a.key, a.value)...)
+	}
+	return string(b)
+}
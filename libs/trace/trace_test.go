@@ -0,0 +1,83 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSampled(t *testing.T) {
+	Init(Options{Service: "test"})
+	if NewTraceId() != "" || Start("", "test") != nil {
+		t.Fatal("trace closed but id generated")
+	}
+	Init(Options{Service: "test", Sample: 1})
+	id := NewTraceId()
+	if !ValidTraceId(id) || !Sampled(id) {
+		t.Fatalf("trace id: %s not valid or sampled", id)
+	}
+	if ValidTraceId("00000000000000000000000000000000") || ValidTraceId("xyz") {
+		t.Fatal("invalid trace id passed")
+	}
+	// one of 4
+	Init(Options{Service: "test", Sample: 4})
+	if !Sampled("0000000000000008ffffffffffffffff") || Sampled("0000000000000009ffffffffffffffff") {
+		t.Fatal("sample not by trace id")
+	}
+	// nil span
+	var s *Span
+	s.Set("k", 1)
+	s.Error(errors.New("test"))
+	s.Finish()
+	if s.Child("c") != nil || s.TraceId() != "" {
+		t.Fatal("nil span")
+	}
+}
+
+func TestExport(t *testing.T) {
+	body := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body <- b
+	}))
+	defer srv.Close()
+	Init(Options{Service: "goim-test", Sample: 1, URL: srv.URL, Batch: 2, Interval: time.Second})
+	root := Start(NewTraceId(), "root")
+	root.Set("keys", 3)
+	child := root.Child("child")
+	child.Set("server", int32(1))
+	child.Error(errors.New("rpc timeout"))
+	child.Finish()
+	root.Finish()
+	var traces otlpTraces
+	select {
+	case b := <-body:
+		if err := json.Unmarshal(b, &traces); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("export timeout")
+	}
+	rs := traces.ResourceSpans[0]
+	if *rs.Resource.Attributes[0].Value.StringValue != "goim-test" {
+		t.Fatalf("resource: %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans: %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].ParentSpanId != spans[1].SpanId || spans[0].TraceId != spans[1].TraceId {
+		t.Fatalf("child span: %+v", spans[0])
+	}
+	if spans[0].Status.Code != statusCodeError || *spans[0].Attributes[0].Value.IntValue != "1" {
+		t.Fatalf("child span: %+v", spans[0])
+	}
+	if spans[1].Status.Code != statusCodeOk || *spans[1].Attributes[0].Value.IntValue != "3" {
+		t.Fatalf("root span: %+v", spans[1])
+	}
+	Init(Options{})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/net/xrpc"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	healthOK           = "ok"
+	healthUnconfigured = "unconfigured"
+	jobMonitorTimeout  = 2 * time.Second
+)
+
+var (
+	jobMonitorClient = &http.Client{Timeout: jobMonitorTimeout}
+)
+
+// CometNode is the admin view of a comet server.
+type CometNode struct {
+	ServerId       int32  `json:"server_id"`
+	Addr           string `json:"addr"`
+	Health         string `json:"health"`
+	TcpOnline      int64  `json:"tcp_online"`
+	WsOnline       int64  `json:"websocket_online"`
+	Channels       int    `json:"channels"`
+	Rooms          int    `json:"rooms"`
+	SpeedMsgSecond uint64 `json:"speed_msg_second"`
+	Sessions       int32  `json:"sessions"` // sessions counted by routers
+}
+
+// RouterNode is the admin view of a router node.
+type RouterNode struct {
+	Node     string  `json:"node"`
+	Addr     string  `json:"addr"`
+	Health   string  `json:"health"`
+	Sessions int32   `json:"sessions"`
+	Users    int     `json:"users"`
+	Rooms    int     `json:"rooms"`
+	Share    float64 `json:"share"` // ketama share of the user ids
+}
+
+// JobNode is the admin view of a job consumer.
+type JobNode struct {
+	Addr           string           `json:"addr"`
+	Health         string           `json:"health"`
+	ConsumeLag     int64            `json:"consume_lag"` // milliseconds
+	SpeedMsgSecond uint64           `json:"speed_msg_second"`
+	ActiveRooms    int              `json:"active_rooms"`
+	Comets         map[int32]string `json:"comets"`
+}
+
+// Topology is the cluster view.
+type Topology struct {
+	Comets  []*CometNode  `json:"comets"`
+	Routers []*RouterNode `json:"routers"`
+	Jobs    []*JobNode    `json:"jobs"`
+}
+
+func health(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return healthOK
+}
+
+// InitAdmin register the admin api on the monitor mux.
+func InitAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/topology", adminTopology)
+}
+
+// adminTopology get the cluster topology.
+// GET /admin/topology
+func adminTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{"ret": OK, "data": NewTopology()}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error("json.Marshal(%v) error(%v)", res, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(b)
+}
+
+// NewTopology fan out to all comets, routers and jobs.
+func NewTopology() (t *Topology) {
+	var (
+		wg          sync.WaitGroup
+		serverId    int32
+		_, sessions = Counts()
+		shares      = routerRing.Shares()
+	)
+	t = new(Topology)
+	// comets
+	for serverId, addr := range Conf.CometRPCAddrs {
+		node := &CometNode{ServerId: serverId, Addr: addr, Sessions: sessions[serverId]}
+		t.Comets = append(t.Comets, node)
+		wg.Add(1)
+		go func(c *xrpc.Clients) {
+			defer wg.Done()
+			stat, err := cometStat(c)
+			if err == nil {
+				node.TcpOnline, node.WsOnline, node.SpeedMsgSecond = stat.TcpOnline, stat.WsOnline, stat.SpeedMsgSecond
+				node.Channels = stat.Channels
+				// rooms with online users
+				node.Rooms, err = cometRooms(c)
+			}
+			node.Health = health(err)
+		}(cometServiceMap[serverId])
+	}
+	// comets only known by routers
+	for serverId = range sessions {
+		if _, ok := Conf.CometRPCAddrs[serverId]; !ok {
+			t.Comets = append(t.Comets, &CometNode{ServerId: serverId, Health: healthUnconfigured, Sessions: sessions[serverId]})
+		}
+	}
+	// routers
+	for name, addr := range Conf.RouterRPCAddrs {
+		node := &RouterNode{Node: name, Addr: addr, Share: shares[name]}
+		t.Routers = append(t.Routers, node)
+		wg.Add(1)
+		go func(c *xrpc.Clients) {
+			defer wg.Done()
+			stat, err := routerStat(c)
+			if err == nil {
+				node.Sessions, node.Users, node.Rooms = stat.Sessions, stat.Users, stat.Rooms
+			}
+			node.Health = health(err)
+		}(routerServiceMap[name])
+	}
+	// jobs
+	for _, addr := range Conf.JobMonitors {
+		node := &JobNode{Addr: addr}
+		t.Jobs = append(t.Jobs, node)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Health = health(jobStat(node))
+		}()
+	}
+	wg.Wait()
+	sort.Slice(t.Comets, func(i, j int) bool { return t.Comets[i].ServerId < t.Comets[j].ServerId })
+	sort.Slice(t.Routers, func(i, j int) bool { return t.Routers[i].Node < t.Routers[j].Node })
+	return
+}
+
+// jobStat read the job monitor stat.
+func jobStat(node *JobNode) (err error) {
+	var (
+		resp *http.Response
+		res  struct {
+			Data struct {
+				ConsumeLag      int64            `json:"consume_lag"`
+				SpeedMsgSecond  uint64           `json:"speed_msg_second"`
+				ActiveRoomCount int              `json:"active_room_count"`
+				CometNodes      map[int32]string `json:"comet_nodes"`
+			} `json:"data"`
+		}
+	)
+	if resp, err = jobMonitorClient.Get("http://" + node.Addr + "/monitor/stat"); err != nil {
+		log.Error("job monitor http.Get(%s) error(%v)", node.Addr, err)
+		return
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Error("job monitor %s json.Decode() error(%v)", node.Addr, err)
+		return
+	}
+	node.ConsumeLag = res.Data.ConsumeLag
+	node.SpeedMsgSecond = res.Data.SpeedMsgSecond
+	node.ActiveRooms = res.Data.ActiveRoomCount
+	node.Comets = res.Data.CometNodes
+	return
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/hash/ketama"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminTopology(t *testing.T) {
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	routerRing = ketama.NewRing(ketama.Base)
+	routerRing.Bake()
+	job := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ret":1,"data":{"consume_lag":5,"active_room_count":2}}`))
+	}))
+	defer job.Close()
+	oldJobs := Conf.JobMonitors
+	Conf.JobMonitors = []string{strings.TrimPrefix(job.URL, "http://")}
+	defer func() { Conf.JobMonitors = oldJobs }()
+	countLock.Lock()
+	ServerCountMap = map[int32]int32{1: 5}
+	countLock.Unlock()
+	// the counts are replaced while the topology reads them
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			countLock.Lock()
+			ServerCountMap = map[int32]int32{1: 5}
+			countLock.Unlock()
+		}
+	}()
+	w := httptest.NewRecorder()
+	adminTopology(w, httptest.NewRequest("GET", "/admin/topology", nil))
+	close(stop)
+	<-done
+	var res struct {
+		Ret  int      `json:"ret"`
+		Data Topology `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("json.Unmarshal(%s) error(%v)", w.Body.String(), err)
+	}
+	if res.Ret != OK || len(res.Data.Comets) != 1 || len(res.Data.Jobs) != 1 {
+		t.Fatalf("topology got %s", w.Body.String())
+	}
+	if c := res.Data.Comets[0]; c.ServerId != 1 || c.Sessions != 5 || c.Health != healthUnconfigured {
+		t.Errorf("comet got %+v", c)
+	}
+	if j := res.Data.Jobs[0]; j.Health != healthOK || j.ConsumeLag != 5 || j.ActiveRooms != 2 {
+		t.Errorf("job got %+v", j)
+	}
+	w = httptest.NewRecorder()
+	if adminTopology(w, httptest.NewRequest("POST", "/admin/topology", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST got %d", w.Code)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"goim/libs/ratelimit"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	// api types
+	apiSingle    = "single"
+	apiMulti     = "multi"
+	apiRoom      = "room"
+	apiBroadcast = "broadcast"
+	apiTopic     = "topic"
+	apiAdmin     = "admin"
+
+	apiKeyHeader = "X-Api-Key"
+	apiKeyParam  = "apikey"
+)
+
+var (
+	DefaultAPIKeys *APIKeys
+	apiTypes       = []string{apiSingle, apiMulti, apiRoom, apiBroadcast, apiTopic, apiAdmin}
+)
+
+// APIUsage is the usage counters of an api key and type.
+type APIUsage struct {
+	Requests  uint64 `json:"requests"`
+	Limited   uint64 `json:"limited"`
+	Forbidden uint64 `json:"forbidden"`
+}
+
+// apiKey is the quotas of a caller, a type not in quotas is forbidden, a nil
+// bucket means no limit.
+type apiKey struct {
+	quotas map[string]*ratelimit.Bucket
+	usages map[string]*APIUsage
+}
+
+// APIKeys check the api key and the quota of the logic http requests.
+type APIKeys struct {
+	open         bool
+	lock         sync.RWMutex
+	keys         map[string]*apiKey
+	unauthorized uint64
+}
+
+// NewAPIKeys new api keys, if not open all the requests are allowed.
+func NewAPIKeys(open bool, keys map[string]string) (a *APIKeys, err error) {
+	a = &APIKeys{open: open}
+	err = a.Load(keys)
+	return
+}
+
+// InitAPIKeys init the global api keys by the config.
+func InitAPIKeys() (err error) {
+	DefaultAPIKeys, err = NewAPIKeys(Conf.APIOpen, Conf.APIKeys)
+	return
+}
+
+// Load load the keys, key => quotas, such as "single:100,room:10,admin:0",
+// the number is requests per second, 0 means no limit. the usage counters of
+// the kept keys are not reset.
+func (a *APIKeys) Load(keys map[string]string) (err error) {
+	var (
+		k, v   string
+		quotas map[string]int
+		ks     = make(map[string]*apiKey, len(keys))
+	)
+	for k, v = range keys {
+		if quotas, err = parseQuotas(v); err != nil {
+			log.Error("api key: \"%s\" parseQuotas(\"%s\") error(%v)", k, v, err)
+			return
+		}
+		ak := &apiKey{quotas: make(map[string]*ratelimit.Bucket, len(quotas)), usages: make(map[string]*APIUsage, len(apiTypes))}
+		for t, rate := range quotas {
+			if rate > 0 {
+				ak.quotas[t] = ratelimit.NewBucket(rate, rate)
+			} else {
+				ak.quotas[t] = nil
+			}
+		}
+		for _, t := range apiTypes {
+			ak.usages[t] = new(APIUsage)
+		}
+		ks[k] = ak
+	}
+	a.lock.Lock()
+	for k, ak := range ks {
+		if old, ok := a.keys[k]; ok {
+			ak.usages = old.usages
+		}
+	}
+	a.keys = ks
+	a.lock.Unlock()
+	return
+}
+
+// parseQuotas parse "type:rate,type:rate".
+func parseQuotas(s string) (quotas map[string]int, err error) {
+	var (
+		i    int
+		rate int
+		kv   []string
+	)
+	quotas = make(map[string]int)
+	for _, q := range strings.Split(s, ",") {
+		if q = strings.TrimSpace(q); q == "" {
+			continue
+		}
+		if kv = strings.SplitN(q, ":", 2); len(kv) != 2 {
+			err = ErrAPIQuota
+			return
+		}
+		for i = 0; i < len(apiTypes); i++ {
+			if apiTypes[i] == kv[0] {
+				break
+			}
+		}
+		if i == len(apiTypes) {
+			err = ErrAPIQuota
+			return
+		}
+		if rate, err = strconv.Atoi(kv[1]); err != nil || rate < 0 {
+			err = ErrAPIQuota
+			return
+		}
+		quotas[kv[0]] = rate
+	}
+	return
+}
+
+// Allow check the key and the quota of the type, return the http status.
+func (a *APIKeys) Allow(key, typ string) int {
+	var (
+		ok bool
+		ak *apiKey
+		b  *ratelimit.Bucket
+		u  *APIUsage
+	)
+	if !a.open {
+		return http.StatusOK
+	}
+	a.lock.RLock()
+	ak, ok = a.keys[key]
+	a.lock.RUnlock()
+	if !ok {
+		atomic.AddUint64(&a.unauthorized, 1)
+		return http.StatusUnauthorized
+	}
+	u = ak.usages[typ]
+	if b, ok = ak.quotas[typ]; !ok {
+		atomic.AddUint64(&u.Forbidden, 1)
+		return http.StatusForbidden
+	}
+	if b != nil && !b.Allow() {
+		atomic.AddUint64(&u.Limited, 1)
+		return http.StatusTooManyRequests
+	}
+	atomic.AddUint64(&u.Requests, 1)
+	return http.StatusOK
+}
+
+// Usages return the usage counters, key => type => usage.
+func (a *APIKeys) Usages() (unauthorized uint64, usages map[string]map[string]*APIUsage) {
+	unauthorized = atomic.LoadUint64(&a.unauthorized)
+	usages = make(map[string]map[string]*APIUsage)
+	a.lock.RLock()
+	for k, ak := range a.keys {
+		us := make(map[string]*APIUsage, len(ak.usages))
+		for t, u := range ak.usages {
+			us[t] = &APIUsage{
+				Requests:  atomic.LoadUint64(&u.Requests),
+				Limited:   atomic.LoadUint64(&u.Limited),
+				Forbidden: atomic.LoadUint64(&u.Forbidden),
+			}
+		}
+		usages[k] = us
+	}
+	a.lock.RUnlock()
+	return
+}
+
+// Reset reset the usage counters.
+func (a *APIKeys) Reset() {
+	atomic.StoreUint64(&a.unauthorized, 0)
+	a.lock.RLock()
+	for _, ak := range a.keys {
+		for _, u := range ak.usages {
+			atomic.StoreUint64(&u.Requests, 0)
+			atomic.StoreUint64(&u.Limited, 0)
+			atomic.StoreUint64(&u.Forbidden, 0)
+		}
+	}
+	a.lock.RUnlock()
+}
+
+// apiHandler wrap the handler with the api key check of the type.
+func apiHandler(typ string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" {
+			key = r.URL.Query().Get(apiKeyParam)
+		}
+		switch DefaultAPIKeys.Allow(key, typ) {
+		case http.StatusUnauthorized:
+			log.Warn("api key: \"%s\" unauthorized, req: \"%s\", ip:\"%s\"", key, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case http.StatusForbidden:
+			log.Warn("api key: \"%s\" %s forbidden, req: \"%s\", ip:\"%s\"", key, typ, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case http.StatusTooManyRequests:
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		default:
+			h(w, r)
+		}
+	}
+}
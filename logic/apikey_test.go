@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := parseQuotas(" single:100, room:0,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotas) != 2 || quotas[apiSingle] != 100 || quotas[apiRoom] != 0 {
+		t.Errorf("quotas got %v", quotas)
+	}
+	for _, s := range []string{"single", "unknown:1", "single:-1", "single:x"} {
+		if _, err = parseQuotas(s); err != ErrAPIQuota {
+			t.Errorf("parseQuotas(%q) error(%v), want %v", s, err, ErrAPIQuota)
+		}
+	}
+}
+
+func TestAPIKeysAllow(t *testing.T) {
+	a, err := NewAPIKeys(true, map[string]string{"k1": "single:1,room:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		key, typ string
+		status   int
+	}{
+		{"", apiSingle, http.StatusUnauthorized},
+		{"k2", apiSingle, http.StatusUnauthorized},
+		{"k1", apiBroadcast, http.StatusForbidden},
+		{"k1", apiSingle, http.StatusOK},
+		{"k1", apiSingle, http.StatusTooManyRequests},
+		{"k1", apiRoom, http.StatusOK},
+		{"k1", apiRoom, http.StatusOK},
+	} {
+		if status := a.Allow(c.key, c.typ); status != c.status {
+			t.Errorf("Allow(%q, %s) got %d, want %d", c.key, c.typ, status, c.status)
+		}
+	}
+	unauthorized, usages := a.Usages()
+	if unauthorized != 2 {
+		t.Errorf("unauthorized got %d, want 2", unauthorized)
+	}
+	if u := usages["k1"][apiSingle]; u.Requests != 1 || u.Limited != 1 {
+		t.Errorf("single usage got %+v", u)
+	}
+	if u := usages["k1"][apiBroadcast]; u.Forbidden != 1 {
+		t.Errorf("broadcast usage got %+v", u)
+	}
+	a.Reset()
+	if unauthorized, usages = a.Usages(); unauthorized != 0 || usages["k1"][apiRoom].Requests != 0 {
+		t.Errorf("usages after reset got %d %+v", unauthorized, usages["k1"][apiRoom])
+	}
+	// not open
+	if a, err = NewAPIKeys(false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := a.Allow("", apiAdmin); status != http.StatusOK {
+		t.Errorf("Allow() not open got %d", status)
+	}
+}
+
+func TestAPIKeysRotate(t *testing.T) {
+	a, err := NewAPIKeys(true, map[string]string{"old": "single:0", "kept": "single:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Allow("kept", apiSingle)
+	if err = a.Load(map[string]string{"kept": "single:0", "new": "room:0"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := a.Allow("old", apiSingle); status != http.StatusUnauthorized {
+		t.Errorf("the rotated out key got %d", status)
+	}
+	if status := a.Allow("new", apiRoom); status != http.StatusOK {
+		t.Errorf("the new key got %d", status)
+	}
+	// the usages of the kept key are not reset
+	a.Allow("kept", apiSingle)
+	if _, usages := a.Usages(); usages["kept"][apiSingle].Requests != 2 {
+		t.Errorf("kept usage got %+v", usages["kept"][apiSingle])
+	}
+	// a bad config keeps the loaded keys
+	if err = a.Load(map[string]string{"bad": "single:x"}); err != ErrAPIQuota {
+		t.Fatalf("Load() error(%v), want %v", err, ErrAPIQuota)
+	}
+	if status := a.Allow("new", apiRoom); status != http.StatusOK {
+		t.Errorf("the new key after a bad load got %d", status)
+	}
+}
+
+func TestAPIHandler(t *testing.T) {
+	old := DefaultAPIKeys
+	defer func() { DefaultAPIKeys = old }()
+	var err error
+	if DefaultAPIKeys, err = NewAPIKeys(true, map[string]string{"k1": "single:0"}); err != nil {
+		t.Fatal(err)
+	}
+	h := apiHandler(apiSingle, func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range []struct {
+		url, header string
+		code        int
+	}{
+		{"/1/push?uid=1", "", http.StatusUnauthorized},
+		{"/1/push?uid=1", "k1", http.StatusOK},
+		{"/1/push?uid=1&apikey=k1", "", http.StatusOK},
+		{"/1/push?uid=1&apikey=k2", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("POST", c.url, nil)
+		if c.header != "" {
+			r.Header.Set(apiKeyHeader, c.header)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s key:%q got %d, want %d", c.url, c.header, w.Code, c.code)
+		}
+	}
+	// the type not in the quotas
+	h = apiHandler(apiRoom, func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("POST", "/1/push/room?rid=1", nil)
+	r.Header.Set(apiKeyHeader, "k1")
+	w := httptest.NewRecorder()
+	if h(w, r); w.Code != http.StatusForbidden {
+		t.Errorf("room got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"encoding/json"
 	"goim/libs/define"
+	"goim/libs/proto"
 	"sync/atomic"
 )
 
-// developer could implement "Auth" interface for decide how get userId, or roomId
+// developer could implement "Auth" interface for decide how get userId, or roomId,
+// the attributes such as platform and version are used by the broadcast filter.
 type Auther interface {
-	Auth(token string) (userId int64, roomId int32)
+	Auth(token string) (userId int64, roomId string, attrs map[string]string)
 }
 
 type DefaultAuther struct {
@@ -18,8 +20,8 @@ func NewDefaultAuther() *DefaultAuther {
 	return &DefaultAuther{}
 }
 
-//{"uid":1,"rid":1}
-func (a *GuluAuther) Auth(token string) (userId int64, roomId int32) {
+//{"userId":1,"roomId":"1","platform":"ios","version":"5.1.0","locale":"zh-CN","region":"cn"}
+func (a *GuluAuther) Auth(token string) (userId int64, roomId string, attrs map[string]string) {
 	// var err error
 	// if userId, err = strconv.ParseInt(token, 10, 64); err != nil {
 	// 	userId = 0
@@ -29,14 +31,16 @@ func (a *GuluAuther) Auth(token string) (userId int64, roomId int32) {
 	// }
 	// return
 	guluLogger.Info("token is " + token)
-	var user GuLuAuthInfo
+	// the int32 room id was 0 if not set, keep it for the old clients
+	var user = GuLuAuthInfo{RoomId: "0"}
 	if err := json.Unmarshal([]byte(token), &user); err != nil {
 		guluLogger.Error("反序列化失败" + err.Error())
 		userId = -1
 		roomId = define.NoRoom
 	} else {
 		userId = user.UserId
-		roomId = user.RoomId // only for debug
+		roomId = string(user.RoomId) // only for debug
+		attrs = user.Attrs()
 	}
 	if userId <= 0 {
 		// must positive
@@ -59,8 +63,27 @@ func NewGuluAuther() *GuluAuther {
 }
 
 type GuLuAuthInfo struct {
-	RoomId int32 `json:"roomId"`
+	RoomId proto.RoomId `json:"roomId"`
 	UserId int64 `json:"userId,omitempty"`
+	// attributes
+	Platform string `json:"platform,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	Region   string `json:"region,omitempty"`
+}
+
+// Attrs the connection attributes for the broadcast filter, nil if not set.
+func (u *GuLuAuthInfo) Attrs() (attrs map[string]string) {
+	for k, v := range map[string]string{"platform": u.Platform, "version": u.Version, "locale": u.Locale, "region": u.Region} {
+		if v == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string, 4)
+		}
+		attrs[k] = v
+	}
+	return
 }
 
 func (a *GuluAuther) anonymousId() int64 {
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAuthInfoAttrs(t *testing.T) {
+	for _, c := range []struct {
+		token string
+		attrs map[string]string
+	}{
+		{`{"userId":1,"roomId":"1"}`, nil},
+		{`{"userId":1,"platform":"ios","version":"5.1.0"}`, map[string]string{"platform": "ios", "version": "5.1.0"}},
+		{`{"userId":1,"platform":"android","version":"10","locale":"zh-CN","region":"cn"}`,
+			map[string]string{"platform": "android", "version": "10", "locale": "zh-CN", "region": "cn"}},
+		{`{"userId":1,"platform":"","region":"hk"}`, map[string]string{"region": "hk"}},
+	} {
+		var user GuLuAuthInfo
+		if err := json.Unmarshal([]byte(c.token), &user); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error(%v)", c.token, err)
+		}
+		if attrs := user.Attrs(); !reflect.DeepEqual(attrs, c.attrs) {
+			t.Errorf("Attrs(%s) got %v, want %v", c.token, attrs, c.attrs)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	inet "goim/libs/net"
+	"goim/libs/net/xrpc"
+	"goim/libs/proto"
+	"strings"
+)
+
+var (
+	cometServiceMap = map[int32]*xrpc.Clients{}
+)
+
+const (
+	cometServicePing  = "PushRPC.Ping"
+	cometServiceStat  = "PushRPC.Stat"
+	cometServiceRooms = "PushRPC.Rooms"
+)
+
+// InitComet dial the comets, logic only reads the comet stat for the admin
+// api, the pushes are always sent by job.
+func InitComet(addrs map[int32]string) (err error) {
+	var (
+		network, addr string
+		auth          = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret)
+		tlsCfg        *tls.Config
+	)
+	if tlsCfg, err = auth.ClientTLS(); err != nil {
+		guluLogger.Errorf("rpc auth ClientTLS() error(%v)", err)
+		return
+	}
+	for serverId, bind := range addrs {
+		var rpcOptions []xrpc.ClientOptions
+		for _, bind = range strings.Split(bind, ",") {
+			if network, addr, err = inet.ParseNetwork(bind); err != nil {
+				guluLogger.Errorf("inet.ParseNetwork() error(%v)", err)
+				return
+			}
+			options := xrpc.ClientOptions{
+				Proto:  network,
+				Addr:   addr,
+				TLS:    tlsCfg,
+				Secret: auth.Secret,
+			}
+			rpcOptions = append(rpcOptions, options)
+		}
+		// rpc clients
+		rpcClient := xrpc.Dials(rpcOptions)
+		// ping & reconnect
+		rpcClient.Ping(cometServicePing)
+		cometServiceMap[serverId] = rpcClient
+		guluLogger.Infof("comet rpc connect: %v ", rpcOptions)
+	}
+	return
+}
+
+func cometStat(client *xrpc.Clients) (reply *proto.CometStatReply, err error) {
+	reply = new(proto.CometStatReply)
+	if err = client.Call(cometServiceStat, &proto.NoArg{}, reply); err != nil {
+		guluLogger.Errorf("c.Call(\"%s\", nil) error(%v)", cometServiceStat, err)
+	}
+	return
+}
+
+func cometRooms(client *xrpc.Clients) (rooms int, err error) {
+	reply := new(proto.RoomsReply)
+	if err = client.Call(cometServiceRooms, &proto.NoArg{}, reply); err != nil {
+		guluLogger.Errorf("c.Call(\"%s\", nil) error(%v)", cometServiceRooms, err)
+	} else {
+		rooms = len(reply.RoomIds)
+	}
+	return
+}
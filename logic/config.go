@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/Terry-Mao/goconf"
@@ -47,12 +48,39 @@ type Config struct {
 	HTTPWriteTimeout time.Duration `goconf:"base:http.write.timeout:time"`
 	// router RPC
 	RouterRPCAddrs map[string]string `-`
+	// comet RPC, only used by the admin api
+	CometRPCAddrs map[int32]string `goconf:"-"`
+	// rpc auth
+	RPCTLSOpen       bool   `goconf:"rpc.auth:tls.open"`
+	RPCTLSCert       string `goconf:"rpc.auth:tls.cert"`
+	RPCTLSKey        string `goconf:"rpc.auth:tls.key"`
+	RPCTLSCA         string `goconf:"rpc.auth:tls.ca"`
+	RPCTLSServerName string `goconf:"rpc.auth:tls.server.name"`
+	RPCSecret        string `goconf:"rpc.auth:secret"`
+	// api keys, key => quotas
+	APIOpen bool `goconf:"api:open"`
+	APIKeys map[string]string
 	// kafka
 	KafkaTopic string   `goconf:"kafka:topic"`
 	KafkaAddrs []string `goconf:"kafka:addrs"`
 	// monitor
 	MonitorOpen  bool     `goconf:"monitor:open"`
 	MonitorAddrs []string `goconf:"monitor:addrs:,"`
+	MonitorAdmin bool     `goconf:"monitor:admin"`
+	JobMonitors  []string `goconf:"monitor:job.addrs:,"`
+	// trace
+	TraceSample   int           `goconf:"trace:sample"`
+	TraceURL      string        `goconf:"trace:exporter.url"`
+	TraceBatch    int           `goconf:"trace:exporter.batch"`
+	TraceInterval time.Duration `goconf:"trace:exporter.interval:time"`
+	TraceQueue    int           `goconf:"trace:exporter.queue"`
+	// schedule
+	ScheduleFile     string        `goconf:"schedule:file"`
+	ScheduleMax      int           `goconf:"schedule:max"`
+	ScheduleDelayMax time.Duration `goconf:"schedule:delay.max:time"`
+	ScheduleWorkers  int           `goconf:"schedule:workers"`
+	ScheduleCompact  time.Duration `goconf:"schedule:compact:time"`
+	SchedulePeers    []string      `goconf:"schedule:peers:,"`
 }
 
 func NewConfig() *Config {
@@ -67,6 +95,18 @@ func NewConfig() *Config {
 		PprofAddrs:     []string{"localhost:6971"},
 		HTTPAddrs:      []string{"7172"},
 		RouterRPCAddrs: make(map[string]string),
+		CometRPCAddrs:  make(map[int32]string),
+		APIKeys:        make(map[string]string),
+		// trace
+		TraceBatch:    512,
+		TraceInterval: 5 * time.Second,
+		TraceQueue:    10240,
+		// schedule
+		ScheduleFile:     "./logic-schedule.log",
+		ScheduleMax:      100000,
+		ScheduleDelayMax: 30 * 24 * time.Hour,
+		ScheduleWorkers:  4,
+		ScheduleCompact:  10 * time.Minute,
 	}
 }
 
@@ -87,6 +127,45 @@ func InitConfig() (err error) {
 		}
 		Conf.RouterRPCAddrs[serverID] = addr
 	}
+	if err = loadCometAddrs(gconf, Conf); err != nil {
+		return err
+	}
+	return loadAPIKeys(gconf, Conf)
+}
+
+// loadCometAddrs load the comet.addrs section, it's optional.
+func loadCometAddrs(c *goconf.Config, conf *Config) error {
+	s := c.Get("comet.addrs")
+	if s == nil {
+		return nil
+	}
+	for _, serverID := range s.Keys() {
+		addr, err := s.String(serverID)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(serverID, 10, 32)
+		if err != nil {
+			return err
+		}
+		conf.CometRPCAddrs[int32(id)] = addr
+	}
+	return nil
+}
+
+// loadAPIKeys load the api.keys section, it's optional.
+func loadAPIKeys(c *goconf.Config, conf *Config) error {
+	s := c.Get("api.keys")
+	if s == nil {
+		return nil
+	}
+	for _, key := range s.Keys() {
+		quotas, err := s.String(key)
+		if err != nil {
+			return err
+		}
+		conf.APIKeys[key] = quotas
+	}
 	return nil
 }
 
@@ -99,6 +178,9 @@ func ReloadConfig() (*Config, error) {
 	if err := ngconf.Unmarshal(conf); err != nil {
 		return nil, err
 	}
+	if err := loadAPIKeys(ngconf, conf); err != nil {
+		return nil, err
+	}
 	gconf = ngconf
 	return conf, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"goim/libs/net/xrpc"
+	"sync"
 	"time"
 )
 
@@ -10,26 +11,38 @@ const (
 )
 
 var (
-	RoomCountMap   = make(map[int32]int32) // roomid:count
+	// the maps are replaced under the lock, read them by Counts
+	countLock      sync.RWMutex
+	RoomCountMap   = make(map[string]int32) // roomid:count
 	ServerCountMap = make(map[int32]int32) // server:count
 )
 
+// Counts get the room and server counts, they must not be modified.
+func Counts() (rooms map[string]int32, servers map[int32]int32) {
+	countLock.RLock()
+	rooms, servers = RoomCountMap, ServerCountMap
+	countLock.RUnlock()
+	return
+}
+
 func MergeCount() {
 	var (
 		c                     *xrpc.Clients
 		err                   error
-		roomId, server, count int32
+		roomId                string
+		server, count         int32
 		counter               map[int32]int32
-		roomCount             = make(map[int32]int32)
+		roomCounter           map[string]int32
+		roomCount             = make(map[string]int32)
 		serverCount           = make(map[int32]int32)
 	)
 	// all comet nodes
 	for _, c = range routerServiceMap {
 		if c != nil {
-			if counter, err = allRoomCount(c); err != nil {
+			if roomCounter, err = allRoomCount(c); err != nil {
 				continue
 			}
-			for roomId, count = range counter {
+			for roomId, count = range roomCounter {
 				roomCount[roomId] += count
 			}
 			if counter, err = allServerCount(c); err != nil {
@@ -40,24 +53,34 @@ func MergeCount() {
 			}
 		}
 	}
+	countLock.Lock()
 	RoomCountMap = roomCount
 	ServerCountMap = serverCount
+	countLock.Unlock()
 }
 
 //描述：清空房间人数
 //作者：徐明祥
 //日期：2017-01-08
-func CleanRoomCount(roomId int32) (err error) {
+func CleanRoomCount(roomId string) (err error) {
 	if err = cleanRoomCount(roomId); err != nil {
+		countLock.Lock()
 		if _, ok := RoomCountMap[roomId]; ok {
-			RoomCountMap[roomId] = 0
+			// copy on write, the readers hold the old map
+			roomCount := make(map[string]int32, len(RoomCountMap))
+			for k, v := range RoomCountMap {
+				roomCount[k] = v
+			}
+			roomCount[roomId] = 0
+			RoomCountMap = roomCount
 		}
+		countLock.Unlock()
 	}
 	return
 }
 
 /*
-func RoomCount(roomId int32) (count int32) {
+func RoomCount(roomId string) (count int32) {
 	count = RoomCountMap[roomId]
 	return
 }
@@ -10,4 +10,17 @@ var (
 	ErrNetworkAddr    = errors.New("network addrs error, must network@address")
 	ErrConnectArgs    = errors.New("connect rpc args error")
 	ErrDisconnectArgs = errors.New("disconnect rpc args error")
+	ErrAPIQuota       = errors.New("api key quota error, must type:rate,type:rate")
+	ErrRoomId         = errors.New("room id error, must not empty and at most 128 bytes")
+	ErrTopic          = errors.New("topic error, must not empty and at most 128 bytes")
+	ErrHistoryArgs    = errors.New("room history rpc args error")
+	ErrResendArgs     = errors.New("resend rpc args error")
+	// schedule
+	ErrScheduler    = errors.New("scheduler is not available")
+	ErrScheduleFull = errors.New("over the max pending schedules")
+	ErrScheduleTime = errors.New("schedule time error, send_at must unix seconds and delay must seconds within delay.max")
+	ErrScheduleType = errors.New("unknown schedule type")
+	ErrSchedulePeer = errors.New("peer logic schedules request failed")
+	// expire
+	ErrExpire = errors.New("expire error, ttl must positive seconds and expire_at must unix seconds")
 )
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goim/libs/proto"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// roomHistory get the latest n messages after since(unix milliseconds) of the
+// room from the oldest, n 0 means all. the history is kept by the job which
+// consumes the room, read from all the job monitors and merged, it fails only
+// if no job replied.
+func roomHistory(roomId string, n int, since int64) (msgs []*proto.RoomHistoryMsg, err error) {
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		replied bool
+		query   = url.Values{"rid": {roomId}, "n": {strconv.Itoa(n)}, "since": {strconv.FormatInt(since, 10)}}
+	)
+	for _, addr := range Conf.JobMonitors {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			ms, err1 := jobRoomHistory(addr, query)
+			lock.Lock()
+			if err1 != nil {
+				err = err1
+			} else {
+				replied = true
+				msgs = append(msgs, ms...)
+			}
+			lock.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	if replied {
+		err = nil
+	}
+	// a room moves to another job by the kafka rebalance, merge both
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Time < msgs[j].Time })
+	if n > 0 && len(msgs) > n {
+		msgs = msgs[len(msgs)-n:]
+	}
+	return
+}
+
+// jobRoomHistory read the room history of a job monitor.
+func jobRoomHistory(addr string, query url.Values) (msgs []*proto.RoomHistoryMsg, err error) {
+	var (
+		resp *http.Response
+		res  struct {
+			Data []*proto.RoomHistoryMsg `json:"data"`
+		}
+	)
+	if resp, err = jobMonitorClient.Get("http://" + addr + "/monitor/room/history?" + query.Encode()); err != nil {
+		log.Error("job monitor http.Get(%s) error(%v)", addr, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("job monitor %s status %d", addr, resp.StatusCode)
+		log.Error("job monitor room history error(%v)", err)
+		return
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Error("job monitor %s json.Decode() error(%v)", addr, err)
+		return
+	}
+	msgs = res.Data
+	return
+}
+
+// delRoomHistory delete the room history of all the jobs.
+func delRoomHistory(roomId string) {
+	var wg sync.WaitGroup
+	for _, addr := range Conf.JobMonitors {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			req, err := http.NewRequest("DELETE", "http://"+addr+"/monitor/room/history?rid="+url.QueryEscape(roomId), nil)
+			if err != nil {
+				log.Error("http.NewRequest(%s) error(%v)", addr, err)
+				return
+			}
+			resp, err := jobMonitorClient.Do(req)
+			if err != nil {
+				log.Error("job monitor http.Do(%s) error(%v)", addr, err)
+				return
+			}
+			resp.Body.Close()
+		}(addr)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestJob(t *testing.T, msgs []*proto.RoomHistoryMsg) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/monitor/room/history" || r.URL.Query().Get("rid") != "1" {
+			t.Errorf("job request got %s", r.URL)
+		}
+		if msgs == nil {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ret": 1, "data": msgs})
+	}))
+}
+
+func TestRoomHistoryMerge(t *testing.T) {
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	var (
+		old  = Conf.JobMonitors
+		job1 = newTestJob(t, []*proto.RoomHistoryMsg{{Msg: []byte("a"), Time: 1}, {Msg: []byte("c"), Time: 3}})
+		job2 = newTestJob(t, []*proto.RoomHistoryMsg{{Msg: []byte("b"), Time: 2}, {Msg: []byte("d"), Time: 4}})
+		down = newTestJob(t, nil)
+		addr = func(s *httptest.Server) string { return strings.TrimPrefix(s.URL, "http://") }
+	)
+	defer func() { Conf.JobMonitors = old }()
+	defer job1.Close()
+	defer job2.Close()
+	defer down.Close()
+	// a room moved between the jobs, merged by time, a job down is skipped
+	Conf.JobMonitors = []string{addr(job1), addr(down), addr(job2)}
+	msgs, err := roomHistory("1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for _, m := range msgs {
+		got += string(m.Msg)
+	}
+	if got != "abcd" {
+		t.Errorf("history got %s, want abcd", got)
+	}
+	// the latest n of the merged
+	if msgs, err = roomHistory("1", 3, 0); err != nil || len(msgs) != 3 || string(msgs[0].Msg) != "b" {
+		t.Errorf("latest 3 got %v error(%v)", msgs, err)
+	}
+	// fails only if no job replied
+	Conf.JobMonitors = []string{addr(down)}
+	if _, err = roomHistory("1", 0, 0); err == nil {
+		t.Error("all jobs down got nil error")
+	}
+}
@@ -2,28 +2,41 @@ package main
 
 import (
 	"encoding/json"
+	"goim/libs/filter"
 	inet "goim/libs/net"
+	"goim/libs/proto"
+	"goim/libs/trace"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	log "github.com/thinkboy/log4go"
 )
 
+const (
+	traceIdHeader = "X-Trace-Id"
+)
+
 func InitHTTP() (err error) {
 	// http listen
 	var network, addr string
 	for i := 0; i < len(Conf.HTTPAddrs); i++ {
 		httpServeMux := http.NewServeMux()
-		httpServeMux.HandleFunc("/1/push", Push)
-		httpServeMux.HandleFunc("/1/pushs", Pushs)
-		httpServeMux.HandleFunc("/1/push/all", PushAll)
-		httpServeMux.HandleFunc("/1/push/room", PushRoom)
-		httpServeMux.HandleFunc("/1/server/del", DelServer)
-		httpServeMux.HandleFunc("/1/count", Count)
-		httpServeMux.HandleFunc("/1/room/clean", Clean) //清空房间在线人数
+		httpServeMux.HandleFunc("/1/push", apiHandler(apiSingle, Push))
+		httpServeMux.HandleFunc("/1/pushs", apiHandler(apiMulti, Pushs))
+		httpServeMux.HandleFunc("/1/push/all", apiHandler(apiBroadcast, PushAll))
+		httpServeMux.HandleFunc("/1/push/room", apiHandler(apiRoom, PushRoom))
+		httpServeMux.HandleFunc("/1/push/topic", apiHandler(apiTopic, PushTopic))
+		httpServeMux.HandleFunc("/1/server/del", apiHandler(apiAdmin, DelServer))
+		httpServeMux.HandleFunc("/1/count", apiHandler(apiAdmin, Count))
+		httpServeMux.HandleFunc("/1/room/clean", apiHandler(apiAdmin, Clean)) //清空房间在线人数
+		httpServeMux.HandleFunc("/1/room/meta", apiHandler(apiAdmin, RoomMeta))
+		httpServeMux.HandleFunc("/1/room/users", apiHandler(apiAdmin, RoomUsers))
+		httpServeMux.HandleFunc("/1/room/history", apiHandler(apiAdmin, RoomHistory))
+		httpServeMux.HandleFunc("/1/schedules", apiHandler(apiAdmin, Schedules))
 
 		log.Info("start http listen:\"%s\"", Conf.HTTPAddrs[i])
 		if network, addr, err = inet.ParseNetwork(Conf.HTTPAddrs[i]); err != nil {
@@ -77,6 +90,55 @@ func retPWrite(w http.ResponseWriter, r *http.Request, res map[string]interface{
 	log.Info("req: \"%s\", post: \"%s\", res:\"%s\", ip:\"%s\", time:\"%fs\"", r.URL.String(), *body, dataStr, r.RemoteAddr, time.Now().Sub(start).Seconds())
 }
 
+// pushTrace start the push span, the trace id is from the X-Trace-Id header if
+// valid, or a new one. the trace id is returned in the result.
+func pushTrace(r *http.Request, name string, res map[string]interface{}) *trace.Span {
+	traceId := r.Header.Get(traceIdHeader)
+	if !trace.ValidTraceId(traceId) {
+		traceId = trace.NewTraceId()
+	}
+	if traceId != "" {
+		res["trace"] = traceId
+	}
+	return trace.Start(traceId, name)
+}
+
+// parseExpire get the message deadline in unix milliseconds by ttl(seconds,
+// from the send time) or expire_at(unix seconds), 0 if never expire.
+func parseExpire(query url.Values, sendAt time.Time) (expire int64, err error) {
+	var n int64
+	if s := query.Get("ttl"); s != "" {
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil || n <= 0 {
+			err = ErrExpire
+			return
+		}
+		if sendAt.IsZero() {
+			sendAt = time.Now()
+		}
+		expire = sendAt.Add(time.Duration(n)*time.Second).UnixNano() / int64(time.Millisecond)
+	} else if s = query.Get("expire_at"); s != "" {
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil || n <= 0 {
+			err = ErrExpire
+			return
+		}
+		expire = n * 1000
+	}
+	return
+}
+
+// parsePushTime get the send time by parseSendAt and the message deadline
+// by parseExpire.
+func parsePushTime(query url.Values) (sendAt time.Time, expire int64, err error) {
+	if sendAt, err = parseSendAt(query); err != nil {
+		log.Error("parseSendAt(\"%s\") error(%v)", query.Encode(), err)
+		return
+	}
+	if expire, err = parseExpire(query, sendAt); err != nil {
+		log.Error("parseExpire(\"%s\") error(%v)", query.Encode(), err)
+	}
+	return
+}
+
 func Push(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method Not Allowed", 405)
@@ -87,31 +149,49 @@ func Push(w http.ResponseWriter, r *http.Request) {
 		serverId  int32
 		keys      []string
 		subKeys   map[int32][]string
+		msgSeqs   map[int32][]int32
 		bodyBytes []byte
 		userId    int64
 		err       error
+		sendAt    time.Time
+		expire    int64
 		uidStr    = r.URL.Query().Get("uid")
 		res       = map[string]interface{}{"ret": OK}
 	)
 	defer retPWrite(w, r, res, &body, time.Now())
+	span := pushTrace(r, "logic.push", res)
+	defer span.Finish()
 	if bodyBytes, err = ioutil.ReadAll(r.Body); err != nil {
 		log.Error("ioutil.ReadAll() failed (%s)", err)
 		res["ret"] = InternalErr
 		return
 	}
 	body = string(bodyBytes)
+	span.Set("uid", uidStr)
 	if userId, err = strconv.ParseInt(uidStr, 10, 64); err != nil {
 		log.Error("strconv.Atoi(\"%s\") error(%v)", uidStr, err)
 		res["ret"] = InternalErr
 		return
 	}
-	subKeys = genSubKey(userId)
+	// scheduled push and message expiry
+	if sendAt, expire, err = parsePushTime(r.URL.Query()); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if !sendAt.IsZero() {
+		schedulePush(res, &Schedule{Type: apiSingle, UserIds: []int64{userId}, Msg: bodyBytes, Expire: expire}, sendAt, span)
+		return
+	}
+	subKeys, msgSeqs = genSubKeys([]int64{userId}, bodyBytes, expire)
+	span.Set("servers", len(subKeys))
 	for serverId, keys = range subKeys {
-		if err = mpushKafka(serverId, keys, bodyBytes); err != nil {
+		if err = mpushKafka(serverId, keys, msgSeqs[serverId], bodyBytes, expire, span, nil); err != nil {
+			span.Error(err)
 			res["ret"] = InternalErr
 			return
 		}
 	}
+	pushCounter.WithLabelValues(apiSingle).Inc()
 	res["ret"] = OK
 	return
 }
@@ -143,11 +223,16 @@ func Pushs(w http.ResponseWriter, r *http.Request) {
 		serverId  int32
 		userIds   []int64
 		err       error
+		sendAt    time.Time
+		expire    int64
 		res       = map[string]interface{}{"ret": OK}
 		subKeys   map[int32][]string
+		msgSeqs   map[int32][]int32
 		keys      []string
 	)
 	defer retPWrite(w, r, res, &body, time.Now())
+	span := pushTrace(r, "logic.pushs", res)
+	defer span.Finish()
 	if bodyBytes, err = ioutil.ReadAll(r.Body); err != nil {
 		log.Error("ioutil.ReadAll() failed (%s)", err)
 		res["ret"] = InternalErr
@@ -159,13 +244,26 @@ func Pushs(w http.ResponseWriter, r *http.Request) {
 		res["ret"] = InternalErr
 		return
 	}
-	subKeys = genSubKeys(userIds)
+	// scheduled push and message expiry
+	if sendAt, expire, err = parsePushTime(r.URL.Query()); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if !sendAt.IsZero() {
+		schedulePush(res, &Schedule{Type: apiMulti, UserIds: userIds, Msg: bodyBytes, Expire: expire}, sendAt, span)
+		return
+	}
+	subKeys, msgSeqs = genSubKeys(userIds, bodyBytes, expire)
+	span.Set("users", len(userIds))
+	span.Set("servers", len(subKeys))
 	for serverId, keys = range subKeys {
-		if err = mpushKafka(serverId, keys, bodyBytes); err != nil {
+		if err = mpushKafka(serverId, keys, msgSeqs[serverId], bodyBytes, expire, span, nil); err != nil {
+			span.Error(err)
 			res["ret"] = InternalErr
 			return
 		}
 	}
+	pushCounter.WithLabelValues(apiMulti).Inc()
 	res["ret"] = OK
 	return
 }
@@ -178,12 +276,16 @@ func PushRoom(w http.ResponseWriter, r *http.Request) {
 	var (
 		bodyBytes []byte
 		body      string
-		rid       int
+		rid       string
 		err       error
+		sendAt    time.Time
+		expire    int64
 		param     = r.URL.Query()
 		res       = map[string]interface{}{"ret": OK}
 	)
 	defer retPWrite(w, r, res, &body, time.Now())
+	span := pushTrace(r, "logic.push.room", res)
+	defer span.Finish()
 	if bodyBytes, err = ioutil.ReadAll(r.Body); err != nil {
 		log.Error("ioutil.ReadAll() failed (%v)", err)
 		res["ret"] = InternalErr
@@ -191,18 +293,81 @@ func PushRoom(w http.ResponseWriter, r *http.Request) {
 	}
 	body = string(bodyBytes)
 	ridStr := param.Get("rid")
+	span.Set("rid", ridStr)
 	enable, _ := strconv.ParseBool(param.Get("ensure"))
 	// push room
-	if rid, err = strconv.Atoi(ridStr); err != nil {
-		log.Error("strconv.Atoi(\"%s\") error(%v)", ridStr, err)
+	if rid, err = parseRoomId(ridStr); err != nil {
+		log.Error("parseRoomId(\"%s\") error(%v)", ridStr, err)
+		res["ret"] = InternalErr
+		return
+	}
+	// scheduled push and message expiry
+	if sendAt, expire, err = parsePushTime(r.URL.Query()); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if !sendAt.IsZero() {
+		schedulePush(res, &Schedule{Type: apiRoom, RoomId: rid, Ensure: enable, Msg: bodyBytes, Expire: expire}, sendAt, span)
+		return
+	}
+	if err = broadcastRoomKafka(rid, bodyBytes, enable, expire, span, nil); err != nil {
+		span.Error(err)
+		log.Error("broadcastRoomKafka(\"%s\",\"%s\",\"%t\") error(%s)", rid, body, enable, err)
+		res["ret"] = InternalErr
+		return
+	}
+	pushCounter.WithLabelValues(apiRoom).Inc()
+	res["ret"] = OK
+	return
+}
+
+// PushTopic push to the channels subscribed the topic by OP_SUB.
+func PushTopic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	var (
+		bodyBytes []byte
+		body      string
+		err       error
+		sendAt    time.Time
+		expire    int64
+		topic     = r.URL.Query().Get("t")
+		res       = map[string]interface{}{"ret": OK}
+	)
+	defer retPWrite(w, r, res, &body, time.Now())
+	span := pushTrace(r, "logic.push.topic", res)
+	defer span.Finish()
+	if bodyBytes, err = ioutil.ReadAll(r.Body); err != nil {
+		log.Error("ioutil.ReadAll() failed (%v)", err)
+		res["ret"] = InternalErr
+		return
+	}
+	body = string(bodyBytes)
+	span.Set("topic", topic)
+	if topic == "" || len(topic) > topicMaxSize {
+		log.Error("topic \"%s\" error(%v)", topic, ErrTopic)
 		res["ret"] = InternalErr
 		return
 	}
-	if err = broadcastRoomKafka(int32(rid), bodyBytes, enable); err != nil {
-		log.Error("broadcastRoomKafka(\"%s\",\"%s\",\"%d\") error(%s)", rid, body, enable, err)
+	// scheduled push and message expiry
+	if sendAt, expire, err = parsePushTime(r.URL.Query()); err != nil {
 		res["ret"] = InternalErr
 		return
 	}
+	if !sendAt.IsZero() {
+		schedulePush(res, &Schedule{Type: apiTopic, Topic: topic, Msg: bodyBytes, Expire: expire}, sendAt, span)
+		return
+	}
+	// push topic
+	if err = broadcastTopicKafka(topic, bodyBytes, expire, span, nil); err != nil {
+		span.Error(err)
+		log.Error("broadcastTopicKafka(\"%s\",\"%s\") error(%s)", topic, body, err)
+		res["ret"] = InternalErr
+		return
+	}
+	pushCounter.WithLabelValues(apiTopic).Inc()
 	res["ret"] = OK
 	return
 }
@@ -216,27 +381,50 @@ func PushAll(w http.ResponseWriter, r *http.Request) {
 		bodyBytes []byte
 		body      string
 		err       error
+		sendAt    time.Time
+		expire    int64
+		f         = r.URL.Query().Get("filter")
 		res       = map[string]interface{}{"ret": OK}
 	)
 	defer retPWrite(w, r, res, &body, time.Now())
+	span := pushTrace(r, "logic.push.all", res)
+	defer span.Finish()
 	if bodyBytes, err = ioutil.ReadAll(r.Body); err != nil {
 		log.Error("ioutil.ReadAll() failed (%v)", err)
 		res["ret"] = InternalErr
 		return
 	}
 	body = string(bodyBytes)
+	// check the filter, comet evaluates it by the auth attributes
+	span.Set("filter", f)
+	if _, err = filter.Parse(f); err != nil {
+		log.Error("filter.Parse(\"%s\") error(%v)", f, err)
+		res["ret"] = InternalErr
+		return
+	}
+	// scheduled push and message expiry
+	if sendAt, expire, err = parsePushTime(r.URL.Query()); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if !sendAt.IsZero() {
+		schedulePush(res, &Schedule{Type: apiBroadcast, Filter: f, Msg: bodyBytes, Expire: expire}, sendAt, span)
+		return
+	}
 	// push all
-	if err := broadcastKafka(bodyBytes); err != nil {
-		log.Error("broadcastKafka(\"%s\") error(%s)", body, err)
+	if err := broadcastKafka(bodyBytes, f, expire, span, nil); err != nil {
+		span.Error(err)
+		log.Error("broadcastKafka(\"%s\",\"%s\") error(%s)", body, f, err)
 		res["ret"] = InternalErr
 		return
 	}
+	pushCounter.WithLabelValues(apiBroadcast).Inc()
 	res["ret"] = OK
 	return
 }
 
 type RoomCounter struct {
-	RoomId int32
+	RoomId proto.RoomId
 	Count  int32
 }
 
@@ -255,15 +443,16 @@ func Count(w http.ResponseWriter, r *http.Request) {
 		res     = map[string]interface{}{"ret": OK}
 	)
 	defer retWrite(w, r, res, time.Now())
+	rooms, servers := Counts()
 	if typeStr == "room" {
-		d := make([]*RoomCounter, 0, len(RoomCountMap))
-		for roomId, count := range RoomCountMap {
-			d = append(d, &RoomCounter{RoomId: roomId, Count: count})
+		d := make([]*RoomCounter, 0, len(rooms))
+		for roomId, count := range rooms {
+			d = append(d, &RoomCounter{RoomId: proto.RoomId(roomId), Count: count})
 		}
 		res["data"] = d
 	} else if typeStr == "server" {
-		d := make([]*ServerCounter, 0, len(ServerCountMap))
-		for server, count := range ServerCountMap {
+		d := make([]*ServerCounter, 0, len(servers))
+		for server, count := range servers {
 			d = append(d, &ServerCounter{Server: server, Count: count})
 		}
 		res["data"] = d
@@ -306,16 +495,16 @@ func Clean(w http.ResponseWriter, r *http.Request) {
 	var (
 		err       error
 		roomIdStr = r.URL.Query().Get("rid")
-		roomId    int64
+		roomId    string
 		res       = map[string]interface{}{"ret": OK}
 	)
-	if roomId, err = strconv.ParseInt(roomIdStr, 10, 32); err != nil {
-		log.Error("strconv.Atoi(\"%s\") error(%v)", roomIdStr, err)
+	if roomId, err = parseRoomId(roomIdStr); err != nil {
+		log.Error("parseRoomId(\"%s\") error(%v)", roomIdStr, err)
 		res["ret"] = InternalErr
 		return
 	}
 	defer retWrite(w, r, res, time.Now())
-	if err = CleanRoomCount(int32(roomId)); err != nil {
+	if err = CleanRoomCount(roomId); err != nil {
 		res["ret"] = InternalErr
 		return
 	}
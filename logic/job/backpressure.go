@@ -0,0 +1,227 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	// allComets is the comet of the messages to all comets
+	allComets = int32(-1)
+)
+
+var (
+	DefaultBackpressure *Backpressure
+)
+
+// queueStat is the depths of the job queues.
+type queueStat struct {
+	push          int           // queued in the push chans
+	comets        map[int32]int // queued in the routines of each comet
+	rooms         int           // queued in the rooms
+	fullest       int           // the fullest queue in percent of its size
+	shared        int           // the fullest of the push chans and rooms in percent
+	cometsFullest map[int32]int // the fullest routine of each comet in percent
+}
+
+// Backpressure pause the kafka consumption of the messages to a comet while
+// the fullest of its routine queues is over the high watermark, and resume
+// it below the low watermark. the push chans and rooms are shared by all
+// comets, the consumption of all messages is paused while they are over.
+// the messages to a paused comet are held by their partitions and the
+// broadcasts skip it, see partition.
+type Backpressure struct {
+	high    int   // percent
+	low     int   // percent
+	paused  int32 // the shared queues are over
+	pauses  uint64
+	lock    sync.Mutex
+	comets  map[int32]struct{} // the paused comets
+	changed chan struct{}      // closed once paused or resumed
+	stat    queueStat
+}
+
+// InitBackpressure init the global backpressure, the queues are checked
+// every check.
+func InitBackpressure(high, low int, check time.Duration) {
+	DefaultBackpressure = NewBackpressure(high, low)
+	go DefaultBackpressure.checkproc(check)
+}
+
+// NewBackpressure new a backpressure by the watermarks in percent.
+func NewBackpressure(high, low int) *Backpressure {
+	return &Backpressure{high: high, low: low, comets: make(map[int32]struct{}), changed: make(chan struct{})}
+}
+
+// percent get n of size in percent.
+func percent(n, size int) int {
+	if size <= 0 {
+		return 0
+	}
+	return n * 100 / size
+}
+
+// queues get the depths of the queues.
+func queues() (s queueStat) {
+	var n int
+	s.comets = make(map[int32]int, len(cometServiceMap))
+	s.cometsFullest = make(map[int32]int, len(cometServiceMap))
+	for _, ch := range pushChs {
+		s.push += len(ch)
+		if n = percent(len(ch), cap(ch)); n > s.shared {
+			s.shared = n
+		}
+	}
+	if roomBucket != nil {
+		roomBucket.bLock.RLock()
+		for _, r := range roomBucket.rooms {
+			s.rooms += len(r.proto)
+			if n = percent(len(r.proto), cap(r.proto)); n > s.shared {
+				s.shared = n
+			}
+		}
+		roomBucket.bLock.RUnlock()
+	}
+	s.fullest = s.shared
+	for serverId, c := range cometServiceMap {
+		for i := range c.pushRoutines {
+			for _, l := range [][2]int{
+				{len(c.pushRoutines[i]), cap(c.pushRoutines[i])},
+				{len(c.roomRoutines[i]), cap(c.roomRoutines[i])},
+				{len(c.topicRoutines[i]), cap(c.topicRoutines[i])},
+				{len(c.broadcastRoutines[i]), cap(c.broadcastRoutines[i])},
+			} {
+				s.comets[serverId] += l[0]
+				if n = percent(l[0], l[1]); n > s.cometsFullest[serverId] {
+					s.cometsFullest[serverId] = n
+				}
+			}
+		}
+		if n = s.cometsFullest[serverId]; n > s.fullest {
+			s.fullest = n
+		}
+	}
+	return
+}
+
+// over report whether the queue of fullest percent should be paused, by
+// the watermarks and whether it is paused.
+func (b *Backpressure) over(fullest int, paused bool) bool {
+	if paused {
+		return fullest > b.low
+	}
+	return b.high > 0 && fullest >= b.high
+}
+
+func (b *Backpressure) checkproc(d time.Duration) {
+	for {
+		s := queues()
+		b.check(s)
+		time.Sleep(d)
+	}
+}
+
+// check pause or resume the consumption by the queue depths.
+func (b *Backpressure) check(s queueStat) {
+	var paused, changed bool
+	if paused = atomic.LoadInt32(&b.paused) == 1; b.over(s.shared, paused) != paused {
+		changed = true
+		if paused = !paused; paused {
+			atomic.StoreInt32(&b.paused, 1)
+			b.pause()
+			log.Warn("job push and room queues %d%% full, pause the kafka consumption", s.shared)
+		} else {
+			atomic.StoreInt32(&b.paused, 0)
+			log.Info("job push and room queues %d%% full, resume the kafka consumption", s.shared)
+		}
+	}
+	b.lock.Lock()
+	b.stat = s
+	for serverId, fullest := range s.cometsFullest {
+		_, paused = b.comets[serverId]
+		if b.over(fullest, paused) == paused {
+			continue
+		}
+		if changed = true; paused {
+			delete(b.comets, serverId)
+			log.Info("comet serverId:%d queues %d%% full, resume its kafka consumption", serverId, fullest)
+		} else {
+			b.comets[serverId] = struct{}{}
+			b.pause()
+			log.Warn("comet serverId:%d queues %d%% full, pause its kafka consumption", serverId, fullest)
+		}
+	}
+	if changed {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+	b.lock.Unlock()
+}
+
+// pause count a pause.
+func (b *Backpressure) pause() {
+	atomic.AddUint64(&b.pauses, 1)
+	pauseCounter.Inc()
+}
+
+// Paused report whether the consumption of the messages to the comet is
+// paused, allComets if the shared queues are over, nil never.
+func (b *Backpressure) Paused(serverId int32) (paused bool) {
+	if b == nil {
+		return
+	}
+	if atomic.LoadInt32(&b.paused) == 1 {
+		return true
+	}
+	if serverId == allComets {
+		return
+	}
+	b.lock.Lock()
+	_, paused = b.comets[serverId]
+	b.lock.Unlock()
+	return
+}
+
+// CometPaused report whether the queues of the comet are over, the
+// broadcasts skip it, nil never.
+func (b *Backpressure) CometPaused(serverId int32) (paused bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	_, paused = b.comets[serverId]
+	b.lock.Unlock()
+	return
+}
+
+// Changed get the chan closed once the consumption of any comet is paused
+// or resumed, nil never.
+func (b *Backpressure) Changed() (ch <-chan struct{}) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	ch = b.changed
+	b.lock.Unlock()
+	return
+}
+
+// Stat get the queue depths of the last check, whether the shared queues
+// are paused and the paused comets.
+func (b *Backpressure) Stat() (s queueStat, paused bool, comets []int32, pauses uint64) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	s = b.stat
+	for serverId := range b.comets {
+		comets = append(comets, serverId)
+	}
+	b.lock.Unlock()
+	sort.Slice(comets, func(i, j int) bool { return comets[i] < comets[j] })
+	return s, atomic.LoadInt32(&b.paused) == 1, comets, atomic.LoadUint64(&b.pauses)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBackpressureCheck(t *testing.T) {
+	b := NewBackpressure(80, 50)
+	for i, c := range []struct {
+		shared  int
+		comets  map[int32]int // the fullest percent of each comet
+		paused  []int32       // the paused comets, allComets if all
+		ok      []int32       // the comets not paused
+		changed bool
+	}{
+		{10, map[int32]int{1: 10, 2: 10}, nil, []int32{1, 2, allComets}, false},
+		// a slow comet pauses only the messages to it
+		{10, map[int32]int{1: 90, 2: 10}, []int32{1}, []int32{2, allComets}, true},
+		// resumed below the low watermark only
+		{10, map[int32]int{1: 60, 2: 10}, []int32{1}, []int32{2, allComets}, false},
+		{10, map[int32]int{1: 50, 2: 10}, nil, []int32{1, 2, allComets}, true},
+		// the shared queues pause all
+		{80, map[int32]int{1: 10, 2: 10}, []int32{1, 2, allComets}, nil, true},
+		{60, map[int32]int{1: 10, 2: 10}, []int32{1, 2, allComets}, nil, false},
+		{40, map[int32]int{1: 10, 2: 10}, nil, []int32{1, 2, allComets}, true},
+	} {
+		ch := b.Changed()
+		b.check(queueStat{shared: c.shared, cometsFullest: c.comets})
+		select {
+		case <-ch:
+			if !c.changed {
+				t.Errorf("%d: Changed() closed, want open", i)
+			}
+		default:
+			if c.changed {
+				t.Errorf("%d: Changed() open, want closed", i)
+			}
+		}
+		for _, serverId := range c.paused {
+			if !b.Paused(serverId) {
+				t.Errorf("%d: Paused(%d) got false, want true", i, serverId)
+			}
+		}
+		for _, serverId := range c.ok {
+			if b.Paused(serverId) {
+				t.Errorf("%d: Paused(%d) got true, want false", i, serverId)
+			}
+		}
+	}
+	if _, _, _, pauses := b.Stat(); pauses != 2 {
+		t.Errorf("Stat() got pauses %d, want 2", pauses)
+	}
+	var nb *Backpressure
+	if nb.Paused(1) || nb.Paused(allComets) || nb.Changed() != nil {
+		t.Error("nil Backpressure paused")
+	}
+}
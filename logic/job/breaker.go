@@ -0,0 +1,161 @@
+package main
+
+import (
+	"goim/libs/proto"
+	"net/rpc"
+	"sync"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+// parkedCall is a comet rpc parked while the comet is unavailable.
+type parkedCall struct {
+	method string
+	arg    interface{}
+	p      *proto.Proto // the pushed proto, for the expiry
+	failed func()       // increase the failed stat
+}
+
+// breaker park the comet rpcs while the comet is unavailable, the parked
+// calls over max drop the oldest.
+type breaker struct {
+	lock   sync.Mutex
+	open   bool
+	max    int
+	parked []*parkedCall
+}
+
+// park park the call if the breaker is open or force, false if closed.
+func (b *breaker) park(call *parkedCall, force bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.open && !force {
+		return false
+	}
+	b.open = true
+	if b.max <= 0 {
+		parkDrop.Inc()
+		return true
+	}
+	if len(b.parked) >= b.max {
+		b.parked[0] = nil
+		b.parked = b.parked[1:]
+		parkDrop.Inc()
+	}
+	b.parked = append(b.parked, call)
+	return true
+}
+
+// pop get the oldest parked call, the breaker is closed if none, so the
+// new calls are after the parked ones.
+func (b *breaker) pop() (call *parkedCall) {
+	b.lock.Lock()
+	if len(b.parked) == 0 {
+		b.open = false
+		b.parked = nil
+	} else {
+		call = b.parked[0]
+		b.parked[0] = nil
+		b.parked = b.parked[1:]
+	}
+	b.lock.Unlock()
+	return
+}
+
+// unpop put back the call popped.
+func (b *breaker) unpop(call *parkedCall) {
+	b.lock.Lock()
+	b.parked = append([]*parkedCall{call}, b.parked...)
+	b.lock.Unlock()
+}
+
+// stat get the breaker state and the parked calls.
+func (b *breaker) stat() (open bool, n int) {
+	b.lock.Lock()
+	open, n = b.open, len(b.parked)
+	b.lock.Unlock()
+	return
+}
+
+// retryable report whether the error is of the rpc link, the errors
+// returned by the comet are not retried.
+func retryable(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return !ok
+}
+
+// backoff get the sleep before the nth retry, capped by BackoffMax also if
+// the doubling overflows.
+func (c *Comet) backoff(n int) (d time.Duration) {
+	var b = c.options.Backoff
+	if d = b << uint(n); n >= 63 || d>>uint(n) != b || d > c.options.BackoffMax || d <= 0 {
+		d = c.options.BackoffMax
+	}
+	return
+}
+
+// call call the comet with the bounded retries, the call is parked if the
+// comet is unavailable and replayed after it recovers.
+func (c *Comet) call(method string, arg interface{}, p *proto.Proto, failed func()) (err error) {
+	var (
+		reply = &proto.NoReply{}
+		call  = &parkedCall{method: method, arg: arg, p: p, failed: failed}
+	)
+	if c.breaker.park(call, false) {
+		return ErrCometParked
+	}
+	for i := 0; ; i++ {
+		if err = c.rpcClient.Call(method, arg, reply); err == nil || !retryable(err) {
+			break
+		}
+		if c.rpcClient.Available() != nil {
+			log.Warn("comet serverId:%d unavailable, park the calls", c.serverId)
+			c.breaker.park(call, true)
+			return ErrCometParked
+		}
+		if i >= c.options.Retry || p.Expired() {
+			break
+		}
+		retryCounter.Inc()
+		time.Sleep(c.backoff(i))
+	}
+	if err != nil {
+		log.Error("rpcClient.Call(%s, %v, reply) serverId:%d error(%v)", method, arg, c.serverId, err)
+		failed()
+	}
+	return
+}
+
+// recoverproc replay the parked calls after the comet is available again.
+func (c *Comet) recoverproc() {
+	var (
+		err   error
+		call  *parkedCall
+		reply = &proto.NoReply{}
+	)
+	for {
+		time.Sleep(c.options.Probe)
+		if open, _ := c.breaker.stat(); !open || c.rpcClient.Available() != nil {
+			continue
+		}
+		log.Info("comet serverId:%d available, replay the parked calls", c.serverId)
+		for call = c.breaker.pop(); call != nil; call = c.breaker.pop() {
+			if call.p.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
+			}
+			if err = c.rpcClient.Call(call.method, call.arg, reply); err != nil {
+				if retryable(err) && c.rpcClient.Available() != nil {
+					// down again
+					c.breaker.unpop(call)
+					break
+				}
+				log.Error("rpcClient.Call(%s, %v, reply) serverId:%d error(%v)", call.method, call.arg, c.serverId, err)
+				call.failed()
+				continue
+			}
+			replayCounter.Inc()
+		}
+	}
+}
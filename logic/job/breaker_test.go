@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newCalls new the parked calls named by the methods.
+func newCalls(methods string) (calls []*parkedCall) {
+	for _, m := range methods {
+		calls = append(calls, &parkedCall{method: string(m)})
+	}
+	return
+}
+
+// popAll pop all the parked calls, the methods in order.
+func popAll(b *breaker) (methods string) {
+	for call := b.pop(); call != nil; call = b.pop() {
+		methods += call.method
+	}
+	return
+}
+
+func TestBreakerPark(t *testing.T) {
+	for _, c := range []struct {
+		max    int
+		parked string
+		want   string
+	}{
+		{2, "", ""},
+		{2, "a", "a"},
+		{4, "abcd", "abcd"},
+		{2, "abcd", "cd"},
+		{1, "abcd", "d"},
+		{0, "abcd", ""},
+	} {
+		b := &breaker{max: c.max}
+		for _, call := range newCalls(c.parked) {
+			if !b.park(call, true) {
+				t.Errorf("max %d: park(%s, force) got false", c.max, call.method)
+			}
+		}
+		if got := popAll(b); got != c.want {
+			t.Errorf("max %d parked %q: popped %q, want %q", c.max, c.parked, got, c.want)
+		}
+		if open, n := b.stat(); open || n != 0 {
+			t.Errorf("max %d: stat() got open %v parked %d after drained, want closed", c.max, open, n)
+		}
+	}
+}
+
+func TestBreakerOpen(t *testing.T) {
+	b := &breaker{max: 4}
+	calls := newCalls("abc")
+	if b.park(calls[0], false) {
+		t.Fatal("park() on the closed breaker got true")
+	}
+	b.park(calls[0], true)
+	// the new calls are parked after the parked ones while open
+	if !b.park(calls[1], false) || !b.park(calls[2], false) {
+		t.Fatal("park() on the open breaker got false")
+	}
+	if open, n := b.stat(); !open || n != 3 {
+		t.Fatalf("stat() got open %v parked %d, want open 3", open, n)
+	}
+	if got := popAll(b); got != "abc" {
+		t.Errorf("popped %q, want abc", got)
+	}
+	if b.park(calls[0], false) {
+		t.Error("park() after drained got true, want closed")
+	}
+}
+
+func TestBreakerUnpop(t *testing.T) {
+	for _, c := range []struct {
+		parked string
+		pops   int // popped before the failed replay
+		want   string
+	}{
+		{"a", 1, "a"},
+		{"abc", 1, "abc"},
+		{"abc", 2, "bc"},
+		{"abc", 3, "c"},
+	} {
+		b := &breaker{max: 4}
+		for _, call := range newCalls(c.parked) {
+			b.park(call, true)
+		}
+		var call *parkedCall
+		for i := 0; i < c.pops; i++ {
+			call = b.pop()
+		}
+		// the replay failed, the call is put back in front and the
+		// breaker stays open
+		b.unpop(call)
+		if open, _ := b.stat(); !open {
+			t.Errorf("parked %q pops %d: breaker closed after unpop", c.parked, c.pops)
+		}
+		if !b.park(&parkedCall{method: "z"}, false) {
+			t.Errorf("parked %q pops %d: park() after unpop got false", c.parked, c.pops)
+		}
+		if got := popAll(b); got != c.want+"z" {
+			t.Errorf("parked %q pops %d: popped %q, want %q", c.parked, c.pops, got, c.want+"z")
+		}
+	}
+}
+
+func TestCometBackoff(t *testing.T) {
+	for _, v := range []struct {
+		backoff time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{10 * time.Millisecond, 0, 10 * time.Millisecond},
+		{10 * time.Millisecond, 1, 20 * time.Millisecond},
+		{10 * time.Millisecond, 6, 640 * time.Millisecond},
+		{10 * time.Millisecond, 7, time.Second},   // capped
+		{10 * time.Millisecond, 40, time.Second},  // overflow
+		{10 * time.Millisecond, 56, time.Second},  // overflow to the sign bit
+		{10 * time.Millisecond, 64, time.Second},  // shifted out
+		{10 * time.Millisecond, 200, time.Second}, // shifted out
+		{1<<62 + 1, 2, time.Second},               // overflow to a small positive
+	} {
+		c := &Comet{options: CometOptions{Backoff: v.backoff, BackoffMax: time.Second}}
+		if d := c.backoff(v.n); d != v.want {
+			t.Errorf("backoff %v: backoff(%d) got %v, want %v", v.backoff, v.n, d, v.want)
+		}
+	}
+}
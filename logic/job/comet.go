@@ -1,15 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"goim/libs/define"
 	inet "goim/libs/net"
 	"goim/libs/net/xrpc"
 	"goim/libs/proto"
+	"goim/libs/trace"
 
 	log "github.com/thinkboy/log4go"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -24,11 +27,20 @@ const (
 	CometServiceMPushMsg      = "PushRPC.MPushMsg"
 	CometServiceBroadcast     = "PushRPC.Broadcast"
 	CometServiceBroadcastRoom = "PushRPC.BroadcastRoom"
+	// topic
+	CometServiceTopics         = "PushRPC.Topics"
+	CometServiceBroadcastTopic = "PushRPC.BroadcastTopic"
 )
 
 type CometOptions struct {
 	RoutineSize uint64
 	RoutineChan int
+	// retry and breaker
+	Retry      int           // retries of a failed call
+	Backoff    time.Duration // sleep before the first retry, doubled after
+	BackoffMax time.Duration
+	ParkMax    int           // max parked calls while the comet is unavailable
+	Probe      time.Duration // check the comet recovered interval
 }
 
 type Comet struct {
@@ -37,16 +49,27 @@ type Comet struct {
 	pushRoutines         []chan *proto.MPushMsgArg
 	broadcastRoutines    []chan *proto.BoardcastArg
 	roomRoutines         []chan *proto.BoardcastRoomArg
-	pushRoutinesNum      uint64
+	topicRoutines        []chan *proto.BoardcastTopicArg
 	roomRoutinesNum      uint64
+	topicRoutinesNum     uint64
 	broadcastRoutinesNum uint64
+	breaker              breaker
 	options              CometOptions
 }
 
-// user push
+// user push, the keys are divided to the routines by hash so the messages
+// of a key are in order.
 func (c *Comet) Push(arg *proto.MPushMsgArg) (err error) {
-	num := atomic.AddUint64(&c.pushRoutinesNum, 1) % c.options.RoutineSize
-	c.pushRoutines[num] <- arg
+	keys, seqs := divideKeys(arg.Keys, arg.Seqs, int(c.options.RoutineSize))
+	if len(keys) == 1 {
+		for idx := range keys {
+			c.pushRoutines[idx] <- arg
+		}
+		return
+	}
+	for idx := range keys {
+		c.pushRoutines[idx] <- &proto.MPushMsgArg{Keys: keys[idx], Seqs: seqs[idx], P: arg.P, TraceId: arg.TraceId}
+	}
 	return
 }
 
@@ -57,6 +80,13 @@ func (c *Comet) BroadcastRoom(arg *proto.BoardcastRoomArg) (err error) {
 	return
 }
 
+// topic push
+func (c *Comet) BroadcastTopic(arg *proto.BoardcastTopicArg) (err error) {
+	num := atomic.AddUint64(&c.topicRoutinesNum, 1) % c.options.RoutineSize
+	c.topicRoutines[num] <- arg
+	return
+}
+
 // broadcast
 func (c *Comet) Broadcast(arg *proto.BoardcastArg) (err error) {
 	num := atomic.AddUint64(&c.broadcastRoutinesNum, 1) % c.options.RoutineSize
@@ -64,51 +94,93 @@ func (c *Comet) Broadcast(arg *proto.BoardcastArg) (err error) {
 	return
 }
 
-// process
-func (c *Comet) process(pushChan chan *proto.MPushMsgArg, roomChan chan *proto.BoardcastRoomArg, broadcastChan chan *proto.BoardcastArg) {
+// process call the comet rpcs of a routine, see call for the retries.
+func (c *Comet) process(pushChan chan *proto.MPushMsgArg, roomChan chan *proto.BoardcastRoomArg, topicChan chan *proto.BoardcastTopicArg, broadcastChan chan *proto.BoardcastArg) {
 	var (
 		pushArg      *proto.MPushMsgArg
 		roomArg      *proto.BoardcastRoomArg
+		topicArg     *proto.BoardcastTopicArg
 		broadcastArg *proto.BoardcastArg
-		reply        = &proto.NoReply{}
 		err          error
+		spans        []*trace.Span
 	)
 	for {
 		select {
 		case pushArg = <-pushChan:
 			// push
-			err = c.rpcClient.Call(CometServiceMPushMsg, pushArg, reply)
-			if err != nil {
-				log.Error("rpcClient.Call(%s, %v, reply) serverId:%d error(%v)", CometServiceMPushMsg, pushArg, c.serverId, err)
-				DefaultStat.IncrPushMsgFailed()
+			if pushArg.P.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
 			}
+			spans = startSpans("job.comet.mpush", pushArg.TraceId)
+			err = c.call(CometServiceMPushMsg, pushArg, &pushArg.P, DefaultStat.IncrPushMsgFailed)
+			finishSpans(spans, c.serverId, err)
 			pushArg = nil
 		case roomArg = <-roomChan:
 			// room
-			err = c.rpcClient.Call(CometServiceBroadcastRoom, roomArg, reply)
-			if err != nil {
-				log.Error("rpcClient.Call(%s, %v, reply) serverId:%d error(%v)", CometServiceBroadcastRoom, roomArg, c.serverId, err)
-				DefaultStat.IncrBroadcastRoomMsgFailed()
+			if roomArg.P.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
 			}
+			spans = startSpans("job.comet.broadcast_room", roomArg.TraceIds...)
+			err = c.call(CometServiceBroadcastRoom, roomArg, &roomArg.P, DefaultStat.IncrBroadcastRoomMsgFailed)
+			finishSpans(spans, c.serverId, err)
 			roomArg = nil
+		case topicArg = <-topicChan:
+			// topic
+			if topicArg.P.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
+			}
+			spans = startSpans("job.comet.broadcast_topic", topicArg.TraceId)
+			err = c.call(CometServiceBroadcastTopic, topicArg, &topicArg.P, DefaultStat.IncrBroadcastTopicMsgFailed)
+			finishSpans(spans, c.serverId, err)
+			topicArg = nil
 		case broadcastArg = <-broadcastChan:
 			// broadcast
-			err = c.rpcClient.Call(CometServiceBroadcast, broadcastArg, reply)
-			if err != nil {
-				log.Error("rpcClient.Call(%s, %v, reply) serverId:%d error(%v)", CometServiceBroadcast, broadcastArg, c.serverId, err)
-				DefaultStat.IncrBroadcastMsgFailed()
+			if broadcastArg.P.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
 			}
+			spans = startSpans("job.comet.broadcast", broadcastArg.TraceId)
+			err = c.call(CometServiceBroadcast, broadcastArg, &broadcastArg.P, DefaultStat.IncrBroadcastMsgFailed)
+			finishSpans(spans, c.serverId, err)
 			broadcastArg = nil
 		}
 	}
 }
 
+// startSpans start the comet rpc spans, one for each trace.
+func startSpans(name string, traceIds ...string) (spans []*trace.Span) {
+	for _, traceId := range traceIds {
+		if span := trace.Start(traceId, name); span != nil {
+			spans = append(spans, span)
+		}
+	}
+	return
+}
+
+// finishSpans finish the comet rpc spans.
+func finishSpans(spans []*trace.Span, serverId int32, err error) {
+	for _, span := range spans {
+		span.Set("server", serverId)
+		span.Error(err)
+		span.Finish()
+	}
+}
+
 func InitComet(addrs map[int32]string, options CometOptions) (err error) {
 	var (
 		serverId      int32
 		bind          string
 		network, addr string
+		auth          = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret)
+		tlsCfg        *tls.Config
 	)
+	if tlsCfg, err = auth.ClientTLS(); err != nil {
+		log.Error("rpc auth ClientTLS() error(%v)", err)
+		return
+	}
 	for serverId, bind = range addrs {
 		var rpcOptions []xrpc.ClientOptions
 		for _, bind = range strings.Split(bind, ",") {
@@ -117,8 +189,10 @@ func InitComet(addrs map[int32]string, options CometOptions) (err error) {
 				return
 			}
 			options := xrpc.ClientOptions{
-				Proto: network,
-				Addr:  addr,
+				Proto:  network,
+				Addr:   addr,
+				TLS:    tlsCfg,
+				Secret: auth.Secret,
 			}
 			rpcOptions = append(rpcOptions, options)
 		}
@@ -132,28 +206,48 @@ func InitComet(addrs map[int32]string, options CometOptions) (err error) {
 		c.rpcClient = rpcClient
 		c.pushRoutines = make([]chan *proto.MPushMsgArg, options.RoutineSize)
 		c.roomRoutines = make([]chan *proto.BoardcastRoomArg, options.RoutineSize)
+		c.topicRoutines = make([]chan *proto.BoardcastTopicArg, options.RoutineSize)
 		c.broadcastRoutines = make([]chan *proto.BoardcastArg, options.RoutineSize)
 		c.options = options
+		c.breaker.max = options.ParkMax
 		cometServiceMap[serverId] = c
 		// process
 		for i := uint64(0); i < options.RoutineSize; i++ {
 			pushChan := make(chan *proto.MPushMsgArg, options.RoutineChan)
 			roomChan := make(chan *proto.BoardcastRoomArg, options.RoutineChan)
+			topicChan := make(chan *proto.BoardcastTopicArg, options.RoutineChan)
 			broadcastChan := make(chan *proto.BoardcastArg, options.RoutineChan)
 			c.pushRoutines[i] = pushChan
 			c.roomRoutines[i] = roomChan
+			c.topicRoutines[i] = topicChan
 			c.broadcastRoutines[i] = broadcastChan
-			go c.process(pushChan, roomChan, broadcastChan)
+			go c.process(pushChan, roomChan, topicChan, broadcastChan)
 		}
+		go c.recoverproc()
 		log.Info("init comet rpc: %v", rpcOptions)
 	}
 	return
 }
 
 // mPushComet push a message to a batch of subkeys
-func mPushComet(serverId int32, subKeys []string, body json.RawMessage) {
+func mPushComet(serverId int32, subKeys []string, seqs []int32, body json.RawMessage, expire int64, traceId string) {
+	var args = proto.MPushMsgArg{
+		Keys: subKeys, Seqs: seqs, P: proto.Proto{Ver: 0, Operation: define.OP_SEND_SMS_REPLY, Body: body, Expire: expire}, TraceId: traceId,
+	}
+	if c, ok := cometServiceMap[serverId]; ok {
+		if err := c.Push(&args); err != nil {
+			log.Error("c.Push(%v) serverId:%d error(%v)", args, serverId, err)
+			DefaultStat.IncrPushMsgFailed()
+		}
+	}
+	DefaultStat.IncrPushMsg()
+}
+
+// kickComet send OP_DISCONNECT_REPLY to the subkeys, the comet closes the
+// connections after the reply
+func kickComet(serverId int32, subKeys []string, traceId string) {
 	var args = proto.MPushMsgArg{
-		Keys: subKeys, P: proto.Proto{Ver: 0, Operation: define.OP_SEND_SMS_REPLY, Body: body},
+		Keys: subKeys, P: proto.Proto{Ver: 0, Operation: define.OP_DISCONNECT_REPLY}, TraceId: traceId,
 	}
 	if c, ok := cometServiceMap[serverId]; ok {
 		if err := c.Push(&args); err != nil {
@@ -164,12 +258,26 @@ func mPushComet(serverId int32, subKeys []string, body json.RawMessage) {
 	DefaultStat.IncrPushMsg()
 }
 
-// broadcast broadcast a message to all
-func broadcast(msg []byte) {
+// skipPaused drop a broadcast to the comet if its queues are over, so a slow
+// comet never holds up the broadcasts to the others.
+func skipPaused(serverId int32, failed func()) bool {
+	if !DefaultBackpressure.CometPaused(serverId) {
+		return false
+	}
+	pausedDrop.Inc()
+	failed()
+	return true
+}
+
+// broadcast broadcast a message to all, or the connections match the filter
+func broadcast(msg []byte, filter string, expire int64, traceId string) {
 	var args = proto.BoardcastArg{
-		P: proto.Proto{Ver: 0, Operation: define.OP_SEND_SMS_REPLY, Body: msg},
+		P: proto.Proto{Ver: 0, Operation: define.OP_SEND_SMS_REPLY, Body: msg, Expire: expire}, TraceId: traceId, Filter: filter,
 	}
 	for serverId, c := range cometServiceMap {
+		if skipPaused(serverId, DefaultStat.IncrBroadcastMsgFailed) {
+			continue
+		}
 		if err := c.Broadcast(&args); err != nil {
 			log.Error("c.Broadcast(%v) serverId:%d error(%v)", args, serverId, err)
 			DefaultStat.IncrBroadcastMsgFailed()
@@ -179,9 +287,9 @@ func broadcast(msg []byte) {
 }
 
 // broadcastRoomBytes broadcast aggregation messages to room
-func broadcastRoomBytes(roomId int32, body []byte) {
+func broadcastRoomBytes(roomId string, body []byte, expire int64, traceIds []string) {
 	var (
-		args     = proto.BoardcastRoomArg{P: proto.Proto{Ver: 0, Operation: define.OP_RAW, Body: body}, RoomId: roomId}
+		args     = proto.BoardcastRoomArg{P: proto.Proto{Ver: 0, Operation: define.OP_RAW, Body: body, Expire: expire}, RoomId: roomId, TraceIds: traceIds}
 		c        *Comet
 		serverId int32
 		servers  map[int32]struct{}
@@ -191,9 +299,12 @@ func broadcastRoomBytes(roomId int32, body []byte) {
 	if servers, ok = RoomServersMap[roomId]; ok {
 		for serverId, _ = range servers {
 			if c, ok = cometServiceMap[serverId]; ok {
+				if skipPaused(serverId, DefaultStat.IncrBroadcastRoomMsgFailed) {
+					continue
+				}
 				// push routines
 				if err = c.BroadcastRoom(&args); err != nil {
-					log.Error("c.BroadcastRoom(%v) roomId:%d error(%v)", args, roomId, err)
+					log.Error("c.BroadcastRoom(%v) roomId:%s error(%v)", args, roomId, err)
 					DefaultStat.IncrBroadcastRoomMsgFailed()
 				}
 			}
@@ -202,7 +313,54 @@ func broadcastRoomBytes(roomId int32, body []byte) {
 	DefaultStat.IncrBroadcastRoomMsg()
 }
 
-func roomsComet(c *xrpc.Clients) map[int32]struct{} {
+// broadcastTopic broadcast a message to the comets which hold the topic, to
+// all the comets if the topic is not known yet, so the new subscriptions
+// before the next MergeTopicServers are not missed.
+func broadcastTopic(topic string, msg []byte, expire int64, traceId string) {
+	var (
+		args = proto.BoardcastTopicArg{
+			Topic: topic, P: proto.Proto{Ver: 0, Operation: define.OP_SEND_SMS_REPLY, Body: msg, Expire: expire}, TraceId: traceId,
+		}
+		c        *Comet
+		serverId int32
+		servers  map[int32]struct{}
+		ok       bool
+		err      error
+	)
+	if servers, ok = TopicServers(topic); !ok {
+		servers = make(map[int32]struct{}, len(cometServiceMap))
+		for serverId = range cometServiceMap {
+			servers[serverId] = struct{}{}
+		}
+	}
+	for serverId = range servers {
+		if c, ok = cometServiceMap[serverId]; ok {
+			if skipPaused(serverId, DefaultStat.IncrBroadcastTopicMsgFailed) {
+				continue
+			}
+			if err = c.BroadcastTopic(&args); err != nil {
+				log.Error("c.BroadcastTopic(%v) topic:%s error(%v)", args, topic, err)
+				DefaultStat.IncrBroadcastTopicMsgFailed()
+			}
+		}
+	}
+	DefaultStat.IncrBroadcastTopicMsg()
+}
+
+func topicsComet(c *xrpc.Clients) map[string]struct{} {
+	var (
+		args  = proto.NoArg{}
+		reply = proto.TopicsReply{}
+		err   error
+	)
+	if err = c.Call(CometServiceTopics, &args, &reply); err != nil {
+		log.Error("c.Call(%s, args, reply) error(%v)", CometServiceTopics, err)
+		return nil
+	}
+	return reply.Topics
+}
+
+func roomsComet(c *xrpc.Clients) map[string]struct{} {
 	var (
 		args  = proto.NoArg{}
 		reply = proto.RoomsReply{}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync/atomic"
 	"time"
 )
 
@@ -9,18 +10,31 @@ const (
 )
 
 var (
-	RoomServersMap = make(map[int32]map[int32]struct{}) // roomid:servers
+	RoomServersMap = make(map[string]map[int32]struct{}) // roomid:servers
+	// topic:servers, replaced as a whole while the pushers read it
+	topicServersMap atomic.Value
 )
 
+func init() {
+	topicServersMap.Store(make(map[string]map[int32]struct{}))
+}
+
+// TopicServers get the comets which hold the topic, ok is false if the
+// topic is not known yet, it may be just subscribed.
+func TopicServers(topic string) (servers map[int32]struct{}, ok bool) {
+	servers, ok = topicServersMap.Load().(map[string]map[int32]struct{})[topic]
+	return
+}
+
 func MergeRoomServers() {
 	var (
 		c           *Comet
 		ok          bool
-		roomId      int32
+		roomId      string
 		serverId    int32
-		roomIds     map[int32]struct{}
+		roomIds     map[string]struct{}
 		servers     map[int32]struct{}
-		roomServers = make(map[int32]map[int32]struct{})
+		roomServers = make(map[string]map[int32]struct{})
 	)
 	// all comet nodes
 	for serverId, c = range cometServiceMap {
@@ -40,9 +54,40 @@ func MergeRoomServers() {
 	RoomServersMap = roomServers
 }
 
+// MergeTopicServers learn the comets which hold the channels subscribed
+// the topics.
+func MergeTopicServers() {
+	var (
+		c            *Comet
+		ok           bool
+		topic        string
+		serverId     int32
+		topics       map[string]struct{}
+		servers      map[int32]struct{}
+		topicServers = make(map[string]map[int32]struct{})
+	)
+	// all comet nodes
+	for serverId, c = range cometServiceMap {
+		if c.rpcClient != nil {
+			if topics = topicsComet(c.rpcClient); topics != nil {
+				// merge topic's servers
+				for topic = range topics {
+					if servers, ok = topicServers[topic]; !ok {
+						servers = make(map[int32]struct{})
+						topicServers[topic] = servers
+					}
+					servers[serverId] = struct{}{}
+				}
+			}
+		}
+	}
+	topicServersMap.Store(topicServers)
+}
+
 func SyncRoomServers() {
 	for {
 		MergeRoomServers()
+		MergeTopicServers()
 		time.Sleep(syncRoomServersDelay)
 	}
 }
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestTopicServers(t *testing.T) {
+	defer topicServersMap.Store(make(map[string]map[int32]struct{}))
+	if _, ok := TopicServers("news"); ok {
+		t.Fatal("TopicServers(news) known before merged")
+	}
+	topicServersMap.Store(map[string]map[int32]struct{}{"news": {1: {}}})
+	if servers, ok := TopicServers("news"); !ok || len(servers) != 1 {
+		t.Errorf("TopicServers(news) got %v %t", servers, ok)
+	}
+	if _, ok := TopicServers("sports"); ok {
+		t.Error("TopicServers(sports) got known")
+	}
+}
@@ -20,16 +20,35 @@ func init() {
 }
 
 type Config struct {
-	Log        string   `goconf:"base:log"`
-	PprofAddrs []string `goconf:"base:pprof.addrs:,"`
-	ZKAddrs    []string `goconf:"kafka:zookeeper.list:,"`
-	ZKRoot     string   `goconf:"kafka:zkroot"`
-	KafkaGroup string   `goconf:"kafka:group"`
-	KafkaTopic string   `goconf:"kafka:topic"`
+	Log          string   `goconf:"base:log"`
+	PprofAddrs   []string `goconf:"base:pprof.addrs:,"`
+	KafkaAddrs   []string `goconf:"kafka:addrs:,"`
+	KafkaVersion string   `goconf:"kafka:version"`
+	KafkaGroup   string   `goconf:"kafka:group"`
+	KafkaTopic   string   `goconf:"kafka:topic"`
+	// kafka backpressure
+	KafkaPauseHigh  int           `goconf:"kafka:pause.high"`
+	KafkaPauseLow   int           `goconf:"kafka:pause.low"`
+	KafkaPauseCheck time.Duration `goconf:"kafka:pause.check:time"`
+	// the messages to the paused comets held per partition
+	KafkaPartitionHold int `goconf:"kafka:partition.hold"`
 	// comet
 	Comets      map[int32]string `goconf:"-"`
 	RoutineSize uint64           `goconf:"comet:routine.size"`
 	RoutineChan int              `goconf:"comet:routine.chan"`
+	// comet retry and breaker
+	CometRetry      int           `goconf:"comet:retry"`
+	CometBackoff    time.Duration `goconf:"comet:retry.backoff:time"`
+	CometBackoffMax time.Duration `goconf:"comet:retry.backoff.max:time"`
+	CometParkMax    int           `goconf:"comet:park.max"`
+	CometProbe      time.Duration `goconf:"comet:probe:time"`
+	// rpc auth
+	RPCTLSOpen       bool   `goconf:"rpc.auth:tls.open"`
+	RPCTLSCert       string `goconf:"rpc.auth:tls.cert"`
+	RPCTLSKey        string `goconf:"rpc.auth:tls.key"`
+	RPCTLSCA         string `goconf:"rpc.auth:tls.ca"`
+	RPCTLSServerName string `goconf:"rpc.auth:tls.server.name"`
+	RPCSecret        string `goconf:"rpc.auth:secret"`
 	// push
 	PushChan     int `goconf:"push:chan"`
 	PushChanSize int `goconf:"push:chan.size"`
@@ -37,31 +56,62 @@ type Config struct {
 	Timer     int `goconf:"timer:num"`
 	TimerSize int `goconf:"timer:size"`
 	// room
-	RoomBatch  int           `goconf:"room:batch"`
-	RoomSignal time.Duration `goconf:"room:signal:time"`
-	RoomIdle   time.Duration `goconf:"room:idle:time"`
+	RoomBatch      int           `goconf:"room:batch"`
+	RoomBatchBytes int           `goconf:"room:batch.bytes"`
+	RoomHotRate    int           `goconf:"room:hot.rate"`
+	RoomSignal     time.Duration `goconf:"room:signal:time"`
+	RoomIdle       time.Duration `goconf:"room:idle:time"`
+	HistorySize    int           `goconf:"room:history.size"`
+	HistoryAge     time.Duration `goconf:"room:history.age:time"`
 	// monitor
-	MonitorOpen  bool     `goconf:"monitor:open"`
-	MonitorAddrs []string `goconf:"monitor:addrs:,"`
+	MonitorOpen     bool     `goconf:"monitor:open"`
+	MonitorAddrs    []string `goconf:"monitor:addrs:,"`
+	MonitorAdminKey string   `goconf:"monitor:admin.key"`
+	// trace
+	TraceSample   int           `goconf:"trace:sample"`
+	TraceURL      string        `goconf:"trace:exporter.url"`
+	TraceBatch    int           `goconf:"trace:exporter.batch"`
+	TraceInterval time.Duration `goconf:"trace:exporter.interval:time"`
+	TraceQueue    int           `goconf:"trace:exporter.queue"`
 }
 
 func NewConfig() *Config {
 	return &Config{
 		Comets:       make(map[int32]string),
-		ZKRoot:       "",
+		KafkaAddrs:   []string{"localhost:9092"},
+		KafkaVersion: "0.10.2.0",
 		KafkaGroup:   "kafka_topic_push_group",
 		KafkaTopic:   "KafkaPushsTopic",
 		RoutineSize:  16,
 		RoutineChan:  64,
 		PushChan:     4,
 		PushChanSize: 100,
+		// comet retry and breaker
+		CometRetry:      3,
+		CometBackoff:    100 * time.Millisecond,
+		CometBackoffMax: time.Second,
+		CometParkMax:    10000,
+		CometProbe:      time.Second,
+		// kafka backpressure
+		KafkaPauseHigh:     80,
+		KafkaPauseLow:      50,
+		KafkaPauseCheck:    100 * time.Millisecond,
+		KafkaPartitionHold: 1024,
 		// room
-		RoomBatch:  40,
-		RoomSignal: time.Second,
-		RoomIdle:   time.Hour,
+		RoomBatch:      40,
+		RoomBatchBytes: 1024,
+		RoomHotRate:    10,
+		RoomSignal:     time.Second,
+		RoomIdle:       time.Hour,
+		HistorySize:    20,
+		HistoryAge:     10 * time.Minute,
 		// timer
 		Timer:     runtime.NumCPU(),
 		TimerSize: 1000,
+		// trace
+		TraceBatch:    512,
+		TraceInterval: 5 * time.Second,
+		TraceQueue:    10240,
 	}
 }
 
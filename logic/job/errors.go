@@ -8,6 +8,8 @@ var (
 	// comet
 	ErrComet     = errors.New("comet rpc is not available")
 	ErrCometFull = errors.New("comet proto chan full")
+	// parked while the comet is unavailable, replayed after it recovers
+	ErrCometParked = errors.New("comet rpc parked")
 	// room
 	ErrRoomFull = errors.New("room proto chan full")
 )
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"goim/libs/proto"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultHistory *History
+)
+
+// historyRing is the latest messages of a room, the oldest is overwritten.
+type historyRing struct {
+	msgs []*proto.RoomHistoryMsg
+	next int
+	num  int
+}
+
+func (r *historyRing) put(m *proto.RoomHistoryMsg) {
+	r.msgs[r.next] = m
+	if r.next++; r.next == len(r.msgs) {
+		r.next = 0
+	}
+	if r.num < len(r.msgs) {
+		r.num++
+	}
+}
+
+// last get the newest message.
+func (r *historyRing) last() *proto.RoomHistoryMsg {
+	if r.num == 0 {
+		return nil
+	}
+	return r.msgs[(r.next-1+len(r.msgs))%len(r.msgs)]
+}
+
+// History keep the latest messages of the rooms in memory, bounded by the
+// count and the age, so the late joiners can catch up. the room messages are
+// keyed by the room id in kafka, so a room is consumed by one job which keeps
+// its whole history.
+type History struct {
+	lock  sync.RWMutex
+	size  int
+	age   time.Duration
+	rooms map[string]*historyRing
+}
+
+// InitHistory init the global room history, disabled if size is 0.
+func InitHistory(size int, age time.Duration) {
+	if size <= 0 {
+		return
+	}
+	DefaultHistory = NewHistory(size, age)
+	if age > 0 {
+		go DefaultHistory.cleanproc(age)
+	}
+}
+
+// NewHistory new a room history keeps the latest size messages not older
+// than age of a room, 0 age means no age limit.
+func NewHistory(size int, age time.Duration) *History {
+	return &History{size: size, age: age, rooms: make(map[string]*historyRing)}
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Add keep a message of the room, nil history ignores.
+func (h *History) Add(roomId string, msg []byte, expire int64) {
+	if h == nil {
+		return
+	}
+	m := &proto.RoomHistoryMsg{Msg: msg, Time: nowMs(), Expire: expire}
+	h.lock.Lock()
+	r, ok := h.rooms[roomId]
+	if !ok {
+		r = &historyRing{msgs: make([]*proto.RoomHistoryMsg, h.size)}
+		h.rooms[roomId] = r
+	}
+	r.put(m)
+	h.lock.Unlock()
+}
+
+// Get get the latest n messages after since(unix milliseconds) of the room
+// from the oldest, the expired messages are skipped, n 0 means all.
+func (h *History) Get(roomId string, n int, since int64) (msgs []*proto.RoomHistoryMsg) {
+	if h == nil {
+		return
+	}
+	var now = nowMs()
+	if h.age > 0 {
+		if min := now - int64(h.age/time.Millisecond); since < min {
+			since = min
+		}
+	}
+	h.lock.RLock()
+	if r, ok := h.rooms[roomId]; ok {
+		for i := 0; i < r.num; i++ {
+			m := r.msgs[(r.next-r.num+i+len(r.msgs))%len(r.msgs)]
+			if m.Time <= since || (m.Expire > 0 && now > m.Expire) {
+				continue
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	h.lock.RUnlock()
+	if n > 0 && len(msgs) > n {
+		msgs = msgs[len(msgs)-n:]
+	}
+	return
+}
+
+// Del delete the history of the room.
+func (h *History) Del(roomId string) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	delete(h.rooms, roomId)
+	h.lock.Unlock()
+}
+
+// clean delete the rooms whose newest message is older than age.
+func (h *History) clean() {
+	var min = nowMs() - int64(h.age/time.Millisecond)
+	h.lock.Lock()
+	for roomId, r := range h.rooms {
+		if m := r.last(); m == nil || m.Time <= min {
+			delete(h.rooms, roomId)
+		}
+	}
+	h.lock.Unlock()
+}
+
+func (h *History) cleanproc(d time.Duration) {
+	for {
+		time.Sleep(d)
+		h.clean()
+	}
+}
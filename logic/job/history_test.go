@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3, 0)
+	for _, m := range []string{"a", "b", "c", "d"} {
+		h.Add("1", []byte(m), 0)
+	}
+	h.Add("2", []byte("x"), 1)
+	for _, c := range []struct {
+		room string
+		n    int
+		want string
+	}{
+		{"1", 0, "bcd"},
+		{"1", 2, "cd"},
+		{"2", 0, ""}, // expired
+		{"3", 0, ""},
+	} {
+		var got string
+		for _, m := range h.Get(c.room, c.n, 0) {
+			got += string(m.Msg)
+		}
+		if got != c.want {
+			t.Errorf("Get(%s, %d) = %q, want %q", c.room, c.n, got, c.want)
+		}
+	}
+	if msgs := h.Get("1", 0, nowMs()+1); len(msgs) != 0 {
+		t.Errorf("since: %d", len(msgs))
+	}
+	h.Del("1")
+	if msgs := h.Get("1", 0, 0); len(msgs) != 0 {
+		t.Errorf("deleted: %d", len(msgs))
+	}
+	// nil history ignores
+	var nh *History
+	nh.Add("1", []byte("a"), 0)
+	if msgs := nh.Get("1", 0, 0); msgs != nil {
+		t.Errorf("nil: %d", len(msgs))
+	}
+}
+
+func TestHistoryClean(t *testing.T) {
+	h := NewHistory(3, time.Millisecond)
+	h.Add("1", []byte("a"), 0)
+	time.Sleep(2 * time.Millisecond)
+	h.clean()
+	if len(h.rooms) != 0 {
+		t.Errorf("rooms: %d", len(h.rooms))
+	}
+}
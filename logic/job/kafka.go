@@ -1,45 +1,185 @@
 package main
 
 import (
+	"context"
+	"goim/libs/proto"
 	llog "log"
 	"os"
 	"time"
 
 	"github.com/Shopify/sarama"
 	log "github.com/thinkboy/log4go"
-	"github.com/wvanbergen/kafka/consumergroup"
 )
 
 const (
-	OFFSETS_PROCESSING_TIMEOUT_SECONDS = 10 * time.Second
-	OFFSETS_COMMIT_INTERVAL            = 10 * time.Second
+	OFFSETS_COMMIT_INTERVAL = 10 * time.Second
+	// the consumer group rejoins after the error
+	CONSUME_RETRY_INTERVAL = time.Second
 )
 
-func InitKafka() error {
+func InitKafka() (err error) {
 	log.Info("start topic:%s consumer", Conf.KafkaTopic)
 	log.Info("consumer group name:%s", Conf.KafkaGroup)
 	sarama.Logger = llog.New(os.Stdout, "[Sarama] ", llog.LstdFlags)
-	config := consumergroup.NewConfig()
-	config.Offsets.Initial = sarama.OffsetNewest
-	config.Offsets.ProcessingTimeout = OFFSETS_PROCESSING_TIMEOUT_SECONDS
-	config.Offsets.CommitInterval = OFFSETS_COMMIT_INTERVAL
-	config.Zookeeper.Chroot = Conf.ZKRoot
-	kafkaTopics := []string{Conf.KafkaTopic}
-	cg, err := consumergroup.JoinConsumerGroup(Conf.KafkaGroup, kafkaTopics, Conf.ZKAddrs, config)
+	config := sarama.NewConfig()
+	if config.Version, err = sarama.ParseKafkaVersion(Conf.KafkaVersion); err != nil {
+		return
+	}
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	config.Consumer.Offsets.AutoCommit.Interval = OFFSETS_COMMIT_INTERVAL
+	config.Consumer.Return.Errors = true
+	group, err := sarama.NewConsumerGroup(Conf.KafkaAddrs, Conf.KafkaGroup, config)
 	if err != nil {
-		return err
+		return
 	}
 	go func() {
-		for err := range cg.Errors() {
+		for err := range group.Errors() {
 			log.Error("consumer error(%v)", err)
 		}
 	}()
 	go func() {
-		for msg := range cg.Messages() {
-			log.Info("deal with topic:%s, partitionId:%d, Offset:%d, Key:%s msg:%s", msg.Topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
-			push(msg.Value)
-			cg.CommitUpto(msg)
+		var (
+			topics = []string{Conf.KafkaTopic}
+			c      = &consumer{group: group}
+		)
+		// returns after a rebalance, then joins again
+		for {
+			if err := group.Consume(context.Background(), topics, c); err != nil {
+				log.Error("group.Consume(%v) error(%v)", topics, err)
+				time.Sleep(CONSUME_RETRY_INTERVAL)
+			}
+		}
+	}()
+	return
+}
+
+// consumer consume the claimed partitions apart, each in its goroutine.
+type consumer struct {
+	group sarama.ConsumerGroup
+}
+
+func (c *consumer) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (c *consumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim dispatch the messages of a partition, see partition. the
+// fetch of the partition is paused while its held messages are over
+// partition.hold or the shared queues are over, the other partitions go on.
+func (c *consumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var (
+		paused bool
+		msgs   <-chan *sarama.ConsumerMessage
+		parts  = map[string][]int32{claim.Topic(): {claim.Partition()}}
+		p      = newPartition(Conf.KafkaPartitionHold, push, func(offset int64) {
+			sess.MarkOffset(claim.Topic(), claim.Partition(), offset, "")
+		})
+	)
+	defer func() {
+		// the held messages are not marked, consumed again by the next owner
+		if paused {
+			c.group.Resume(parts)
 		}
 	}()
-	return nil
+	for {
+		changed := DefaultBackpressure.Changed()
+		p.release()
+		if full := p.full(); full != paused {
+			if paused = full; paused {
+				c.group.Pause(parts)
+			} else {
+				c.group.Resume(parts)
+			}
+		}
+		if msgs = claim.Messages(); paused {
+			msgs = nil
+		}
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			log.Info("deal with topic:%s, partitionId:%d, Offset:%d, Key:%s msg:%s", msg.Topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
+			m, err := decode(msg.Value)
+			if err != nil {
+				m = nil
+			}
+			p.dispatch(msg.Offset, m)
+		case <-changed:
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// heldMsg is a message held while its comet is paused.
+type heldMsg struct {
+	offset int64
+	m      *proto.KafkaMsg
+}
+
+// partition dispatch the messages of a partition in order, the messages to
+// a paused comet are held while the others go on, the messages of a comet
+// stay in order. the offset is marked before the oldest held message, so
+// they are consumed again after a crash or rebalance.
+type partition struct {
+	max  int // the held messages pause the fetch over it
+	n    int
+	held map[int32][]*heldMsg // serverId->messages
+	next int64                // the offset after the last dispatched, -1 none
+	push func(*proto.KafkaMsg) error
+	mark func(offset int64)
+}
+
+func newPartition(max int, push func(*proto.KafkaMsg) error, mark func(int64)) *partition {
+	return &partition{max: max, held: make(map[int32][]*heldMsg), next: -1, push: push, mark: mark}
+}
+
+// dispatch push or hold the message at offset, nil skipped.
+func (p *partition) dispatch(offset int64, m *proto.KafkaMsg) {
+	p.next = offset + 1
+	if m != nil {
+		if serverId := cometOf(m); serverId != allComets && (len(p.held[serverId]) > 0 || DefaultBackpressure.Paused(serverId)) {
+			p.held[serverId] = append(p.held[serverId], &heldMsg{offset: offset, m: m})
+			p.n++
+		} else {
+			p.push(m)
+		}
+	}
+	p.commit()
+}
+
+// release push the held messages of the resumed comets.
+func (p *partition) release() {
+	if p.n == 0 {
+		return
+	}
+	for serverId, msgs := range p.held {
+		if DefaultBackpressure.Paused(serverId) {
+			continue
+		}
+		for _, h := range msgs {
+			p.push(h.m)
+		}
+		p.n -= len(msgs)
+		delete(p.held, serverId)
+	}
+	p.commit()
+}
+
+// full report whether the fetch should be paused.
+func (p *partition) full() bool {
+	return (p.max > 0 && p.n >= p.max) || DefaultBackpressure.Paused(allComets)
+}
+
+// commit mark the offset before the oldest held message.
+func (p *partition) commit() {
+	var offset = p.next
+	for _, msgs := range p.held {
+		if msgs[0].offset < offset {
+			offset = msgs[0].offset
+		}
+	}
+	if offset >= 0 {
+		p.mark(offset)
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"goim/libs/define"
+	"goim/libs/proto"
+	"testing"
+)
+
+func TestPartitionHold(t *testing.T) {
+	var (
+		pushed []int32
+		marked int64 = -1
+		b            = NewBackpressure(80, 50)
+		p            = newPartition(2, func(m *proto.KafkaMsg) error {
+			pushed = append(pushed, m.ServerId)
+			return nil
+		}, func(offset int64) { marked = offset })
+		multi = func(serverId int32) *proto.KafkaMsg {
+			return &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_MULTI, ServerId: serverId}
+		}
+	)
+	DefaultBackpressure = b
+	defer func() { DefaultBackpressure = nil }()
+	// the comet 1 is paused
+	b.check(queueStat{cometsFullest: map[int32]int{1: 90, 2: 10}})
+	p.dispatch(0, multi(1))
+	p.dispatch(1, multi(2))
+	p.dispatch(2, &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST})
+	if len(pushed) != 2 || pushed[0] != 2 || marked != 0 {
+		t.Fatalf("pushed %v marked %d, want [2 0] 0", pushed, marked)
+	}
+	if p.full() {
+		t.Fatal("full with 1 held")
+	}
+	p.dispatch(3, multi(1))
+	if !p.full() {
+		t.Fatal("not full with 2 held")
+	}
+	// resumed, the held messages in order
+	b.check(queueStat{cometsFullest: map[int32]int{1: 10, 2: 10}})
+	p.release()
+	if len(pushed) != 4 || pushed[2] != 1 || pushed[3] != 1 || marked != 4 || p.full() {
+		t.Fatalf("pushed %v marked %d, want [2 0 1 1] 4", pushed, marked)
+	}
+	// the shared queues pause the fetch
+	b.check(queueStat{shared: 90})
+	if !p.full() {
+		t.Fatal("not full with the shared queues over")
+	}
+	// undecoded messages are skipped
+	p.dispatch(5, nil)
+	if marked != 6 {
+		t.Fatalf("marked %d, want 6", marked)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"goim/libs/perf"
+	"goim/libs/trace"
 	"infrastructure/loggingclient"
 	"runtime"
 
@@ -26,19 +27,32 @@ func main() {
 	log.LoadConfiguration(Conf.Log)
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	perf.Init(Conf.PprofAddrs)
+	trace.Init(trace.Options{
+		Service:  "goim-job",
+		Sample:   Conf.TraceSample,
+		URL:      Conf.TraceURL,
+		Batch:    Conf.TraceBatch,
+		Interval: Conf.TraceInterval,
+		Queue:    Conf.TraceQueue,
+	})
 	DefaultStat = NewStat()
 	// comet
 	err := InitComet(Conf.Comets,
 		CometOptions{
 			RoutineSize: Conf.RoutineSize,
 			RoutineChan: Conf.RoutineChan,
+			Retry:       Conf.CometRetry,
+			Backoff:     Conf.CometBackoff,
+			BackoffMax:  Conf.CometBackoffMax,
+			ParkMax:     Conf.CometParkMax,
+			Probe:       Conf.CometProbe,
 		})
 	if err != nil {
 		guluLogger.Warn("comet rpc current can't connect, retry")
 	}
 	// start monitor
 	if Conf.MonitorOpen {
-		InitMonitor(Conf.MonitorAddrs)
+		InitMonitor(Conf.MonitorAddrs, Conf.MonitorAdminKey)
 	}
 	// round
 	round := NewRound(RoundOptions{
@@ -49,13 +63,18 @@ func main() {
 	InitRoomBucket(round,
 		RoomOptions{
 			BatchNum:   Conf.RoomBatch,
+			BatchBytes: Conf.RoomBatchBytes,
+			HotRate:    Conf.RoomHotRate,
 			SignalTime: Conf.RoomSignal,
 			IdleTime:   Conf.RoomIdle,
 		})
+	InitHistory(Conf.HistorySize, Conf.HistoryAge)
 	//room info
 	MergeRoomServers()
 	go SyncRoomServers()
 	InitPush()
+	// pause the consumption by the queue depths
+	InitBackpressure(Conf.KafkaPauseHigh, Conf.KafkaPauseLow, Conf.KafkaPauseCheck)
 	if err := InitKafka(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"goim/libs/metrics"
+	"time"
+)
+
+const (
+	metricPush      = "push"
+	metricBroadcast = "broadcast"
+	metricRoom      = "broadcast_room"
+	metricTopic     = "broadcast_topic"
+	// room batch flushes
+	flushCold   = "cold"
+	flushCount  = "count"
+	flushBytes  = "bytes"
+	flushSignal = "signal"
+)
+
+var (
+	consumeCounter = metrics.NewCounterVec("goim_job_kafka_consume_total", "kafka consumed messages by operation.", "op")
+	consumeLag     = metrics.NewHistogramVec("goim_job_kafka_lag_seconds", "kafka lag from produced to consumed.", []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}, "op")
+	pushCounter    = metrics.NewCounterVec("goim_job_push_total", "comet pushes by type.", "type")
+	failedCounter  = metrics.NewCounterVec("goim_job_push_failed_total", "comet failed pushes by type.", "type")
+	dropCounter    = metrics.NewCounterVec("goim_job_drop_total", "dropped messages by reason.", "reason")
+	cometCounter   = metrics.NewCounterVec("goim_job_comet_calls_total", "comet rpc retries and replays by kind.", "kind")
+	pauseVec       = metrics.NewCounterVec("goim_job_kafka_pauses_total", "kafka consumption pauses by the queue depths.")
+	batchMsgs      = metrics.NewHistogramVec("goim_job_room_batch_msgs", "msgs of the room batches by flush reason.", []float64{1, 2, 5, 10, 20, 40, 80, 160, 320}, "reason")
+	batchBytes     = metrics.NewHistogramVec("goim_job_room_batch_bytes", "bytes of the room batches by flush reason.", []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576}, "reason")
+	batchDelay     = metrics.NewHistogramVec("goim_job_room_batch_delay_seconds", "latency added by the room batching, from the first msg to the flush.", []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5}, "reason")
+
+	roomDrop    = dropCounter.WithLabelValues("room_full")
+	expiredDrop = dropCounter.WithLabelValues("expired")
+	parkDrop    = dropCounter.WithLabelValues("comet_parked")
+	pausedDrop  = dropCounter.WithLabelValues("comet_paused")
+	// breaker
+	retryCounter  = cometCounter.WithLabelValues("retry")
+	replayCounter = cometCounter.WithLabelValues("replay")
+	// backpressure
+	pauseCounter = pauseVec.WithLabelValues()
+)
+
+func init() {
+	metrics.MustRegister(consumeCounter, consumeLag, pushCounter, failedCounter, dropCounter, cometCounter, pauseVec, batchMsgs, batchBytes, batchDelay,
+		metrics.NewGaugeFunc("goim_job_rooms", "active batching rooms.", func() float64 {
+			if roomBucket == nil {
+				return 0
+			}
+			return float64(roomBucket.Size())
+		}),
+		metrics.NewGaugeFunc("goim_job_queue_fullest_percent", "the fullest of the push, comet and room queues.", func() float64 {
+			s, _, _, _ := DefaultBackpressure.Stat()
+			return float64(s.fullest)
+		}),
+		metrics.NewGaugeFunc("goim_job_kafka_paused", "1 if the kafka consumption of all is paused.", func() float64 {
+			if _, paused, _, _ := DefaultBackpressure.Stat(); paused {
+				return 1
+			}
+			return 0
+		}),
+		metrics.NewGaugeFunc("goim_job_kafka_paused_comets", "comets the kafka consumption to is paused.", func() float64 {
+			_, _, comets, _ := DefaultBackpressure.Stat()
+			return float64(len(comets))
+		}),
+		metrics.NewGaugeFunc("goim_job_comet_breakers_open", "comets unavailable with the calls parked.", func() float64 {
+			var n int
+			for _, c := range cometServiceMap {
+				if open, _ := c.breaker.stat(); open {
+					n++
+				}
+			}
+			return float64(n)
+		}),
+		metrics.NewGaugeFunc("goim_job_comet_parked", "parked calls of the unavailable comets.", func() float64 {
+			var n int
+			for _, c := range cometServiceMap {
+				_, parked := c.breaker.stat()
+				n += parked
+			}
+			return float64(n)
+		}),
+	)
+}
+
+// observeRoomBatch observe a flushed room batch.
+func observeRoomBatch(reason string, msgs, bytes int, delay time.Duration) {
+	batchMsgs.WithLabelValues(reason).Observe(float64(msgs))
+	batchBytes.WithLabelValues(reason).Observe(float64(bytes))
+	batchDelay.WithLabelValues(reason).Observe(delay.Seconds())
+}
+
+// observeConsume observe the kafka lag, t is the produced unix time in
+// milliseconds, 0 if the producer not set.
+func observeConsume(op string, t int64) {
+	consumeCounter.WithLabelValues(op).Inc()
+	if t > 0 {
+		lag := time.Since(time.Unix(0, t*int64(time.Millisecond)))
+		consumeLag.WithLabelValues(op).Observe(lag.Seconds())
+		DefaultStat.SetConsumeLag(lag)
+	}
+}
@@ -1,25 +1,37 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	log "github.com/thinkboy/log4go"
+	"goim/libs/metrics"
+	"goim/libs/proto"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
 	OK = 1
+
+	monitorKeyHeader = "X-Api-Key"
 )
 
 type Monitor struct {
 }
 
-// StartPprof start http monitor.
-func InitMonitor(binds []string) {
+// StartPprof start http monitor, the room batch changes must carry the key
+// if not empty, or come from the loopback.
+func InitMonitor(binds []string, key string) {
 	m := new(Monitor)
 	monitorServeMux := http.NewServeMux()
 	monitorServeMux.HandleFunc("/monitor/ping", m.Ping)
 	monitorServeMux.HandleFunc("/monitor/stat", m.Stat)
+	monitorServeMux.HandleFunc("/monitor/room/batch", monitorAuth(key, m.RoomBatch))
+	monitorServeMux.HandleFunc("/monitor/room/history", m.RoomHistory)
+	monitorServeMux.Handle("/metrics", metrics.Handler())
 	for _, addr := range binds {
 		log.Info("start monitor listen: \"%s\"", addr)
 		go func(bind string) {
@@ -31,6 +43,36 @@ func InitMonitor(binds []string) {
 	}
 }
 
+// monitorAuth check the key in the X-Api-Key header of the requests except
+// GET, only the loopback clients are allowed if no key.
+func monitorAuth(key string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+		} else if key != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(monitorKeyHeader)), []byte(key)) != 1 {
+				log.Warn("monitor %s %s unauthorized from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if !loopback(r.RemoteAddr) {
+			log.Warn("monitor %s %s forbidden from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// loopback report whether the remote address is a loopback address.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // monitor ping
 func (m *Monitor) Ping(w http.ResponseWriter, r *http.Request) {
 	for _, c := range cometServiceMap {
@@ -61,3 +103,134 @@ func (m *Monitor) Stat(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(b)
 }
+
+// monitor room batch, get, tune or restore the batching of a running room
+func (m *Monitor) RoomBatch(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		b       []byte
+		roomId  string
+		options RoomOptions
+		tuned   bool
+		res     = map[string]interface{}{"ret": OK}
+	)
+	if err = r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("parse form error(%v)", err), http.StatusBadRequest)
+		return
+	}
+	if roomId = r.Form.Get("rid"); roomId == "" || roomBucket == nil {
+		http.Error(w, "rid required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		options, _ = roomBucket.Options(roomId)
+		if err = parseRoomOptions(r, &options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !roomBucket.Tune(roomId, &options) {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+	case "DELETE":
+		roomBucket.Tune(roomId, nil)
+	}
+	options, tuned = roomBucket.Options(roomId)
+	res["data"] = map[string]interface{}{
+		"batch":       options.BatchNum,
+		"batch.bytes": options.BatchBytes,
+		"hot.rate":    options.HotRate,
+		"signal":      options.SignalTime.String(),
+		"tuned":       tuned,
+	}
+	if b, err = json.Marshal(res); err != nil {
+		log.Error("json.Marshal(%v) error(%v)", res, err)
+		return
+	}
+	w.Write(b)
+}
+
+// monitor room history, get (GET) the latest messages after since of a room
+// from the oldest or delete (DELETE) the history of a room on this job.
+func (m *Monitor) RoomHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		b      []byte
+		roomId string
+		n      int
+		since  int64
+		msgs   []*proto.RoomHistoryMsg
+		res    = map[string]interface{}{"ret": OK}
+	)
+	if err = r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("parse form error(%v)", err), http.StatusBadRequest)
+		return
+	}
+	if roomId = r.Form.Get("rid"); roomId == "" {
+		http.Error(w, "rid required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if s := r.Form.Get("n"); s != "" {
+			if n, err = strconv.Atoi(s); err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid n:%s", s), http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.Form.Get("since"); s != "" {
+			if since, err = strconv.ParseInt(s, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid since:%s", s), http.StatusBadRequest)
+				return
+			}
+		}
+		if msgs = DefaultHistory.Get(roomId, n, since); msgs == nil {
+			msgs = []*proto.RoomHistoryMsg{}
+		}
+		res["data"] = msgs
+	case "DELETE":
+		DefaultHistory.Del(roomId)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b, err = json.Marshal(res); err != nil {
+		log.Error("json.Marshal(%v) error(%v)", res, err)
+		return
+	}
+	w.Write(b)
+}
+
+// parseRoomOptions set the batching in the request, the omitted unchanged.
+func parseRoomOptions(r *http.Request, options *RoomOptions) (err error) {
+	var (
+		i int
+		d time.Duration
+	)
+	if s := r.Form.Get("batch"); s != "" {
+		if i, err = strconv.Atoi(s); err != nil || i <= 0 {
+			return fmt.Errorf("invalid batch:%s", s)
+		}
+		options.BatchNum = i
+	}
+	if s := r.Form.Get("batch.bytes"); s != "" {
+		if i, err = strconv.Atoi(s); err != nil || i < 0 || i > int(proto.MaxBodySize) {
+			return fmt.Errorf("invalid batch.bytes:%s", s)
+		}
+		options.BatchBytes = i
+	}
+	if s := r.Form.Get("hot.rate"); s != "" {
+		if i, err = strconv.Atoi(s); err != nil || i < 0 {
+			return fmt.Errorf("invalid hot.rate:%s", s)
+		}
+		options.HotRate = i
+	}
+	if s := r.Form.Get("signal"); s != "" {
+		if d, err = time.ParseDuration(s); err != nil || d <= 0 {
+			return fmt.Errorf("invalid signal:%s", s)
+		}
+		options.SignalTime = d
+	}
+	return nil
+}
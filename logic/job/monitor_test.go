@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorAuth(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	for _, c := range []struct {
+		key, header, method, addr string
+		code                      int
+	}{
+		{"", "", "GET", "10.0.0.1:1", 200},
+		{"", "", "POST", "127.0.0.1:1", 200},
+		{"", "", "POST", "10.0.0.1:1", 403},
+		{"k", "", "POST", "127.0.0.1:1", 401},
+		{"k", "x", "DELETE", "10.0.0.1:1", 401},
+		{"k", "k", "POST", "10.0.0.1:1", 200},
+	} {
+		r := httptest.NewRequest(c.method, "/monitor/room/batch?rid=1", nil)
+		r.RemoteAddr = c.addr
+		if c.header != "" {
+			r.Header.Set(monitorKeyHeader, c.header)
+		}
+		w := httptest.NewRecorder()
+		monitorAuth(c.key, h)(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s key:%q header:%q from %s got %d, want %d", c.method, c.key, c.header, c.addr, w.Code, c.code)
+		}
+	}
+}
+
+func TestRoomBatchTune(t *testing.T) {
+	var (
+		m       = new(Monitor)
+		options = RoomOptions{BatchNum: 40, BatchBytes: 1024, HotRate: 10, SignalTime: time.Second}
+	)
+	InitRoomBucket(nil, options)
+	defer func() { roomBucket = nil }()
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		m.RoomBatch(w, httptest.NewRequest("POST", "/monitor/room/batch?"+query, nil))
+		return w
+	}
+	// not running
+	if w := post("rid=1&batch=10"); w.Code != http.StatusNotFound {
+		t.Fatalf("tune not running room got %d", w.Code)
+	}
+	if len(roomBucket.tunes) != 0 {
+		t.Fatalf("tunes got %v", roomBucket.tunes)
+	}
+	room := &Room{id: "1", options: options}
+	roomBucket.rooms["1"] = room
+	if w := post("rid=1&batch.bytes=4096"); w.Code != http.StatusBadRequest {
+		t.Errorf("batch.bytes over the max body got %d", w.Code)
+	}
+	if w := post("rid=1&batch=10"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"tuned":true`) {
+		t.Fatalf("tune got %d %s", w.Code, w.Body.String())
+	}
+	if o := room.Options(); o.BatchNum != 10 || o.HotRate != 10 {
+		t.Errorf("room options got %+v", o)
+	}
+	// dropped with the idle room
+	roomBucket.Del("1")
+	if _, tuned := roomBucket.Options("1"); tuned {
+		t.Error("tune of the deleted room kept")
+	}
+}
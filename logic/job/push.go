@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"goim/libs/define"
 	"goim/libs/proto"
-	"math/rand"
+	"goim/libs/trace"
+	"hash/crc32"
+	"time"
 
 	log "github.com/thinkboy/log4go"
 )
@@ -12,8 +14,11 @@ import (
 type pushArg struct {
 	ServerId int32
 	SubKeys  []string
+	Seqs     []int32
 	Msg      []byte
-	RoomId   int32
+	RoomId   string
+	TraceId  string
+	Expire   int64
 }
 
 var (
@@ -33,31 +38,93 @@ func processPush(ch chan *pushArg) {
 	var arg *pushArg
 	for {
 		arg = <-ch
-		mPushComet(arg.ServerId, arg.SubKeys, arg.Msg)
+		mPushComet(arg.ServerId, arg.SubKeys, arg.Seqs, arg.Msg, arg.Expire, arg.TraceId)
 	}
 }
 
-func push(msg []byte) (err error) {
-	m := &proto.KafkaMsg{}
+// subKeyIdx hash the sub key to one of n, a sub key always goes through the
+// same push chan and comet routine so its messages are in order.
+func subKeyIdx(key string, n int) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % uint32(n))
+}
+
+// divideKeys divide the sub keys and their optional seqs by subKeyIdx.
+func divideKeys(keys []string, seqs []int32, n int) (dkeys map[int][]string, dseqs map[int][]int32) {
+	dkeys = make(map[int][]string)
+	if len(seqs) == len(keys) {
+		dseqs = make(map[int][]int32)
+	}
+	for i, key := range keys {
+		idx := subKeyIdx(key, n)
+		dkeys[idx] = append(dkeys[idx], key)
+		if dseqs != nil {
+			dseqs[idx] = append(dseqs[idx], seqs[i])
+		}
+	}
+	return
+}
+
+// decode decode the kafka message.
+func decode(msg []byte) (m *proto.KafkaMsg, err error) {
+	m = &proto.KafkaMsg{}
 	if err = json.Unmarshal(msg, m); err != nil {
 		log.Error("json.Unmarshal(%s) error(%s)", msg, err)
+	}
+	return
+}
+
+// cometOf get the comet the message is pushed to, allComets if all.
+func cometOf(m *proto.KafkaMsg) int32 {
+	if m.OP == define.KAFKA_MESSAGE_MULTI || m.OP == define.KAFKA_MESSAGE_KICK {
+		return m.ServerId
+	}
+	return allComets
+}
+
+func push(m *proto.KafkaMsg) (err error) {
+	observeConsume(m.OP, m.Time)
+	span := trace.Start(m.TraceId, "job.consume")
+	span.Set("op", m.OP)
+	defer span.Finish()
+	// expired by kafka lag
+	if m.Expire > 0 && time.Now().UnixNano()/int64(time.Millisecond) > m.Expire {
+		span.Set("expired", true)
+		DefaultStat.IncrExpiredMsg()
+		DefaultStat.IncrAllMsg()
 		return
 	}
 	switch m.OP {
 	case define.KAFKA_MESSAGE_MULTI:
-		pushChs[rand.Int()%Conf.PushChan] <- &pushArg{ServerId: m.ServerId, SubKeys: m.SubKeys, Msg: m.Msg, RoomId: define.NoRoom}
+		span.Set("server", m.ServerId)
+		span.Set("keys", len(m.SubKeys))
+		keys, seqs := divideKeys(m.SubKeys, m.Seqs, Conf.PushChan)
+		for idx := range keys {
+			pushChs[idx] <- &pushArg{ServerId: m.ServerId, SubKeys: keys[idx], Seqs: seqs[idx], Msg: m.Msg, RoomId: define.NoRoom, TraceId: m.TraceId, Expire: m.Expire}
+		}
+	case define.KAFKA_MESSAGE_KICK:
+		span.Set("server", m.ServerId)
+		span.Set("keys", len(m.SubKeys))
+		kickComet(m.ServerId, m.SubKeys, m.TraceId)
 	case define.KAFKA_MESSAGE_BROADCAST:
-		broadcast(m.Msg)
+		span.Set("filter", m.Filter)
+		broadcast(m.Msg, m.Filter, m.Expire, m.TraceId)
 	case define.KAFKA_MESSAGE_BROADCAST_ROOM:
-		room := roomBucket.Get(int32(m.RoomId))
+		span.Set("room", m.RoomId)
+		DefaultHistory.Add(string(m.RoomId), m.Msg, m.Expire)
+		room := roomBucket.Get(string(m.RoomId))
 		if m.Ensure {
-			go room.EPush(0, define.OP_SEND_SMS_REPLY, m.Msg)
+			go room.EPush(0, define.OP_SEND_SMS_REPLY, m.Msg, m.Expire, m.TraceId)
 		} else {
-			err = room.Push(0, define.OP_SEND_SMS_REPLY, m.Msg)
+			err = room.Push(0, define.OP_SEND_SMS_REPLY, m.Msg, m.Expire, m.TraceId)
 			if err != nil {
-				log.Error("room.Push(%s) roomId:%d error(%v)", m.Msg, err)
+				span.Error(err)
+				roomDrop.Inc()
+				log.Error("room.Push(%s) roomId:%s error(%v)", m.Msg, m.RoomId, err)
 			}
 		}
+	case define.KAFKA_MESSAGE_BROADCAST_TOPIC:
+		span.Set("topic", m.Topic)
+		broadcastTopic(m.Topic, m.Msg, m.Expire, m.TraceId)
 	default:
 		log.Error("unknown operation:%s", m.OP)
 	}
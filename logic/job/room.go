@@ -19,38 +19,42 @@ var roomBucket *RoomBucket
 
 type RoomBucket struct {
 	roomNum int
-	rooms   map[int32]*Room
+	rooms   map[string]*Room
 	bLock   sync.RWMutex
 	options RoomOptions
+	tunes   map[string]RoomOptions // the per room batching
 	round   *Round
 }
 
 func InitRoomBucket(r *Round, options RoomOptions) {
 	roomBucket = &RoomBucket{
 		roomNum: 0,
-		rooms:   make(map[int32]*Room, roomMapCup),
+		rooms:   make(map[string]*Room, roomMapCup),
 		bLock:   sync.RWMutex{},
 		options: options,
+		tunes:   make(map[string]RoomOptions),
 		round:   r,
 	}
 }
 
-func (b *RoomBucket) Get(roomId int32) (r *Room) {
+func (b *RoomBucket) Get(roomId string) (r *Room) {
 	b.bLock.Lock()
 	room, ok := b.rooms[roomId]
 	if !ok {
 		room = NewRoom(roomId, b.round.Timer(b.roomNum), b.options)
 		b.rooms[roomId] = room
 		b.roomNum++
-		log.Debug("new roomId:%d num:%d", roomId, b.roomNum)
+		log.Debug("new roomId:%s num:%d", roomId, b.roomNum)
 	}
 	b.bLock.Unlock()
 	return room
 }
 
-func (b *RoomBucket) Del(roomId int32) {
+// Del delete the idle room, the batching tuned is dropped with it.
+func (b *RoomBucket) Del(roomId string) {
 	b.bLock.Lock()
 	delete(b.rooms, roomId)
+	delete(b.tunes, roomId)
 	b.bLock.Unlock()
 }
 
@@ -58,33 +62,95 @@ func (b *RoomBucket) Size() int {
 	return len(b.rooms)
 }
 
+// Options get the batching of the room, tuned is false if the room uses the
+// default.
+func (b *RoomBucket) Options(roomId string) (options RoomOptions, tuned bool) {
+	b.bLock.RLock()
+	if options, tuned = b.tunes[roomId]; !tuned {
+		options = b.options
+	}
+	b.bLock.RUnlock()
+	return
+}
+
+// Tune set the batching of the running room, nil restores the default, false
+// if the room is not running. the tuning is dropped when the room is idle,
+// so the tunes are at most the running rooms. the channel of the room is
+// not resized, it's sized by the batch when the room starts.
+func (b *RoomBucket) Tune(roomId string, options *RoomOptions) (ok bool) {
+	var room *Room
+	b.bLock.Lock()
+	if room, ok = b.rooms[roomId]; ok {
+		if options != nil {
+			b.tunes[roomId] = *options
+		} else {
+			delete(b.tunes, roomId)
+			options = &b.options
+		}
+		room.SetOptions(*options)
+	}
+	b.bLock.Unlock()
+	return
+}
+
+// RoomOptions is the batching of a room. the rooms receiving less than
+// HotRate msgs per second push every msg immediately, the hot rooms merge
+// the msgs until BatchNum msgs, BatchBytes bytes or SignalTime after the
+// first msg, 0 HotRate always batches. BatchBytes is clamped to
+// proto.MaxBodySize, 0 means proto.MaxBodySize.
 type RoomOptions struct {
 	BatchNum   int
+	BatchBytes int
+	HotRate    int
 	SignalTime time.Duration
 	IdleTime   time.Duration
 }
 
 type Room struct {
-	id    int32
-	proto chan *proto.Proto
+	id      string
+	proto   chan *roomProto
+	lock    sync.RWMutex
+	options RoomOptions
+}
+
+// roomProto is a room message with the trace id.
+type roomProto struct {
+	proto.Proto
+	traceId string
 }
 
 var (
-	roomReadyProto = &proto.Proto{Operation: define.OP_ROOM_READY}
+	roomReadyProto = &roomProto{Proto: proto.Proto{Operation: define.OP_ROOM_READY}}
 )
 
 // NewRoom new a room struct, store channel room info.
-func NewRoom(id int32, t *itime.Timer, options RoomOptions) (r *Room) {
+func NewRoom(id string, t *itime.Timer, options RoomOptions) (r *Room) {
 	r = new(Room)
 	r.id = id
-	r.proto = make(chan *proto.Proto, options.BatchNum*2)
-	go r.pushproc(t, options.BatchNum, options.SignalTime, options.IdleTime)
+	r.options = options
+	r.proto = make(chan *roomProto, options.BatchNum*2)
+	go r.pushproc(t)
+	return
+}
+
+// Options get the batching of the room.
+func (r *Room) Options() (options RoomOptions) {
+	r.lock.RLock()
+	options = r.options
+	r.lock.RUnlock()
 	return
 }
 
+// SetOptions set the batching of the room, applied from the next msg.
+func (r *Room) SetOptions(options RoomOptions) {
+	r.lock.Lock()
+	r.options = options
+	r.lock.Unlock()
+}
+
 // Push push msg to the room, if chan full discard it.
-func (r *Room) Push(ver int16, operation int32, msg []byte) (err error) {
-	var p = &proto.Proto{Ver: ver, Operation: operation, Body: msg}
+func (r *Room) Push(ver int16, operation int32, msg []byte, expire int64, traceId string) (err error) {
+	var p = &roomProto{Proto: proto.Proto{Ver: ver, Operation: operation, Body: msg, Expire: expire}, traceId: traceId}
 	select {
 	case r.proto <- p:
 	default:
@@ -94,22 +160,56 @@ func (r *Room) Push(ver int16, operation int32, msg []byte) (err error) {
 }
 
 // EPush ensure push msg to the room.
-func (r *Room) EPush(ver int16, operation int32, msg []byte) {
-	var p = &proto.Proto{Ver: ver, Operation: operation, Body: msg}
+func (r *Room) EPush(ver int16, operation int32, msg []byte, expire int64, traceId string) {
+	var p = &roomProto{Proto: proto.Proto{Ver: ver, Operation: operation, Body: msg, Expire: expire}, traceId: traceId}
 	r.proto <- p
 	return
 }
 
-// pushproc merge proto and push msgs in batch.
-func (r *Room) pushproc(timer *itime.Timer, batch int, sigTime time.Duration, idleTime time.Duration) {
+// roomRate count the msgs of a room in the last second.
+type roomRate struct {
+	start time.Time
+	n     int
+	last  int // msgs of the previous second
+}
+
+// incr count a msg and get the msgs per second.
+func (r *roomRate) incr(now time.Time) int {
+	if d := now.Sub(r.start); d >= time.Second {
+		if d >= 2*time.Second {
+			r.last = 0
+		} else {
+			r.last = r.n
+		}
+		r.start, r.n = now, 0
+	}
+	if r.n++; r.n > r.last {
+		return r.n
+	}
+	return r.last
+}
+
+// pushproc merge proto and push msgs in batch, the expired msgs are dropped
+// when the batch is sent.
+func (r *Room) pushproc(timer *itime.Timer) {
 	var (
-		n   int
-		p   *proto.Proto
-		td  *itime.TimerData
-		buf = bytes.NewWriterSize(int(proto.MaxBodySize))
+		n        int
+		size     int
+		p        *roomProto
+		options  = r.Options()
+		ps       = make([]*roomProto, 0, options.BatchNum)
+		td       *itime.TimerData
+		buf      = bytes.NewWriterSize(int(proto.MaxBodySize))
+		traceIds []string
+		expire   int64
+		now      time.Time
+		first    time.Time // the first msg of the batch arrived
+		rate     roomRate
+		reason   string
+		maxBytes int
 	)
-	guluLogger.Debug("start room: %d goroutine", r.id)
-	td = timer.Add(idleTime, func() {
+	guluLogger.Debug("start room: %s goroutine", r.id)
+	td = timer.Add(options.IdleTime, func() {
 		select {
 		case r.proto <- roomReadyProto:
 		default:
@@ -117,27 +217,65 @@ func (r *Room) pushproc(timer *itime.Timer, batch int, sigTime time.Duration, id
 	})
 	for {
 		if p = <-r.proto; p != roomReadyProto {
-			// merge buffer ignore error, always nil
-			p.WriteTo(buf)
-			// batch
+			ps = append(ps, p)
+			size += int(proto.RawHeaderSize) + len(p.Body)
+			now = time.Now()
+			options = r.Options()
+			// the batch is pushed as a body of comet
+			if maxBytes = options.BatchBytes; maxBytes <= 0 || maxBytes > int(proto.MaxBodySize) {
+				maxBytes = int(proto.MaxBodySize)
+			}
 			if n++; n == 1 {
-				timer.Set(td, sigTime)
-				continue
-			} else if n < batch {
+				first = now
+			}
+			// batch
+			if rate.incr(now) < options.HotRate {
+				reason = flushCold
+			} else if n >= options.BatchNum {
+				reason = flushCount
+			} else if size >= maxBytes {
+				reason = flushBytes
+			} else {
+				if n == 1 {
+					timer.Set(td, options.SignalTime)
+				}
 				continue
 			}
 		} else if n == 0 {
 			// idle
 			break
+		} else {
+			reason = flushSignal
+		}
+		timer.Set(td, options.IdleTime)
+		// the batch expires when all msgs expire, 0 if any never expires
+		expire = -1
+		for _, p = range ps {
+			if p.Expired() {
+				DefaultStat.IncrExpiredMsg()
+				continue
+			}
+			// merge buffer ignore error, always nil
+			p.WriteTo(buf)
+			if p.traceId != "" {
+				traceIds = append(traceIds, p.traceId)
+			}
+			if expire != 0 && (p.Expire == 0 || p.Expire > expire) {
+				expire = p.Expire
+			}
+		}
+		observeRoomBatch(reason, n, buf.Len(), time.Since(first))
+		if expire >= 0 {
+			broadcastRoomBytes(r.id, buf.Buffer(), expire, traceIds)
+			// TODO use reset buffer
+			// after push to room channel, renew a buffer, let old buffer gc
+			buf = bytes.NewWriterSize(buf.Size())
 		}
-		timer.Set(td, idleTime)
-		broadcastRoomBytes(r.id, buf.Buffer())
-		// TODO use reset buffer
-		// after push to room channel, renew a buffer, let old buffer gc
-		buf = bytes.NewWriterSize(buf.Size())
-		n = 0
+		ps = ps[:0]
+		traceIds = nil
+		n, size = 0, 0
 	}
 	timer.Del(td)
 	roomBucket.Del(r.id)
-	log.Debug("end room: %d goroutine exit", r.id)
+	log.Debug("end room: %s goroutine exit", r.id)
 }
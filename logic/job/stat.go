@@ -7,19 +7,33 @@ import (
 
 type Stat struct {
 	// messages
-	AllMsg           uint64 `json:"all_msg"`
-	PushMsg          uint64 `json:"push_msg"`
-	BroadcastMsg     uint64 `json:"broadcast_msg"`
-	BroadcastRoomMsg uint64 `json:"broadcast_room_msg"`
+	AllMsg            uint64 `json:"all_msg"`
+	PushMsg           uint64 `json:"push_msg"`
+	BroadcastMsg      uint64 `json:"broadcast_msg"`
+	BroadcastRoomMsg  uint64 `json:"broadcast_room_msg"`
+	BroadcastTopicMsg uint64 `json:"broadcast_topic_msg"`
 	// miss
-	PushMsgFailed          uint64 `json:"push_msg_failed"`
-	BroadcastMsgFailed     uint64 `json:"broadcast_msg_failed"`
-	BroadcastRoomMsgFailed uint64 `json:"broadcast_room_msg_failed"`
+	PushMsgFailed           uint64 `json:"push_msg_failed"`
+	BroadcastMsgFailed      uint64 `json:"broadcast_msg_failed"`
+	BroadcastRoomMsgFailed  uint64 `json:"broadcast_room_msg_failed"`
+	BroadcastTopicMsgFailed uint64 `json:"broadcast_topic_msg_failed"`
+	// expired messages dropped before the comet rpc
+	ExpiredMsg uint64 `json:"expired_msg"`
 	// speed
 	SpeedMsgSecond       uint64 `json:"speed_msg_second"`
 	SpeedRoomBatchSecond uint64 `json:"speed_room_batch_second"`
+	// kafka lag of the last consumed message in milliseconds
+	ConsumeLag int64 `json:"consume_lag"`
 	// room
 	ActiveRoomCount int `json:"active_room_count"`
+	// queue depths of the last backpressure check
+	PushQueue    int           `json:"push_queue"`
+	CometQueue   map[int32]int `json:"comet_queue"`
+	RoomQueue    int           `json:"room_queue"`
+	QueueFullest int           `json:"queue_fullest"` // percent of the fullest queue
+	Paused       bool          `json:"paused"`        // kafka consumption of all paused
+	PausedComets []int32       `json:"paused_comets"` // kafka consumption to the comets paused
+	PauseCount   uint64        `json:"pause_count"`
 	// nodes
 	CometNodes map[int32]string `json:"comet_nodes"`
 }
@@ -32,6 +46,9 @@ func NewStat() *Stat {
 
 func (s *Stat) Info() *Stat {
 	s.ActiveRoomCount = roomBucket.Size()
+	q, paused, comets, pauses := DefaultBackpressure.Stat()
+	s.PushQueue, s.CometQueue, s.RoomQueue, s.QueueFullest = q.push, q.comets, q.rooms, q.fullest
+	s.Paused, s.PausedComets, s.PauseCount = paused, comets, pauses
 	s.CometNodes = Conf.Comets
 	return s
 }
@@ -41,9 +58,12 @@ func (s *Stat) Reset() {
 	atomic.StoreUint64(&s.PushMsg, 0)
 	atomic.StoreUint64(&s.BroadcastMsg, 0)
 	atomic.StoreUint64(&s.BroadcastRoomMsg, 0)
+	atomic.StoreUint64(&s.BroadcastTopicMsg, 0)
 	atomic.StoreUint64(&s.PushMsgFailed, 0)
 	atomic.StoreUint64(&s.BroadcastMsgFailed, 0)
 	atomic.StoreUint64(&s.BroadcastRoomMsgFailed, 0)
+	atomic.StoreUint64(&s.BroadcastTopicMsgFailed, 0)
+	atomic.StoreUint64(&s.ExpiredMsg, 0)
 }
 
 func (s *Stat) procSpeed() {
@@ -63,26 +83,51 @@ func (s *Stat) procSpeed() {
 
 func (s *Stat) IncrPushMsg() {
 	atomic.AddUint64(&s.PushMsg, 1)
+	pushCounter.WithLabelValues(metricPush).Inc()
 }
 
 func (s *Stat) IncrBroadcastMsg() {
 	atomic.AddUint64(&s.BroadcastMsg, 1)
+	pushCounter.WithLabelValues(metricBroadcast).Inc()
 }
 
 func (s *Stat) IncrBroadcastRoomMsg() {
 	atomic.AddUint64(&s.BroadcastRoomMsg, 1)
+	pushCounter.WithLabelValues(metricRoom).Inc()
+}
+
+func (s *Stat) IncrBroadcastTopicMsg() {
+	atomic.AddUint64(&s.BroadcastTopicMsg, 1)
+	pushCounter.WithLabelValues(metricTopic).Inc()
 }
 
 func (s *Stat) IncrPushMsgFailed() {
 	atomic.AddUint64(&s.PushMsgFailed, 1)
+	failedCounter.WithLabelValues(metricPush).Inc()
 }
 
 func (s *Stat) IncrBroadcastMsgFailed() {
 	atomic.AddUint64(&s.BroadcastMsgFailed, 1)
+	failedCounter.WithLabelValues(metricBroadcast).Inc()
 }
 
 func (s *Stat) IncrBroadcastRoomMsgFailed() {
 	atomic.AddUint64(&s.BroadcastRoomMsgFailed, 1)
+	failedCounter.WithLabelValues(metricRoom).Inc()
+}
+
+func (s *Stat) IncrBroadcastTopicMsgFailed() {
+	atomic.AddUint64(&s.BroadcastTopicMsgFailed, 1)
+	failedCounter.WithLabelValues(metricTopic).Inc()
+}
+
+func (s *Stat) IncrExpiredMsg() {
+	atomic.AddUint64(&s.ExpiredMsg, 1)
+	expiredDrop.Inc()
+}
+
+func (s *Stat) SetConsumeLag(lag time.Duration) {
+	atomic.StoreInt64(&s.ConsumeLag, int64(lag/time.Millisecond))
 }
 
 func (s *Stat) IncrAllMsg() {
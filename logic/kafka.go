@@ -5,6 +5,9 @@ import (
 	"goim/libs/define"
 	"goim/libs/encoding/binary"
 	"goim/libs/proto"
+	"goim/libs/trace"
+	"strconv"
+	"time"
 
 	"github.com/Shopify/sarama"
 	log "github.com/thinkboy/log4go"
@@ -38,6 +41,7 @@ func handleSuccess() {
 		}
 		// increase msg succeeded stat
 		DefaultStat.IncrMsgSucceeded()
+		finishProduce(pm, metricSuccess, nil)
 	}
 }
 
@@ -52,43 +56,77 @@ func handleError() {
 		}
 		// increase msg failed stat
 		DefaultStat.IncrMsgFailed()
+		if err != nil {
+			finishProduce(err.Msg, metricError, err.Err)
+		}
 	}
 }
 
-func mpushKafka(serverId int32, keys []string, msg []byte) (err error) {
-	var (
-		vBytes []byte
-		v      = &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_MULTI, ServerId: serverId, SubKeys: keys, Msg: msg}
-	)
-	if vBytes, err = json.Marshal(v); err != nil {
-		return
-	}
-	producer.Input() <- &sarama.ProducerMessage{Topic: Conf.KafkaTopic, Value: sarama.ByteEncoder(vBytes)}
-	return
+// produceMeta is the metadata of a produced message, used by the acks.
+type produceMeta struct {
+	start time.Time
+	span  *trace.Span
+	ack   func(error) // nil no ack
 }
 
-func broadcastKafka(msg []byte) (err error) {
+// produce send the kafka message with the produce time and trace id, the ack
+// if not nil is called once by the result of the producer, or by the error
+// returned.
+func produce(key sarama.Encoder, v *proto.KafkaMsg, parent *trace.Span, ack func(error)) (err error) {
 	var (
 		vBytes []byte
-		v      = &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST, Msg: msg}
+		meta   = &produceMeta{start: time.Now(), span: parent.Child("logic.kafka.produce"), ack: ack}
 	)
+	v.Time = meta.start.UnixNano() / int64(time.Millisecond)
+	v.TraceId = parent.TraceId()
 	if vBytes, err = json.Marshal(v); err != nil {
+		meta.span.Error(err)
+		meta.span.Finish()
+		if ack != nil {
+			ack(err)
+		}
 		return
 	}
-	producer.Input() <- &sarama.ProducerMessage{Topic: Conf.KafkaTopic, Value: sarama.ByteEncoder(vBytes)}
+	meta.span.Set("op", v.OP)
+	producer.Input() <- &sarama.ProducerMessage{Topic: Conf.KafkaTopic, Key: key, Value: sarama.ByteEncoder(vBytes), Metadata: meta}
 	return
 }
 
-func broadcastRoomKafka(rid int32, msg []byte, ensure bool) (err error) {
-	var (
-		vBytes   []byte
-		ridBytes [4]byte
-		v        = &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST_ROOM, RoomId: rid, Msg: msg, Ensure: ensure}
-	)
-	if vBytes, err = json.Marshal(v); err != nil {
-		return
+// serverKey is the kafka key of the user messages, the messages of a comet
+// are in the same partition so they are consumed in order.
+func serverKey(serverId int32) sarama.Encoder {
+	return sarama.StringEncoder(strconv.FormatInt(int64(serverId), 10))
+}
+
+func mpushKafka(serverId int32, keys []string, seqs []int32, msg []byte, expire int64, span *trace.Span, ack func(error)) (err error) {
+	return produce(serverKey(serverId), &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_MULTI, ServerId: serverId, SubKeys: keys, Seqs: seqs, Msg: msg, Expire: expire}, span, ack)
+}
+
+func kickKafka(serverId int32, keys []string) (err error) {
+	return produce(serverKey(serverId), &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_KICK, ServerId: serverId, SubKeys: keys}, nil, nil)
+}
+
+func broadcastKafka(msg []byte, filter string, expire int64, span *trace.Span, ack func(error)) (err error) {
+	return produce(nil, &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST, Msg: msg, Filter: filter, Expire: expire}, span, ack)
+}
+
+func broadcastTopicKafka(topic string, msg []byte, expire int64, span *trace.Span, ack func(error)) (err error) {
+	return produce(sarama.StringEncoder(topic), &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST_TOPIC, Topic: topic, Msg: msg, Expire: expire}, span, ack)
+}
+
+// roomKey is the kafka key of the room messages, a room is in a partition so
+// it is consumed by one job in order. an id of an int32 number keeps the 4
+// big endian bytes of the int32 room ids, so the rooms stay in their
+// partitions and jobs through the upgrade, the others are the id string.
+func roomKey(rid string) sarama.Encoder {
+	if i, err := strconv.ParseInt(rid, 10, 32); err == nil && strconv.FormatInt(i, 10) == rid {
+		var ridBytes [4]byte
+		binary.BigEndian.PutInt32(ridBytes[:], int32(i))
+		return sarama.ByteEncoder(ridBytes[:])
 	}
-	binary.BigEndian.PutInt32(ridBytes[:], rid)
-	producer.Input() <- &sarama.ProducerMessage{Topic: Conf.KafkaTopic, Key: sarama.ByteEncoder(ridBytes[:]), Value: sarama.ByteEncoder(vBytes)}
-	return
+	return sarama.StringEncoder(rid)
+}
+
+func broadcastRoomKafka(rid string, msg []byte, ensure bool, expire int64, span *trace.Span, ack func(error)) (err error) {
+	return produce(roomKey(rid), &proto.KafkaMsg{OP: define.KAFKA_MESSAGE_BROADCAST_ROOM, RoomId: proto.RoomId(rid), Msg: msg, Ensure: ensure, Expire: expire}, span, ack)
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoomKey(t *testing.T) {
+	for rid, want := range map[string][]byte{
+		"1":          {0, 0, 0, 1},
+		"-2":         {0xff, 0xff, 0xff, 0xfe},
+		"2147483647": {0x7f, 0xff, 0xff, 0xff},
+		"2147483648": []byte("2147483648"),
+		"01":         []byte("01"),
+		"live:1":     []byte("live:1"),
+	} {
+		got, err := roomKey(rid).Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("roomKey(%s) got %v, want %v", rid, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"goim/libs/perf"
+	"goim/libs/trace"
 	"infrastructure/loggingclient"
 	"runtime"
 
@@ -28,17 +29,34 @@ func main() {
 	defer log.Close()
 	log.Info("logic[%s] start", Ver)
 	perf.Init(Conf.PprofAddrs)
+	trace.Init(trace.Options{
+		Service:  "goim-logic",
+		Sample:   Conf.TraceSample,
+		URL:      Conf.TraceURL,
+		Batch:    Conf.TraceBatch,
+		Interval: Conf.TraceInterval,
+		Queue:    Conf.TraceQueue,
+	})
 	DefaultStat = NewStat()
+	// api keys
+	if err := InitAPIKeys(); err != nil {
+		panic(err)
+	}
 	// router rpc
 	if err := InitRouter(Conf.RouterRPCAddrs); err != nil {
 		panic(err)
 	}
+	// comet rpc, only used by the admin api
+	if err := InitComet(Conf.CometRPCAddrs); err != nil {
+		panic(err)
+	}
 	// start monitor
 	if Conf.MonitorOpen {
-		InitMonitor(Conf.MonitorAddrs)
+		InitMonitor(Conf.MonitorAddrs, Conf.MonitorAdmin)
 	}
 	MergeCount()
 	go SyncCount()
+	go SyncRooms()
 	// logic rpc
 	if err := InitRPC(NewGuluAuther()); err != nil {
 		panic(err)
@@ -49,6 +67,10 @@ func main() {
 	if err := InitKafka(Conf.KafkaAddrs); err != nil {
 		panic(err)
 	}
+	// scheduled pushes, after kafka for the expired ones
+	if err := InitScheduler(); err != nil {
+		panic(err)
+	}
 	// block until a signal is received.
 	InitSignal()
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"goim/libs/metrics"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	metricSuccess = "success"
+	metricError   = "error"
+)
+
+var (
+	pushCounter     = metrics.NewCounterVec("goim_logic_push_total", "accepted http pushes by type.", "type")
+	produceCounter  = metrics.NewCounterVec("goim_logic_kafka_produce_total", "kafka produced messages by result.", "result")
+	produceDuration = metrics.NewHistogramVec("goim_logic_kafka_produce_duration_seconds", "kafka produce latency until acked by result.", nil, "result")
+)
+
+func init() {
+	metrics.MustRegister(pushCounter, produceCounter, produceDuration)
+}
+
+// finishProduce observe the produce latency and finish the produce span by
+// the ack of the message, then call the ack of the producer.
+func finishProduce(pm *sarama.ProducerMessage, result string, err error) {
+	produceCounter.WithLabelValues(result).Inc()
+	if meta, ok := pm.Metadata.(*produceMeta); ok {
+		produceDuration.WithLabelValues(result).Observe(time.Since(meta.start).Seconds())
+		meta.span.Set("partition", pm.Partition)
+		meta.span.Set("offset", pm.Offset)
+		meta.span.Error(err)
+		meta.span.Finish()
+		if meta.ack != nil {
+			meta.ack(err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	log "github.com/thinkboy/log4go"
+	"goim/libs/metrics"
 	"net/http"
 )
 
@@ -11,11 +12,15 @@ type Monitor struct {
 }
 
 // StartPprof start http monitor.
-func InitMonitor(binds []string) {
+func InitMonitor(binds []string, admin bool) {
 	m := new(Monitor)
 	monitorServeMux := http.NewServeMux()
 	monitorServeMux.HandleFunc("/monitor/ping", m.Ping)
 	monitorServeMux.HandleFunc("/monitor/stat", m.Stat)
+	monitorServeMux.Handle("/metrics", metrics.Handler())
+	if admin {
+		InitAdmin(monitorServeMux)
+	}
 	for _, addr := range binds {
 		go func(bind string) {
 			log.Info("start monitor listen: \"%s\"", addr)
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"goim/libs/define"
+	"goim/libs/proto"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	roomIdMaxSize        = 128
+	topicMaxSize         = 128
+	roomUsersPageSize    = 20
+	roomUsersPageSizeMax = 1000
+)
+
+// RoomInfo is the room metadata with the online members.
+type RoomInfo struct {
+	RoomId  proto.RoomId `json:"room_id"`
+	Name    string       `json:"name"`
+	Owner   int64        `json:"owner"`
+	Max     int32        `json:"max"`
+	Created int64        `json:"created"`
+	Members int32        `json:"members"`
+}
+
+// parseRoomId check the room id, the room ids are strings, the int32 room
+// ids of the old clients are their decimal strings.
+func parseRoomId(s string) (roomId string, err error) {
+	if s == define.NoRoom || len(s) > roomIdMaxSize {
+		err = ErrRoomId
+		return
+	}
+	roomId = s
+	return
+}
+
+// RoomMeta get, set or delete the room metadata.
+// GET /1/room/meta?rid= get the room metadata and members.
+// POST /1/room/meta?rid=&name=&owner=&max= set the room metadata.
+// DELETE /1/room/meta?rid= delete the room metadata.
+func RoomMeta(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		roomId  string
+		meta    *proto.RoomMeta
+		members int32
+		query   = r.URL.Query()
+		res     = map[string]interface{}{"ret": OK}
+	)
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	defer retWrite(w, r, res, time.Now())
+	if roomId, err = parseRoomId(query.Get("rid")); err != nil {
+		log.Error("parseRoomId(\"%s\") error(%v)", query.Get("rid"), err)
+		res["ret"] = InternalErr
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if meta, members, err = roomMeta(roomId); err != nil {
+			res["ret"] = InternalErr
+			return
+		}
+		info := &RoomInfo{RoomId: proto.RoomId(roomId), Members: members}
+		if meta != nil {
+			info.Name, info.Owner, info.Max, info.Created = meta.Name, meta.Owner, meta.Max, meta.Created
+		}
+		res["data"] = info
+	case "POST":
+		meta = &proto.RoomMeta{RoomId: roomId, Name: query.Get("name"), Created: time.Now().Unix()}
+		if s := query.Get("owner"); s != "" {
+			if meta.Owner, err = strconv.ParseInt(s, 10, 64); err != nil {
+				log.Error("strconv.Atoi(\"%s\") error(%v)", s, err)
+				res["ret"] = InternalErr
+				return
+			}
+		}
+		if s := query.Get("max"); s != "" {
+			var max int64
+			if max, err = strconv.ParseInt(s, 10, 32); err != nil || max < 0 {
+				log.Error("strconv.Atoi(\"%s\") error(%v)", s, err)
+				res["ret"] = InternalErr
+				return
+			}
+			meta.Max = int32(max)
+		}
+		if err = setRoom(meta); err != nil {
+			res["ret"] = InternalErr
+		}
+	case "DELETE":
+		if err = delRoom(roomId); err != nil {
+			res["ret"] = InternalErr
+			return
+		}
+		delRoomHistory(roomId)
+	}
+}
+
+// RoomUsers get the online user ids of the room by page.
+// GET /1/room/users?rid=&pn=1&ps=20
+func RoomUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	var (
+		err     error
+		roomId  string
+		pn      = 1
+		ps      = roomUsersPageSize
+		userIds []int64
+		query   = r.URL.Query()
+		res     = map[string]interface{}{"ret": OK}
+	)
+	defer retWrite(w, r, res, time.Now())
+	if roomId, err = parseRoomId(query.Get("rid")); err != nil {
+		log.Error("parseRoomId(\"%s\") error(%v)", query.Get("rid"), err)
+		res["ret"] = InternalErr
+		return
+	}
+	if s := query.Get("pn"); s != "" {
+		if pn, err = strconv.Atoi(s); err != nil || pn < 1 {
+			log.Error("strconv.Atoi(\"%s\") error(%v)", s, err)
+			res["ret"] = InternalErr
+			return
+		}
+	}
+	if s := query.Get("ps"); s != "" {
+		if ps, err = strconv.Atoi(s); err != nil || ps < 1 {
+			log.Error("strconv.Atoi(\"%s\") error(%v)", s, err)
+			res["ret"] = InternalErr
+			return
+		}
+		if ps > roomUsersPageSizeMax {
+			ps = roomUsersPageSizeMax
+		}
+	}
+	if userIds, err = roomUsers(roomId); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	res["data"] = map[string]interface{}{"total": len(userIds), "user_ids": pageUserIds(userIds, pn, ps)}
+}
+
+// RoomHistory get the latest messages of the room from the oldest.
+// GET /1/room/history?rid=&n=&since=
+func RoomHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	var (
+		err    error
+		roomId string
+		n      int
+		since  int64
+		query  = r.URL.Query()
+		res    = map[string]interface{}{"ret": OK}
+	)
+	defer retWrite(w, r, res, time.Now())
+	if roomId, err = parseRoomId(query.Get("rid")); err != nil {
+		log.Error("parseRoomId(\"%s\") error(%v)", query.Get("rid"), err)
+		res["ret"] = InternalErr
+		return
+	}
+	if s := query.Get("n"); s != "" {
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			log.Error("strconv.Atoi(\"%s\") error(%v)", s, err)
+			res["ret"] = InternalErr
+			return
+		}
+	}
+	if s := query.Get("since"); s != "" {
+		if since, err = strconv.ParseInt(s, 10, 64); err != nil {
+			log.Error("strconv.ParseInt(\"%s\") error(%v)", s, err)
+			res["ret"] = InternalErr
+			return
+		}
+	}
+	var msgs []*proto.RoomHistoryMsg
+	if msgs, err = roomHistory(roomId, n, since); err != nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if msgs == nil {
+		msgs = []*proto.RoomHistoryMsg{}
+	}
+	res["data"] = msgs
+}
+
+// pageUserIds get the page of the user ids, pn starts from 1.
+func pageUserIds(userIds []int64, pn, ps int) []int64 {
+	start := (pn - 1) * ps
+	if start >= len(userIds) {
+		return []int64{}
+	}
+	end := start + ps
+	if end > len(userIds) {
+		end = len(userIds)
+	}
+	return userIds[start:end]
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"goim/libs/hash/ketama"
 	inet "goim/libs/net"
 	"goim/libs/net/xrpc"
 	"goim/libs/proto"
 	"strconv"
+	"time"
 
 	"strings"
 
@@ -29,12 +31,32 @@ const (
 	routerServiceMGet           = "RouterRPC.MGet"
 	routerServiceGetAll         = "RouterRPC.GetAll"
 	routerServiceCleanRoomCount = "RouterRPC.CleanRoomCount"
+	routerServiceStat           = "RouterRPC.Stat"
+	routerServiceSetRoom        = "RouterRPC.SetRoom"
+	routerServiceDelRoom        = "RouterRPC.DelRoom"
+	routerServiceRoom           = "RouterRPC.Room"
+	routerServiceRoomUsers      = "RouterRPC.RoomUsers"
+	routerServiceJoinRoom       = "RouterRPC.JoinRoom"
+	routerServiceLeaveRoom      = "RouterRPC.LeaveRoom"
+	routerServiceRoomMembers    = "RouterRPC.RoomMembers"
+	routerServiceSyncRooms      = "RouterRPC.SyncRooms"
+	routerServiceMPush          = "RouterRPC.MPush"
+	routerServiceOutbox         = "RouterRPC.Outbox"
+
+	// the room members are rebuilt every syncRoomDelay
+	syncRoomDelay = time.Minute
 )
 
 func InitRouter(addrs map[string]string) (err error) {
 	var (
 		network, addr string
+		auth          = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret)
+		tlsCfg        *tls.Config
 	)
+	if tlsCfg, err = auth.ClientTLS(); err != nil {
+		guluLogger.Errorf("rpc auth ClientTLS() error(%v)", err)
+		return
+	}
 	routerRing = ketama.NewRing(ketama.Base)
 	for serverId, bind := range addrs {
 		var rpcOptions []xrpc.ClientOptions
@@ -44,8 +66,10 @@ func InitRouter(addrs map[string]string) (err error) {
 				return
 			}
 			options := xrpc.ClientOptions{
-				Proto: network,
-				Addr:  addr,
+				Proto:  network,
+				Addr:   addr,
+				TLS:    tlsCfg,
+				Secret: auth.Secret,
 			}
 			rpcOptions = append(rpcOptions, options)
 		}
@@ -77,9 +101,15 @@ func getRouterNode(userID int64) string {
 	return routerRing.Hash(strconv.FormatInt(userID, 10))
 }
 
-func connect(userID int64, server, roomId int32) (seq int32, err error) {
+// getRouterByRoom get the owner router of the room, which counts the room
+// members.
+func getRouterByRoom(roomId string) (*xrpc.Clients, error) {
+	return getRouterByServer(routerRing.Hash(roomId))
+}
+
+func connect(userID int64, server int32, roomId string, platform string) (seq int32, kicks map[int32]int32, err error) {
 	var (
-		args   = proto.PutArg{UserId: userID, Server: server, RoomId: roomId}
+		args   = proto.PutArg{UserId: userID, Server: server, RoomId: roomId, Platform: platform}
 		reply  = proto.PutReply{}
 		client *xrpc.Clients
 	)
@@ -90,11 +120,12 @@ func connect(userID int64, server, roomId int32) (seq int32, err error) {
 		guluLogger.Errorf("c.Call(\"%s\",\"%v\") error(%v)", routerServicePut, args, err)
 	} else {
 		seq = reply.Seq
+		kicks = reply.Kicks
 	}
 	return
 }
 
-func disconnect(userID int64, seq, roomId int32) (has bool, err error) {
+func disconnect(userID int64, seq int32, roomId string) (has bool, err error) {
 	var (
 		args   = proto.DelArg{UserId: userID, Seq: seq, RoomId: roomId}
 		reply  = proto.DelReply{}
@@ -125,7 +156,7 @@ func delServer(server int32) (err error) {
 	return
 }
 
-func allRoomCount(client *xrpc.Clients) (counter map[int32]int32, err error) {
+func allRoomCount(client *xrpc.Clients) (counter map[string]int32, err error) {
 	var (
 		args  = proto.NoArg{}
 		reply = proto.AllRoomCountReply{}
@@ -141,7 +172,7 @@ func allRoomCount(client *xrpc.Clients) (counter map[int32]int32, err error) {
 //描述：清空房间人数
 //作者：徐明祥
 //日期：2017-01-08
-func cleanRoomCount(roomId int32) (err error) {
+func cleanRoomCount(roomId string) (err error) {
 	var (
 		args   = proto.CleanRoomCountArg{RoomId: roomId}
 		reply  = proto.CleanRoomCountReply{}
@@ -168,65 +199,191 @@ func allServerCount(client *xrpc.Clients) (counter map[int32]int32, err error) {
 	return
 }
 
-func genSubKey(userId int64) (res map[int32][]string) {
+func routerStat(client *xrpc.Clients) (reply *proto.RouterStatReply, err error) {
+	reply = new(proto.RouterStatReply)
+	if err = client.Call(routerServiceStat, &proto.NoArg{}, reply); err != nil {
+		log.Error("c.Call(\"%s\", nil) error(%v)", routerServiceStat, err)
+	}
+	return
+}
+
+// setRoom set the room metadata to all routers.
+func setRoom(meta *proto.RoomMeta) (err error) {
 	var (
-		err    error
-		i      int
-		ok     bool
-		key    string
-		keys   []string
-		args   = proto.GetArg{UserId: userId}
-		reply  = proto.GetReply{}
+		reply  = proto.NoReply{}
 		client *xrpc.Clients
 	)
-	res = make(map[int32][]string)
-	if client, err = getRouterByUID(userId); err != nil {
+	for _, client = range routerServiceMap {
+		if err = client.Call(routerServiceSetRoom, meta, &reply); err != nil {
+			log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceSetRoom, meta, err)
+			return
+		}
+	}
+	return
+}
+
+// delRoom delete the room metadata from all routers.
+func delRoom(roomId string) (err error) {
+	var (
+		args   = proto.RoomArg{RoomId: roomId}
+		reply  = proto.NoReply{}
+		client *xrpc.Clients
+	)
+	for _, client = range routerServiceMap {
+		if err = client.Call(routerServiceDelRoom, &args, &reply); err != nil {
+			log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceDelRoom, args, err)
+			return
+		}
+	}
+	return
+}
+
+// roomMeta get the room metadata and the members from the owner router.
+func roomMeta(roomId string) (meta *proto.RoomMeta, members int32, err error) {
+	var (
+		args   = proto.RoomArg{RoomId: roomId}
+		reply  = proto.RoomReply{}
+		client *xrpc.Clients
+	)
+	if client, err = getRouterByRoom(roomId); err != nil {
 		return
 	}
-	if err = client.Call(routerServiceGet, &args, &reply); err != nil {
-		log.Error("client.Call(\"%s\",\"%v\") error(%v)", routerServiceGet, args, err)
+	if err = client.Call(routerServiceRoom, &args, &reply); err != nil {
+		log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceRoom, args, err)
 		return
 	}
-	for i = 0; i < len(reply.Servers); i++ {
-		key = encode(userId, reply.Seqs[i])
-		if keys, ok = res[reply.Servers[i]]; !ok {
-			keys = []string{}
+	meta, members = reply.Meta, reply.Members
+	return
+}
+
+// joinRoom join a channel of the user on the comet to the room by the owner
+// router, full if the room is full.
+func joinRoom(userID int64, server int32, roomId string) (full bool, err error) {
+	var (
+		args   = proto.RoomJoinArg{RoomId: roomId, UserId: userID, Server: server}
+		reply  = proto.RoomJoinReply{}
+		client *xrpc.Clients
+	)
+	if client, err = getRouterByRoom(roomId); err != nil {
+		return
+	}
+	if err = client.Call(routerServiceJoinRoom, &args, &reply); err != nil {
+		log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceJoinRoom, args, err)
+		return
+	}
+	full = reply.Full
+	return
+}
+
+// leaveRoom remove a channel of the user on the comet from the room by the
+// owner router.
+func leaveRoom(userID int64, server int32, roomId string) (err error) {
+	var (
+		args   = proto.RoomJoinArg{RoomId: roomId, UserId: userID, Server: server}
+		reply  = proto.NoReply{}
+		client *xrpc.Clients
+	)
+	if client, err = getRouterByRoom(roomId); err != nil {
+		return
+	}
+	if err = client.Call(routerServiceLeaveRoom, &args, &reply); err != nil {
+		log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceLeaveRoom, args, err)
+	}
+	return
+}
+
+// syncRooms rebuild the room members of the owner routers by the sessions of
+// all routers, so the members survive the router restarts and the ring
+// changes. a join between the read and the reset is dropped until the next
+// sync.
+func syncRooms() (err error) {
+	var (
+		node   string
+		client *xrpc.Clients
+		owners = make(map[string][]*proto.RoomMember)
+	)
+	for _, client = range routerServiceMap {
+		reply := proto.RoomMembersReply{}
+		// a partial sync undercounts the rooms, skip it
+		if err = client.Call(routerServiceRoomMembers, &proto.NoArg{}, &reply); err != nil {
+			log.Error("c.Call(\"%s\") error(%v)", routerServiceRoomMembers, err)
+			return
+		}
+		for _, m := range reply.Members {
+			node = routerRing.Hash(m.RoomId)
+			owners[node] = append(owners[node], m)
+		}
+	}
+	for node, client = range routerServiceMap {
+		args := proto.RoomSyncArg{Members: owners[node]}
+		if err = client.Call(routerServiceSyncRooms, &args, &proto.NoReply{}); err != nil {
+			log.Error("c.Call(\"%s\") error(%v)", routerServiceSyncRooms, err)
+		}
+	}
+	return
+}
+
+// SyncRooms rebuild the room members every syncRoomDelay.
+func SyncRooms() {
+	for {
+		syncRooms()
+		time.Sleep(syncRoomDelay)
+	}
+}
+
+// roomUsers get the user ids in the room from all routers.
+func roomUsers(roomId string) (userIds []int64, err error) {
+	var (
+		args   = proto.RoomArg{RoomId: roomId}
+		client *xrpc.Clients
+	)
+	for _, client = range routerServiceMap {
+		reply := proto.RoomUsersReply{}
+		if err = client.Call(routerServiceRoomUsers, &args, &reply); err != nil {
+			log.Error("c.Call(\"%s\",\"%v\") error(%v)", routerServiceRoomUsers, args, err)
+			return
 		}
-		keys = append(keys, key)
-		res[reply.Servers[i]] = keys
+		userIds = append(userIds, reply.UserIds...)
 	}
 	return
 }
 
-func getSubKeys(res chan *proto.MGetReply, serverId string, userIds []int64) {
+func mpushRouter(res chan *proto.MPushReply, serverId string, userIds []int64, msg []byte, expire int64) {
 	var (
-		args  = proto.MGetArg{UserIds: userIds}
-		reply = proto.MGetReply{}
+		args  = proto.MPushArg{UserIds: userIds, Msg: msg, Expire: expire}
+		reply = proto.MPushReply{}
 	)
-	if client, err := getRouterByServer(serverId); err == nil {
-		if err = client.Call(routerServiceMGet, &args, &reply); err != nil {
-			log.Error("client.Call(\"%s\",\"%v\") error(%v)", routerServiceMGet, args, err)
-			res <- nil
+	client, err := getRouterByServer(serverId)
+	if err == nil {
+		if err = client.Call(routerServiceMPush, &args, &reply); err != nil {
+			log.Error("client.Call(\"%s\",\"%v\") error(%v)", routerServiceMPush, args, err)
 		}
 	}
+	if err != nil {
+		res <- nil
+		return
+	}
 	res <- &reply
 }
 
-func genSubKeys(userIds []int64) (divide map[int32][]string) {
+// genSubKeys allocate the message seqs of the users and keep the message
+// in their outboxes by the routers, the sub keys and their message seqs are
+// divided by the comet server.
+func genSubKeys(userIds []int64, msg []byte, expire int64) (divide map[int32][]string, seqs map[int32][]int32) {
 	var (
-		i, j, k      int
-		node, subkey string
-		subkeys      []string
-		server       int32
-		session      *proto.GetReply
-		reply        *proto.MGetReply
-		uid          int64
-		ids          []int64
-		ok           bool
-		m            = make(map[string][]int64)
-		res          = make(chan *proto.MGetReply, 1)
+		i, j, k int
+		node    string
+		server  int32
+		session *proto.GetReply
+		reply   *proto.MPushReply
+		uid     int64
+		ids     []int64
+		ok      bool
+		m       = make(map[string][]int64)
+		res     = make(chan *proto.MPushReply, 1)
 	)
 	divide = make(map[int32][]string) //map[comet.serverId][]subkey
+	seqs = make(map[int32][]int32)
 	for i = 0; i < len(userIds); i++ {
 		node = getRouterNode(userIds[i])
 		if ids, ok = m[node]; !ok {
@@ -236,7 +393,7 @@ func genSubKeys(userIds []int64) (divide map[int32][]string) {
 		m[node] = ids
 	}
 	for node, ids = range m {
-		go getSubKeys(res, node, ids)
+		go mpushRouter(res, node, ids, msg, expire)
 	}
 	k = len(m)
 	for k > 0 {
@@ -248,16 +405,29 @@ func genSubKeys(userIds []int64) (divide map[int32][]string) {
 			session = reply.Sessions[j]
 			uid = reply.UserIds[j]
 			for i = 0; i < len(session.Seqs); i++ {
-				subkey = encode(uid, session.Seqs[i])
 				server = session.Servers[i]
-				if subkeys, ok = divide[server]; !ok {
-					subkeys = []string{subkey}
-				} else {
-					subkeys = append(subkeys, subkey)
-				}
-				divide[server] = subkeys
+				divide[server] = append(divide[server], encode(uid, session.Seqs[i]))
+				seqs[server] = append(seqs[server], reply.MsgSeqs[j])
 			}
 		}
 	}
 	return
 }
+
+// outbox get the kept messages of the user after the message seq.
+func outbox(userId int64, since int32) (msgs []*proto.OutboxMsg, err error) {
+	var (
+		args   = proto.OutboxArg{UserId: userId, Since: since}
+		reply  = proto.OutboxReply{}
+		client *xrpc.Clients
+	)
+	if client, err = getRouterByUID(userId); err != nil {
+		return
+	}
+	if err = client.Call(routerServiceOutbox, &args, &reply); err != nil {
+		log.Error("client.Call(\"%s\",\"%v\") error(%v)", routerServiceOutbox, args, err)
+		return
+	}
+	msgs = reply.Msgs
+	return
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"goim/libs/define"
 	inet "goim/libs/net"
+	"goim/libs/net/xrpc"
 	"goim/libs/proto"
 	"net"
 	"net/rpc"
@@ -10,8 +12,13 @@ import (
 func InitRPC(auther Auther) (err error) {
 	var (
 		network, addr string
+		options       xrpc.ServerOptions
 		c             = &RPC{auther: auther}
 	)
+	if options, err = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret).ServerOptions(); err != nil {
+		guluLogger.Errorf("rpc auth ServerOptions() error(%v)", err)
+		return
+	}
 	rpc.Register(c)
 	for i := 0; i < len(Conf.RPCAddrs); i++ {
 		guluLogger.Infof("start listen rpc addr: \"%s\"", Conf.RPCAddrs[i])
@@ -19,12 +26,12 @@ func InitRPC(auther Auther) (err error) {
 			guluLogger.Errorf("inet.ParseNetwork() error(%v)", err)
 			return
 		}
-		go rpcListen(network, addr)
+		go rpcListen(network, addr, options)
 	}
 	return
 }
 
-func rpcListen(network, addr string) {
+func rpcListen(network, addr string, options xrpc.ServerOptions) {
 	l, err := net.Listen(network, addr)
 	if err != nil {
 		guluLogger.Errorf("net.Listen(\"%s\", \"%s\") error(%v)", network, addr, err)
@@ -37,7 +44,7 @@ func rpcListen(network, addr string) {
 			guluLogger.Errorf("listener.Close() error(%v)", err)
 		}
 	}()
-	rpc.Accept(l)
+	xrpc.Accept(l, options)
 }
 
 // RPC
@@ -57,13 +64,68 @@ func (r *RPC) Connect(arg *proto.ConnArg, reply *proto.ConnReply) (err error) {
 		return
 	}
 	var (
-		uid int64
-		seq int32
+		uid   int64
+		seq   int32
+		kicks map[int32]int32
 	)
-	uid, reply.RoomId = r.auther.Auth(arg.Token)
-	if seq, err = connect(uid, arg.Server, reply.RoomId); err == nil {
+	uid, reply.RoomId, reply.Attrs = r.auther.Auth(arg.Token)
+	// a full room refuses the handshake, comet replies OP_ROOM_FULL_REPLY
+	if reply.RoomId != define.NoRoom {
+		if reply.RoomFull, err = joinRoom(uid, arg.Server, reply.RoomId); err != nil || reply.RoomFull {
+			if reply.RoomFull {
+				guluLogger.Infof("uid: %d room: %s is full, refused", uid, reply.RoomId)
+			}
+			return
+		}
+	}
+	if seq, kicks, err = connect(uid, arg.Server, reply.RoomId, reply.Attrs["platform"]); err == nil {
 		reply.Key = encode(uid, seq)
+		kick(uid, kicks)
+	} else if reply.RoomId != define.NoRoom {
+		leaveRoom(uid, arg.Server, reply.RoomId)
+	}
+	return
+}
+
+// kick send OP_DISCONNECT_REPLY to the sessions evicted by the router
+// session policy, the comets close them after the reply.
+func kick(uid int64, kicks map[int32]int32) {
+	var servers = make(map[int32][]string)
+	for seq, server := range kicks {
+		servers[server] = append(servers[server], encode(uid, seq))
+	}
+	for server, keys := range servers {
+		if err := kickKafka(server, keys); err != nil {
+			guluLogger.Errorf("kickKafka(%d, %v) error(%v)", server, keys, err)
+		}
+	}
+}
+
+// RoomHistory get the latest messages of the room for the late joiners.
+func (r *RPC) RoomHistory(arg *proto.RoomHistoryArg, reply *proto.RoomHistoryReply) (err error) {
+	if arg == nil {
+		err = ErrHistoryArgs
+		guluLogger.Errorf("RoomHistory() error(%v)", err)
+		return
+	}
+	reply.Msgs, err = roomHistory(arg.RoomId, arg.N, arg.Since)
+	return
+}
+
+// Resend get the messages of the key's user after the message seq, the
+// clients ask it after a gap of the seqs.
+func (r *RPC) Resend(arg *proto.ResendArg, reply *proto.ResendReply) (err error) {
+	if arg == nil {
+		err = ErrResendArgs
+		guluLogger.Errorf("Resend() error(%v)", err)
+		return
 	}
+	var uid int64
+	if uid, _, err = decode(arg.Key); err != nil {
+		guluLogger.Errorf("decode(\"%s\") error(%s)", arg.Key, err)
+		return
+	}
+	reply.Msgs, err = outbox(uid, arg.Since)
 	return
 }
 
@@ -83,5 +145,8 @@ func (r *RPC) Disconnect(arg *proto.DisconnArg, reply *proto.DisconnReply) (err
 		return
 	}
 	reply.Has, err = disconnect(uid, seq, arg.RoomId)
+	if arg.RoomId != define.NoRoom {
+		leaveRoom(uid, arg.Server, arg.RoomId)
+	}
 	return
 }
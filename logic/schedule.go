@@ -0,0 +1,487 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	itime "goim/libs/time"
+	"goim/libs/trace"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	scheduleTimerSize   = 1024
+	scheduleSendSize    = 1024
+	schedulePeerTimeout = 2 * time.Second
+	// a schedule failed to send is fired again after it
+	scheduleRetry = 10 * time.Second
+)
+
+var (
+	DefaultScheduler   *Scheduler
+	schedulePeerClient = &http.Client{Timeout: schedulePeerTimeout}
+)
+
+// Schedule is a pending push, the targets are resolved when fired.
+type Schedule struct {
+	Id      string  `json:"id"`
+	Type    string  `json:"type"` // api type: single, multi, room, topic, broadcast
+	UserIds []int64 `json:"user_ids,omitempty"`
+	RoomId  string  `json:"room_id,omitempty"`
+	Topic   string  `json:"topic,omitempty"`
+	Ensure  bool    `json:"ensure,omitempty"`
+	Filter  string  `json:"filter,omitempty"`
+	Msg     []byte  `json:"msg"`
+	SendAt  int64   `json:"send_at"` // unix seconds
+	Created int64   `json:"created"` // unix seconds
+	TraceId string  `json:"trace,omitempty"`
+	Expire  int64   `json:"expire,omitempty"` // unix milliseconds, 0 never
+}
+
+// send push the schedule by kafka, done is called once after all the
+// messages are acked, by the first error.
+func (sc *Schedule) send(done func(error)) {
+	var (
+		err      error
+		serverId int32
+		keys     []string
+		subKeys  map[int32][]string
+		msgSeqs  map[int32][]int32
+		acks     = &scheduleAcks{done: done}
+		span     = trace.Start(sc.TraceId, "logic.schedule.send")
+	)
+	defer span.Finish()
+	span.Set("schedule", sc.Id)
+	switch sc.Type {
+	case apiSingle, apiMulti:
+		subKeys, msgSeqs = genSubKeys(sc.UserIds, sc.Msg, sc.Expire)
+		for serverId, keys = range subKeys {
+			if err = mpushKafka(serverId, keys, msgSeqs[serverId], sc.Msg, sc.Expire, span, acks.add()); err != nil {
+				break
+			}
+		}
+	case apiRoom:
+		err = broadcastRoomKafka(sc.RoomId, sc.Msg, sc.Ensure, sc.Expire, span, acks.add())
+	case apiTopic:
+		err = broadcastTopicKafka(sc.Topic, sc.Msg, sc.Expire, span, acks.add())
+	case apiBroadcast:
+		err = broadcastKafka(sc.Msg, sc.Filter, sc.Expire, span, acks.add())
+	default:
+		err = ErrScheduleType
+	}
+	span.Error(err)
+	acks.seal(err)
+}
+
+// scheduleAcks wait the producer acks of the messages of a schedule.
+type scheduleAcks struct {
+	lock    sync.Mutex
+	pending int
+	sealed  bool // no more messages
+	err     error
+	done    func(error)
+}
+
+// add add a message, the returned func is its ack.
+func (a *scheduleAcks) add() func(error) {
+	a.lock.Lock()
+	a.pending++
+	a.lock.Unlock()
+	return a.ack
+}
+
+func (a *scheduleAcks) ack(err error) {
+	a.lock.Lock()
+	a.pending--
+	a.finish(err)
+}
+
+// seal mark all the messages added, err is the error of the send.
+func (a *scheduleAcks) seal(err error) {
+	a.lock.Lock()
+	a.sealed = true
+	a.finish(err)
+}
+
+// finish call done if all acked, under the lock and unlock it.
+func (a *scheduleAcks) finish(err error) {
+	var done func(error)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	if a.sealed && a.pending == 0 {
+		done, a.done = a.done, nil
+	}
+	a.lock.Unlock()
+	if done != nil {
+		done(a.err)
+	}
+}
+
+type scheduleTimer struct {
+	sc     *Schedule
+	td     *itime.TimerData
+	firing bool // handed to the send workers
+}
+
+// Scheduler hold the pending pushes in the timer heap, the schedules are
+// journaled so they survive restarts. the due schedules are sent by the
+// workers, so a slow send never delays the timer.
+type Scheduler struct {
+	lock      sync.Mutex
+	timer     *itime.Timer
+	store     *ScheduleStore
+	max       int
+	schedules map[string]*scheduleTimer
+	sends     chan *scheduleTimer
+}
+
+// InitScheduler init the global scheduler, the expired schedules of the
+// journal are sent at once.
+func InitScheduler() (err error) {
+	DefaultScheduler, err = NewScheduler(Conf.ScheduleFile, Conf.ScheduleMax, Conf.ScheduleWorkers, Conf.ScheduleCompact)
+	return
+}
+
+// NewScheduler new a scheduler and load the journal, the journal is
+// compacted every compact.
+func NewScheduler(file string, max, workers int, compact time.Duration) (s *Scheduler, err error) {
+	var schedules map[string]*Schedule
+	s = &Scheduler{
+		timer:     itime.NewTimer(scheduleTimerSize),
+		max:       max,
+		schedules: make(map[string]*scheduleTimer),
+		sends:     make(chan *scheduleTimer, scheduleSendSize),
+	}
+	if s.store, schedules, err = NewScheduleStore(file); err != nil {
+		log.Error("NewScheduleStore(\"%s\") error(%v)", file, err)
+		return
+	}
+	for i := 0; i < workers; i++ {
+		go s.sendproc()
+	}
+	if compact > 0 {
+		go s.compactproc(compact)
+	}
+	s.lock.Lock()
+	for _, sc := range schedules {
+		s.add(sc)
+	}
+	s.lock.Unlock()
+	log.Info("schedule load %d pending pushes from \"%s\"", len(schedules), file)
+	return
+}
+
+func (s *Scheduler) add(sc *Schedule) {
+	id := sc.Id
+	t := &scheduleTimer{sc: sc}
+	t.td = s.timer.AddKey(id, time.Unix(sc.SendAt, 0).Sub(time.Now()), func() {
+		s.fire(id)
+	})
+	s.schedules[id] = t
+}
+
+// Add add a schedule, the id is generated.
+func (s *Scheduler) Add(sc *Schedule) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.max > 0 && len(s.schedules) >= s.max {
+		return ErrScheduleFull
+	}
+	if sc.Id, err = newScheduleId(); err != nil {
+		return
+	}
+	sc.Created = time.Now().Unix()
+	if err = s.store.Add(sc); err != nil {
+		log.Error("schedule store.Add(%s) error(%v)", sc.Id, err)
+		return
+	}
+	s.add(sc)
+	return
+}
+
+// Cancel cancel a pending schedule, false if not exists or fired.
+func (s *Scheduler) Cancel(id string) (ok bool, err error) {
+	var t *scheduleTimer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if t, ok = s.schedules[id]; !ok || t.firing {
+		// being sent
+		ok = false
+		return
+	}
+	if err = s.store.Del(id); err != nil {
+		log.Error("schedule store.Del(%s) error(%v)", id, err)
+		return
+	}
+	delete(s.schedules, id)
+	s.timer.Del(t.td)
+	return
+}
+
+// List get the pending schedules by the send time.
+func (s *Scheduler) List() (schedules []*Schedule) {
+	s.lock.Lock()
+	schedules = make([]*Schedule, 0, len(s.schedules))
+	for _, t := range s.schedules {
+		if !t.firing {
+			schedules = append(schedules, t.sc)
+		}
+	}
+	s.lock.Unlock()
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].SendAt < schedules[j].SendAt })
+	return
+}
+
+// fire hand the due schedule to the send workers, called by the timer, a
+// new goroutine sends it if the workers are busy.
+func (s *Scheduler) fire(id string) {
+	var (
+		t  *scheduleTimer
+		ok bool
+	)
+	s.lock.Lock()
+	if t, ok = s.schedules[id]; !ok || t.firing {
+		s.lock.Unlock()
+		return
+	}
+	t.firing = true
+	s.lock.Unlock()
+	select {
+	case s.sends <- t:
+	default:
+		go s.send(t)
+	}
+}
+
+func (s *Scheduler) sendproc() {
+	for t := range s.sends {
+		s.send(t)
+	}
+}
+
+// send send the schedule and wait the producer acks.
+func (s *Scheduler) send(t *scheduleTimer) {
+	acked := make(chan error, 1)
+	t.sc.send(func(err error) { acked <- err })
+	s.sent(t, <-acked)
+}
+
+// sent journal the schedule as deleted after acked by kafka, so a crash may
+// send it again but never lose it. a failed one is fired again after
+// scheduleRetry.
+func (s *Scheduler) sent(t *scheduleTimer, err error) {
+	var id = t.sc.Id
+	s.lock.Lock()
+	if err != nil {
+		log.Error("schedule %s send error(%v), retry after %v", id, err, scheduleRetry)
+		t.firing = false
+		// the expired timer data is not put back, add it again
+		s.timer.Set(t.td, scheduleRetry)
+		s.lock.Unlock()
+		return
+	}
+	if err = s.store.Del(id); err != nil {
+		log.Error("schedule store.Del(%s) error(%v)", id, err)
+	}
+	delete(s.schedules, id)
+	s.lock.Unlock()
+	// put back the timer data
+	s.timer.Del(t.td)
+}
+
+// compactproc compact the journal with the pending schedules, the ones being
+// sent are kept until journaled as deleted.
+func (s *Scheduler) compactproc(d time.Duration) {
+	for {
+		time.Sleep(d)
+		s.lock.Lock()
+		if s.store.Garbage() > 0 {
+			schedules := make(map[string]*Schedule, len(s.schedules))
+			for id, t := range s.schedules {
+				schedules[id] = t.sc
+			}
+			if err := s.store.Compact(schedules); err != nil {
+				log.Error("schedule store.Compact() error(%v)", err)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+func newScheduleId() (id string, err error) {
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	id = hex.EncodeToString(b)
+	return
+}
+
+// parseSendAt get the send time by send_at(unix seconds) or delay(seconds),
+// zero if send now.
+func parseSendAt(query url.Values) (sendAt time.Time, err error) {
+	var (
+		n   int64
+		now = time.Now()
+	)
+	if s := query.Get("send_at"); s != "" {
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return
+		}
+		sendAt = time.Unix(n, 0)
+	} else if s = query.Get("delay"); s != "" {
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil || n < 0 {
+			err = ErrScheduleTime
+			return
+		}
+		sendAt = now.Add(time.Duration(n) * time.Second)
+	}
+	if !sendAt.After(now) {
+		sendAt = time.Time{}
+		return
+	}
+	if Conf.ScheduleDelayMax > 0 && sendAt.Sub(now) > Conf.ScheduleDelayMax {
+		err = ErrScheduleTime
+	}
+	return
+}
+
+// schedulePush add a schedule instead of the push, the id is returned.
+func schedulePush(res map[string]interface{}, sc *Schedule, sendAt time.Time, span *trace.Span) {
+	var err error
+	if DefaultScheduler == nil {
+		err = ErrScheduler
+	} else {
+		sc.SendAt = sendAt.Unix()
+		sc.TraceId = span.TraceId()
+		err = DefaultScheduler.Add(sc)
+	}
+	if err != nil {
+		span.Error(err)
+		log.Error("schedule add(%s) error(%v)", sc.Type, err)
+		res["ret"] = InternalErr
+		return
+	}
+	span.Set("schedule", sc.Id)
+	res["data"] = map[string]interface{}{"id": sc.Id, "send_at": sc.SendAt}
+}
+
+// schedulePeer call the /1/schedules of a peer logic with local=1 and the
+// api key of the request, data is the result data if not nil.
+func schedulePeer(r *http.Request, addr string, data interface{}) (err error) {
+	var (
+		req   *http.Request
+		resp  *http.Response
+		query = r.URL.Query()
+		res   struct {
+			Ret  int             `json:"ret"`
+			Data json.RawMessage `json:"data"`
+		}
+	)
+	query.Set("local", "1")
+	if req, err = http.NewRequest(r.Method, "http://"+addr+"/1/schedules?"+query.Encode(), nil); err != nil {
+		return
+	}
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	if resp, err = schedulePeerClient.Do(req); err != nil {
+		log.Error("schedule peer %s %s error(%v)", r.Method, addr, err)
+		return
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Error("schedule peer %s json.Decode() error(%v)", addr, err)
+		return
+	}
+	if res.Ret != OK {
+		return ErrSchedulePeer
+	}
+	if data != nil {
+		err = json.Unmarshal(res.Data, data)
+	}
+	return
+}
+
+// peerSchedules list the pending pushes of the peer logics.
+func peerSchedules(r *http.Request) (schedules []*Schedule) {
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	for _, addr := range Conf.SchedulePeers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var scs []*Schedule
+			if err := schedulePeer(r, addr, &scs); err != nil {
+				return
+			}
+			lock.Lock()
+			schedules = append(schedules, scs...)
+			lock.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return
+}
+
+// cancelPeerSchedule cancel the pending push on the peer logics, false if
+// none has it.
+func cancelPeerSchedule(r *http.Request) (ok bool) {
+	for _, addr := range Conf.SchedulePeers {
+		if schedulePeer(r, addr, nil) == nil {
+			return true
+		}
+	}
+	return
+}
+
+// Schedules list or cancel the pending pushes of this logic and the peers,
+// only this logic if local=1.
+// GET /1/schedules list the pending pushes.
+// DELETE /1/schedules?id= cancel a pending push.
+func Schedules(w http.ResponseWriter, r *http.Request) {
+	var (
+		ok    bool
+		err   error
+		local = r.URL.Query().Get("local") == "1"
+		res   = map[string]interface{}{"ret": OK}
+	)
+	if r.Method != "GET" && r.Method != "DELETE" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	defer retWrite(w, r, res, time.Now())
+	if DefaultScheduler == nil {
+		res["ret"] = InternalErr
+		return
+	}
+	if r.Method == "GET" {
+		schedules := DefaultScheduler.List()
+		if !local && len(Conf.SchedulePeers) > 0 {
+			schedules = append(schedules, peerSchedules(r)...)
+			sort.Slice(schedules, func(i, j int) bool { return schedules[i].SendAt < schedules[j].SendAt })
+		}
+		res["data"] = schedules
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if ok, err = DefaultScheduler.Cancel(id); err == nil && !ok && !local {
+		ok = cancelPeerSchedule(r)
+	}
+	if err != nil {
+		res["ret"] = InternalErr
+	} else if !ok {
+		log.Warn("schedule %s not exists", id)
+		res["ret"] = InternalErr
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	log "github.com/thinkboy/log4go"
+)
+
+const (
+	scheduleOpAdd = "add"
+	scheduleOpDel = "del"
+)
+
+// scheduleRecord is a line of the schedule journal.
+type scheduleRecord struct {
+	Op       string    `json:"op"`
+	Id       string    `json:"id,omitempty"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// ScheduleStore is the durable journal of the pending schedules, one json
+// record per line, compacted at load and by Compact.
+type ScheduleStore struct {
+	file    string
+	f       *os.File
+	garbage int // the deleted records since compacted
+}
+
+// NewScheduleStore open the journal, replay and compact it, the pending
+// schedules are returned.
+func NewScheduleStore(file string) (s *ScheduleStore, schedules map[string]*Schedule, err error) {
+	s = &ScheduleStore{file: file}
+	if schedules, err = s.load(); err != nil {
+		return
+	}
+	s.f, err = s.compact(schedules)
+	return
+}
+
+func (s *ScheduleStore) load() (schedules map[string]*Schedule, err error) {
+	var (
+		f    *os.File
+		line []byte
+		rd   *bufio.Reader
+	)
+	schedules = make(map[string]*Schedule)
+	if f, err = os.Open(s.file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	rd = bufio.NewReader(f)
+	for {
+		if line, err = rd.ReadBytes('\n'); err != nil && err != io.EOF {
+			return
+		}
+		if len(line) > 0 {
+			r := new(scheduleRecord)
+			if jerr := json.Unmarshal(line, r); jerr != nil {
+				// a partial line of a crash
+				log.Error("schedule store %s json.Unmarshal(\"%s\") error(%v)", s.file, line, jerr)
+			} else if r.Op == scheduleOpAdd && r.Schedule != nil {
+				schedules[r.Schedule.Id] = r.Schedule
+			} else if r.Op == scheduleOpDel {
+				delete(schedules, r.Id)
+			}
+		}
+		if err == io.EOF {
+			err = nil
+			return
+		}
+	}
+}
+
+// compact rewrite the journal with the pending schedules only, the new
+// journal is returned opened to append.
+func (s *ScheduleStore) compact(schedules map[string]*Schedule) (f *os.File, err error) {
+	var (
+		tmp = s.file + ".tmp"
+		w   *bufio.Writer
+	)
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+	w = bufio.NewWriter(f)
+	for _, sc := range schedules {
+		if err = s.write(w, &scheduleRecord{Op: scheduleOpAdd, Schedule: sc}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.file)
+	}
+	if err != nil {
+		f.Close()
+		f = nil
+	}
+	return
+}
+
+func (s *ScheduleStore) write(w io.Writer, r *scheduleRecord) (err error) {
+	var b []byte
+	if b, err = json.Marshal(r); err != nil {
+		return
+	}
+	_, err = w.Write(append(b, '\n'))
+	return
+}
+
+// append write a record and sync.
+func (s *ScheduleStore) append(r *scheduleRecord) (err error) {
+	if err = s.write(s.f, r); err != nil {
+		return
+	}
+	return s.f.Sync()
+}
+
+// Add journal a new schedule.
+func (s *ScheduleStore) Add(sc *Schedule) error {
+	return s.append(&scheduleRecord{Op: scheduleOpAdd, Schedule: sc})
+}
+
+// Del journal a fired or canceled schedule.
+func (s *ScheduleStore) Del(id string) (err error) {
+	if err = s.append(&scheduleRecord{Op: scheduleOpDel, Id: id}); err == nil {
+		s.garbage++
+	}
+	return
+}
+
+// Garbage get the deleted records since compacted.
+func (s *ScheduleStore) Garbage() int {
+	return s.garbage
+}
+
+// Compact rewrite the journal with the pending schedules, the old journal
+// is kept if failed.
+func (s *ScheduleStore) Compact(schedules map[string]*Schedule) (err error) {
+	var f *os.File
+	if f, err = s.compact(schedules); err != nil {
+		return
+	}
+	s.f.Close()
+	s.f = f
+	s.garbage = 0
+	return
+}
+
+// Close close the journal.
+func (s *ScheduleStore) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSchedule(id string, sendAt int64) *Schedule {
+	return &Schedule{Id: id, Type: apiRoom, RoomId: "1", Msg: []byte(`{"test":1}`), SendAt: sendAt}
+}
+
+func TestScheduleStoreReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.log")
+	s, schedules, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 0 {
+		t.Fatalf("schedules got %d, want 0", len(schedules))
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err = s.Add(newTestSchedule(id, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Del("b"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if s, schedules, err = NewScheduleStore(file); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if len(schedules) != 2 || schedules["a"] == nil || schedules["c"] == nil {
+		t.Fatalf("schedules got %v, want a and c", schedules)
+	}
+	if string(schedules["a"].Msg) != `{"test":1}` {
+		t.Errorf("msg got %s", schedules["a"].Msg)
+	}
+	// compacted at load
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("journal lines got %d, want 2", n)
+	}
+}
+
+func TestScheduleStoreTruncated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.log")
+	s, _, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(newTestSchedule("a", 1))
+	s.Add(newTestSchedule("b", 1))
+	s.Close()
+	// a crash in the middle of the last line
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"del","id":"a`)
+	f.Close()
+	s, schedules, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 {
+		t.Fatalf("schedules got %d, want 2", len(schedules))
+	}
+	// the partial line is dropped by the compact, the next record is whole
+	s.Del("a")
+	s.Close()
+	if _, schedules, err = NewScheduleStore(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules["b"] == nil {
+		t.Errorf("schedules got %v, want b", schedules)
+	}
+}
+
+func TestScheduleStoreCompact(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.log")
+	s, _, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := newTestSchedule("a", 1), newTestSchedule("b", 1)
+	s.Add(a)
+	s.Add(b)
+	s.Del("a")
+	if s.Garbage() != 1 {
+		t.Fatalf("garbage got %d, want 1", s.Garbage())
+	}
+	if err = s.Compact(map[string]*Schedule{"b": b}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Garbage() != 0 {
+		t.Errorf("garbage got %d, want 0", s.Garbage())
+	}
+	// appended to the compacted journal
+	s.Add(newTestSchedule("c", 1))
+	s.Close()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("journal lines got %d, want 2", n)
+	}
+	_, schedules, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 || schedules["b"] == nil || schedules["c"] == nil {
+		t.Errorf("schedules got %v, want b and c", schedules)
+	}
+}
+
+func TestSchedulerCancelList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.log")
+	s, err := NewScheduler(file, 3, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	for _, d := range []int64{300, 100, 200} {
+		if err = s.Add(newTestSchedule("", now+d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Add(newTestSchedule("", now+400)); err != ErrScheduleFull {
+		t.Errorf("Add() got %v, want %v", err, ErrScheduleFull)
+	}
+	schedules := s.List()
+	if len(schedules) != 3 {
+		t.Fatalf("List() got %d, want 3", len(schedules))
+	}
+	for i, d := range []int64{100, 200, 300} {
+		if schedules[i].SendAt != now+d {
+			t.Errorf("List()[%d] send_at got %d, want %d", i, schedules[i].SendAt, now+d)
+		}
+	}
+	id := schedules[0].Id
+	if ok, err := s.Cancel(id); err != nil || !ok {
+		t.Fatalf("Cancel(%s) got %t %v", id, ok, err)
+	}
+	if ok, _ := s.Cancel(id); ok {
+		t.Errorf("Cancel(%s) again got ok", id)
+	}
+	if n := len(s.List()); n != 2 {
+		t.Errorf("List() got %d, want 2", n)
+	}
+	// the firing schedule can't be canceled nor listed
+	s.lock.Lock()
+	s.schedules[schedules[1].Id].firing = true
+	s.lock.Unlock()
+	if ok, _ := s.Cancel(schedules[1].Id); ok {
+		t.Errorf("Cancel(%s) firing got ok", schedules[1].Id)
+	}
+	if n := len(s.List()); n != 1 {
+		t.Errorf("List() got %d, want 1", n)
+	}
+	s.store.Close()
+	// the canceled one is journaled
+	_, loaded, err := NewScheduleStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[id] != nil {
+		t.Errorf("journal got %v, want 2 without %s", loaded, id)
+	}
+}
+
+func TestSchedulerSent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.log")
+	s, err := NewScheduler(file, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Add(newTestSchedule("", time.Now().Unix()+100)); err != nil {
+		t.Fatal(err)
+	}
+	id := s.List()[0].Id
+	s.lock.Lock()
+	st := s.schedules[id]
+	st.firing = true
+	s.lock.Unlock()
+	// failed, kept and armed again
+	s.sent(st, errors.New("kafka down"))
+	if schedules := s.List(); len(schedules) != 1 || schedules[0].Id != id {
+		t.Fatalf("List() got %v, want %s", schedules, id)
+	}
+	if d := st.td.Delay(); d <= 0 || d > scheduleRetry {
+		t.Errorf("retry delay got %v", d)
+	}
+	st.firing = true
+	s.sent(st, nil)
+	if n := len(s.List()); n != 0 {
+		t.Errorf("List() got %d, want 0", n)
+	}
+	if s.store.Garbage() != 1 {
+		t.Errorf("garbage got %d, want 1", s.store.Garbage())
+	}
+}
+
+func TestScheduleAcks(t *testing.T) {
+	var (
+		called int
+		got    error
+		kafka  = errors.New("kafka")
+		acks   = &scheduleAcks{done: func(err error) { called++; got = err }}
+	)
+	a1, a2 := acks.add(), acks.add()
+	a1(nil)
+	acks.seal(nil)
+	if called != 0 {
+		t.Fatal("done called before all acked")
+	}
+	a2(kafka)
+	if called != 1 || got != kafka {
+		t.Errorf("done got %d %v, want 1 %v", called, got, kafka)
+	}
+	// nothing to send
+	called = 0
+	acks = &scheduleAcks{done: func(err error) { called++; got = err }}
+	acks.seal(nil)
+	if called != 1 || got != nil {
+		t.Errorf("done got %d %v, want 1 nil", called, got)
+	}
+}
@@ -32,5 +32,9 @@ func reload() {
 		log.Error("ReloadConfig() error(%v)", err)
 		return
 	}
+	if err = DefaultAPIKeys.Load(newConf.APIKeys); err != nil {
+		log.Error("DefaultAPIKeys.Load() error(%v)", err)
+		return
+	}
 	Conf = newConf
 }
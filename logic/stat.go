@@ -15,6 +15,9 @@ type Stat struct {
 	SpeedMsgSecond uint64 `json:"speed_msg_second"`
 	// nodes
 	RouterNodes map[string]string `json:"router_nodes"`
+	// api keys
+	APIUnauthorized uint64                          `json:"api_unauthorized"`
+	APIUsages       map[string]map[string]*APIUsage `json:"api_usages"`
 }
 
 func NewStat() *Stat {
@@ -25,6 +28,7 @@ func NewStat() *Stat {
 
 func (s *Stat) Info() *Stat {
 	s.RouterNodes = Conf.RouterRPCAddrs
+	s.APIUnauthorized, s.APIUsages = DefaultAPIKeys.Usages()
 	return s
 }
 
@@ -32,6 +36,7 @@ func (s *Stat) Reset() {
 	atomic.StoreUint64(&s.MsgSucceeded, 0)
 	atomic.StoreUint64(&s.MsgFailed, 0)
 	atomic.StoreUint64(&s.SyncTimes, 0)
+	DefaultAPIKeys.Reset()
 }
 
 func (s *Stat) procSpeed() {
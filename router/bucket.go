@@ -2,28 +2,33 @@ package main
 
 import (
 	"goim/libs/define"
+	"goim/libs/proto"
 	"sync"
 	"time"
 )
 
 type Bucket struct {
 	bLock             sync.RWMutex
-	server            int                       // session server map init num
-	session           int                       // bucket session init num
-	sessions          map[int64]*Session        // userid->sessions
-	roomCounter       map[int32]int32           // roomid->count
-	serverCounter     map[int32]int32           // server->count
-	userServerCounter map[int32]map[int64]int32 // serverid->userid count
-	cleaner           *Cleaner                  // bucket map cleaner
+	server            int                        // session server map init num
+	session           int                        // bucket session init num
+	sessions          map[int64]*Session         // userid->sessions
+	roomCounter       map[string]int32           // roomid->count
+	serverCounter     map[int32]int32            // server->count
+	userServerCounter map[int32]map[int64]int32  // serverid->userid count
+	roomUsers         map[string]map[int64]int32 // roomid->userid count
+	policy            *Policy                    // multi-session policy
+	cleaner           *Cleaner                   // bucket map cleaner
 }
 
 // NewBucket new a bucket struct. store the subkey with im channel.
-func NewBucket(session, server, cleaner int) *Bucket {
+func NewBucket(session, server, cleaner int, policy *Policy) *Bucket {
 	b := new(Bucket)
 	b.sessions = make(map[int64]*Session, session)
-	b.roomCounter = make(map[int32]int32)
+	b.roomCounter = make(map[string]int32)
 	b.serverCounter = make(map[int32]int32)
 	b.userServerCounter = make(map[int32]map[int64]int32)
+	b.roomUsers = make(map[string]map[int64]int32)
+	b.policy = policy
 	b.cleaner = NewCleaner(cleaner)
 	b.server = server
 	b.session = session
@@ -32,7 +37,7 @@ func NewBucket(session, server, cleaner int) *Bucket {
 }
 
 // counter incr or decr counter.
-func (b *Bucket) counter(userId int64, server int32, roomId int32, incr bool) {
+func (b *Bucket) counter(userId int64, server int32, roomId string, incr bool) {
 	var (
 		sm map[int64]int32
 		v  int32
@@ -46,6 +51,9 @@ func (b *Bucket) counter(userId int64, server int32, roomId int32, incr bool) {
 		sm[userId]++
 		b.roomCounter[roomId]++
 		b.serverCounter[server]++
+		if roomId != define.NoRoom {
+			b.roomUser(userId, roomId, true)
+		}
 	} else {
 		// WARN:
 		// if decr a userid but key not exists just ignore
@@ -57,26 +65,79 @@ func (b *Bucket) counter(userId int64, server int32, roomId int32, incr bool) {
 		}
 		b.roomCounter[roomId]--
 		b.serverCounter[server]--
+		if roomId != define.NoRoom {
+			b.roomUser(userId, roomId, false)
+		}
 	}
 }
 
-// Put put a channel according with user id.
-func (b *Bucket) Put(userId int64, server int32, roomId int32) (seq int32) {
+// roomUser incr or decr the room user index.
+func (b *Bucket) roomUser(userId int64, roomId string, incr bool) {
 	var (
-		s  *Session
-		ok bool
+		users map[int64]int32
+		ok    bool
+	)
+	if users, ok = b.roomUsers[roomId]; !ok {
+		if !incr {
+			return
+		}
+		users = make(map[int64]int32)
+		b.roomUsers[roomId] = users
+	}
+	if incr {
+		users[userId]++
+		return
+	}
+	if users[userId]--; users[userId] <= 0 {
+		delete(users, userId)
+		if len(users) == 0 {
+			delete(b.roomUsers, roomId)
+		}
+	}
+}
+
+// Put put a channel according with user id. The sessions evicted by the
+// policy are returned as seq:server.
+func (b *Bucket) Put(userId int64, server int32, roomId string, platform string) (seq int32, kicks map[int32]int32, err error) {
+	var (
+		s     *Session
+		ok    bool
+		seqs  []int32
+		kseq  int32
+		krid  string
+		has   bool
+		kserv int32
 	)
 	b.bLock.Lock()
+	if seqs, err = b.policy.Evict(b.sessions[userId], platform); err != nil {
+		b.bLock.Unlock()
+		return
+	}
 	if s, ok = b.sessions[userId]; !ok {
 		s = NewSession(b.server)
 		b.sessions[userId] = s
 	}
 	if roomId != define.NoRoom {
-		seq = s.PutRoom(server, roomId)
+		seq = s.PutRoom(server, roomId, platform)
 	} else {
-		seq = s.Put(server)
+		seq = s.Put(server, platform)
 	}
 	b.counter(userId, server, roomId, true)
+	// evict after put, the new session keeps the room member
+	if len(seqs) > 0 {
+		kicks = make(map[int32]int32, len(seqs))
+	}
+	for _, kseq = range seqs {
+		if krid = s.Room(kseq); krid != define.NoRoom {
+			has, _, kserv = s.DelRoom(kseq, krid)
+		} else {
+			has, _, kserv = s.Del(kseq)
+		}
+		if has {
+			b.counter(userId, kserv, krid, false)
+			kicks[kseq] = kserv
+		}
+	}
 	b.bLock.Unlock()
 	return
 }
@@ -106,7 +167,7 @@ func (b *Bucket) GetAll() (userIds []int64, seqs [][]int32, servers [][]int32) {
 }
 
 // Del delete the channel by sub key.
-func (b *Bucket) Del(userId int64, seq int32, roomId int32) (ok bool) {
+func (b *Bucket) Del(userId int64, seq int32, roomId string) (ok bool) {
 	var (
 		s          *Session
 		server     int32
@@ -138,10 +199,10 @@ func (b *Bucket) Del(userId int64, seq int32, roomId int32) (ok bool) {
 
 func (b *Bucket) DelServer(server int32) {
 	var (
-		roomCounter       = make(map[int32]int32)
+		roomCounter       = make(map[string]int32)
 		servers           map[int32]int32
 		userServerCounter map[int64]int32
-		roomId            int32
+		roomId            string
 		count             int32
 		userId            int64
 		s                 *Session
@@ -162,6 +223,9 @@ func (b *Bucket) DelServer(server int32) {
 		}
 		for roomId, servers = range s.rooms {
 			roomCounter[roomId] += int32(len(servers))
+			if delete(b.roomUsers[roomId], userId); len(b.roomUsers[roomId]) == 0 {
+				delete(b.roomUsers, roomId)
+			}
 		}
 		delete(b.sessions, userId)
 	}
@@ -172,7 +236,7 @@ func (b *Bucket) DelServer(server int32) {
 	return
 }
 
-func (b *Bucket) count(roomId int32) (count int32) {
+func (b *Bucket) count(roomId string) (count int32) {
 	b.bLock.RLock()
 	count = b.roomCounter[roomId]
 	b.bLock.RUnlock()
@@ -184,15 +248,18 @@ func (b *Bucket) Count() (count int32) {
 	return
 }
 
-func (b *Bucket) RoomCount(roomId int32) (count int32) {
+func (b *Bucket) RoomCount(roomId string) (count int32) {
 	count = b.count(roomId)
 	return
 }
 
-func (b *Bucket) AllRoomCount() (roomCounter map[int32]int32) {
-	var roomId, count int32
+func (b *Bucket) AllRoomCount() (roomCounter map[string]int32) {
+	var (
+		roomId string
+		count  int32
+	)
 	b.bLock.RLock()
-	roomCounter = make(map[int32]int32, len(b.roomCounter))
+	roomCounter = make(map[string]int32, len(b.roomCounter))
 	for roomId, count = range b.roomCounter {
 		if count > 0 {
 			roomCounter[roomId] = count
@@ -222,6 +289,44 @@ func (b *Bucket) UserCount(userId int64) (count int32) {
 	return
 }
 
+// RoomUsers get the user ids in the room.
+func (b *Bucket) RoomUsers(roomId string) (userIds []int64) {
+	b.bLock.RLock()
+	users := b.roomUsers[roomId]
+	userIds = make([]int64, 0, len(users))
+	for userId := range users {
+		userIds = append(userIds, userId)
+	}
+	b.bLock.RUnlock()
+	return
+}
+
+// RoomMembers get the channels of the users on the comets in the rooms.
+func (b *Bucket) RoomMembers() (members []*proto.RoomMember) {
+	b.bLock.RLock()
+	for userId, s := range b.sessions {
+		for roomId, seqs := range s.rooms {
+			servers := make(map[int32]int32, len(seqs))
+			for _, server := range seqs {
+				servers[server]++
+			}
+			for server, count := range servers {
+				members = append(members, &proto.RoomMember{RoomId: roomId, UserId: userId, Server: server, Count: count})
+			}
+		}
+	}
+	b.bLock.RUnlock()
+	return
+}
+
+// UserTotal get the online user number in the bucket.
+func (b *Bucket) UserTotal() (count int) {
+	b.bLock.RLock()
+	count = len(b.sessions)
+	b.bLock.RUnlock()
+	return
+}
+
 func (b *Bucket) delEmpty(userId int64) {
 	var (
 		s  *Session
@@ -256,7 +361,7 @@ func (b *Bucket) clean() {
 //描述：清空房间人数
 //作者：徐明祥
 //日期：2017-01-08
-func (b *Bucket) CleanRoomCount(roomId int32) {
+func (b *Bucket) CleanRoomCount(roomId string) {
 	b.bLock.RLock()
 	if _, ok := b.roomCounter[roomId]; ok {
 		b.roomCounter[roomId] = 0
@@ -27,6 +27,13 @@ type Config struct {
 	PprofAddrs []string `goconf:"base:pprof.addrs:,"`
 	// rpc
 	RPCAddrs []string `goconf:"rpc:addrs:,"`
+	// rpc auth
+	RPCTLSOpen       bool   `goconf:"rpc.auth:tls.open"`
+	RPCTLSCert       string `goconf:"rpc.auth:tls.cert"`
+	RPCTLSKey        string `goconf:"rpc.auth:tls.key"`
+	RPCTLSCA         string `goconf:"rpc.auth:tls.ca"`
+	RPCTLSServerName string `goconf:"rpc.auth:tls.server.name"`
+	RPCSecret        string `goconf:"rpc.auth:secret"`
 	// bucket
 	Bucket            int           `goconf:"bucket:bucket"`
 	Server            int           `goconf:"bucket:server"`
@@ -35,6 +42,15 @@ type Config struct {
 	// session
 	Session       int           `goconf:"session:session"`
 	SessionExpire time.Duration `goconf:"session:expire:time"`
+	// session policy
+	PolicyMax       int      `goconf:"session:policy.max"`
+	PolicyPlatforms []string `goconf:"session:policy.platforms:,"`
+	PolicyKick      bool     `goconf:"session:policy.kick"`
+	// outbox
+	OutboxSize int           `goconf:"outbox:size"`
+	OutboxAge  time.Duration `goconf:"outbox:age:time"`
+	// room
+	RoomMetaFile string `goconf:"room:meta.file"`
 	// monitor
 	MonitorOpen  bool     `goconf:"monitor:open"`
 	MonitorAddrs []string `goconf:"monitor:addrs:,"`
@@ -58,6 +74,11 @@ func NewConfig() *Config {
 		// session
 		Session:       1000,
 		SessionExpire: time.Hour * 1,
+		// outbox
+		OutboxSize: 20,
+		OutboxAge:  time.Hour * 1,
+		// room
+		RoomMetaFile: "./router-room.json",
 	}
 }
 
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"errors"
+)
+
+var (
+	// session
+	ErrSessionLimit = errors.New("over the session limit")
+	ErrPolicy       = errors.New("session policy error, must platform:max")
+)
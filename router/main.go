@@ -32,16 +32,27 @@ func main() {
 	guluLogger.Infof("router[%s] start", VERSION)
 	// start prof
 	perf.Init(Conf.PprofAddrs)
+	buckets := make([]*Bucket, Conf.Bucket)
+	outboxes := make([]*Outbox, Conf.Bucket)
+	rooms, err := NewRooms(Conf.RoomMetaFile)
+	if err != nil {
+		panic(err)
+	}
+	policy, err := NewPolicy(Conf.PolicyMax, Conf.PolicyPlatforms, Conf.PolicyKick)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < Conf.Bucket; i++ {
+		buckets[i] = NewBucket(Conf.Session, Conf.Server, Conf.Cleaner, policy)
+		outboxes[i] = NewOutbox(Conf.OutboxSize, Conf.OutboxAge)
+	}
+	InitMetrics(buckets, outboxes)
 	// start monitor
 	if Conf.MonitorOpen {
 		InitMonitor(Conf.MonitorAddrs)
 	}
 	// start rpc
-	buckets := make([]*Bucket, Conf.Bucket)
-	for i := 0; i < Conf.Bucket; i++ {
-		buckets[i] = NewBucket(Conf.Session, Conf.Server, Conf.Cleaner)
-	}
-	if err := InitRPC(buckets); err != nil {
+	if err := InitRPC(buckets, outboxes, rooms); err != nil {
 		panic(err)
 	}
 	// block until a signal is received.
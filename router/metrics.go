@@ -0,0 +1,49 @@
+package main
+
+import (
+	"goim/libs/metrics"
+)
+
+var (
+	sessionOps = metrics.NewCounterVec("goim_router_session_ops_total", "session operations by op.", "op")
+
+	sessionPut = sessionOps.WithLabelValues("put")
+	sessionDel = sessionOps.WithLabelValues("del")
+	// policy
+	sessionKick   = sessionOps.WithLabelValues("kick")
+	sessionRefuse = sessionOps.WithLabelValues("refuse")
+	// room max members
+	roomRefuse = sessionOps.WithLabelValues("room_refuse")
+)
+
+// InitMetrics register the router metrics, the gauges are collected from
+// the buckets.
+func InitMetrics(bs []*Bucket, os []*Outbox) {
+	metrics.MustRegister(sessionOps,
+		metrics.NewGaugeFunc("goim_router_sessions", "online sessions in all buckets.", func() float64 {
+			var n int32
+			for _, b := range bs {
+				for _, c := range b.AllServerCount() {
+					n += c
+				}
+			}
+			return float64(n)
+		}),
+		metrics.NewGaugeFunc("goim_router_rooms", "rooms with online sessions.", func() float64 {
+			rooms := make(map[string]struct{})
+			for _, b := range bs {
+				for roomId := range b.AllRoomCount() {
+					rooms[roomId] = struct{}{}
+				}
+			}
+			return float64(len(rooms))
+		}),
+		metrics.NewGaugeFunc("goim_router_outbox_users", "users with message seqs in all outboxes.", func() float64 {
+			var n int
+			for _, o := range os {
+				n += o.Len()
+			}
+			return float64(n)
+		}),
+	)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	log "github.com/thinkboy/log4go"
+	"goim/libs/metrics"
 	"net/http"
 )
 
@@ -13,6 +14,7 @@ func InitMonitor(binds []string) {
 	m := new(Monitor)
 	monitorServeMux := http.NewServeMux()
 	monitorServeMux.HandleFunc("/monitor/ping", m.Ping)
+	monitorServeMux.Handle("/metrics", metrics.Handler())
 	for _, addr := range binds {
 		log.Info("start monitor listen: \"%s\"", addr)
 		go func(bind string) {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"goim/libs/proto"
+	"sync"
+	"time"
+)
+
+// userOutbox is the message seq and the latest messages of a user, the
+// oldest message is overwritten.
+type userOutbox struct {
+	seq    int32
+	msgs   []*proto.OutboxMsg
+	next   int
+	num    int
+	active time.Time
+}
+
+// Outbox allocate the increasing message seqs of the users and keep their
+// latest messages, so the clients detect the gaps by the seqs and ask the
+// resends. the idle users are released after age, their seqs restart
+// from 1.
+type Outbox struct {
+	lock  sync.Mutex
+	size  int
+	age   time.Duration
+	users map[int64]*userOutbox
+}
+
+// NewOutbox new a outbox keeps the latest size messages of a user, size 0
+// only allocates the seqs.
+func NewOutbox(size int, age time.Duration) *Outbox {
+	o := &Outbox{size: size, age: age, users: make(map[int64]*userOutbox)}
+	if age > 0 {
+		go o.clean()
+	}
+	return o
+}
+
+// Push allocate the next seq of the user and keep the message.
+func (o *Outbox) Push(userId int64, msg []byte, expire int64) (seq int32) {
+	o.lock.Lock()
+	u, ok := o.users[userId]
+	if !ok {
+		u = &userOutbox{msgs: make([]*proto.OutboxMsg, o.size)}
+		o.users[userId] = u
+	}
+	if u.seq++; u.seq <= 0 {
+		// overflow, restart
+		u.seq = 1
+	}
+	seq = u.seq
+	u.active = time.Now()
+	if o.size > 0 {
+		u.msgs[u.next] = &proto.OutboxMsg{Seq: seq, Msg: msg, Expire: expire}
+		if u.next++; u.next == o.size {
+			u.next = 0
+		}
+		if u.num < o.size {
+			u.num++
+		}
+	}
+	o.lock.Unlock()
+	return
+}
+
+// Get get the kept messages of the user after the seq from the oldest, the
+// expired messages are skipped.
+func (o *Outbox) Get(userId int64, since int32) (msgs []*proto.OutboxMsg) {
+	var now = time.Now().UnixNano() / int64(time.Millisecond)
+	o.lock.Lock()
+	if u, ok := o.users[userId]; ok {
+		for i := 0; i < u.num; i++ {
+			m := u.msgs[(u.next-u.num+i+o.size)%o.size]
+			if m.Seq <= since || (m.Expire > 0 && now > m.Expire) {
+				continue
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	o.lock.Unlock()
+	return
+}
+
+// Len get the users in the outbox.
+func (o *Outbox) Len() (n int) {
+	o.lock.Lock()
+	n = len(o.users)
+	o.lock.Unlock()
+	return
+}
+
+// clean release the users idle over age.
+func (o *Outbox) clean() {
+	for {
+		time.Sleep(o.age)
+		now := time.Now()
+		o.lock.Lock()
+		for userId, u := range o.users {
+			if now.Sub(u.active) >= o.age {
+				delete(o.users, userId)
+			}
+		}
+		o.lock.Unlock()
+	}
+}
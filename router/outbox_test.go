@@ -0,0 +1,59 @@
+package main
+
+import (
+	"goim/libs/define"
+	"goim/libs/proto"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	o := NewOutbox(3, 0)
+	for i := 1; i <= 5; i++ {
+		if seq := o.Push(1, []byte{byte(i)}, 0); seq != int32(i) {
+			t.Fatalf("push %d got seq %d", i, seq)
+		}
+	}
+	if seq := o.Push(2, nil, 0); seq != 1 {
+		t.Fatalf("user 2 got seq %d", seq)
+	}
+	// the latest 3 kept
+	msgs := o.Get(1, 0)
+	if len(msgs) != 3 || msgs[0].Seq != 3 || msgs[2].Seq != 5 || msgs[2].Msg[0] != 5 {
+		t.Fatalf("get %v", msgs)
+	}
+	if msgs = o.Get(1, 4); len(msgs) != 1 || msgs[0].Seq != 5 {
+		t.Fatalf("get since 4 %v", msgs)
+	}
+	// expired
+	o.Push(1, nil, 1)
+	if msgs = o.Get(1, 5); len(msgs) != 0 {
+		t.Fatalf("get expired %v", msgs)
+	}
+	// seqs only
+	o = NewOutbox(0, 0)
+	if seq := o.Push(1, nil, 0); seq != 1 || len(o.Get(1, 0)) != 0 {
+		t.Fatalf("seqs only got seq %d", seq)
+	}
+}
+
+func TestRouterMPush(t *testing.T) {
+	// the buckets read it in their cleaners
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	var (
+		r     = &RouterRPC{Buckets: []*Bucket{NewBucket(10, 10, 10, nil)}, Outboxes: []*Outbox{NewOutbox(3, 0)}, BucketIdx: 1}
+		reply = proto.MPushReply{}
+	)
+	r.Buckets[0].Put(1, 1, define.NoRoom, "")
+	if err := r.MPush(&proto.MPushArg{UserIds: []int64{1, 2}, Msg: []byte("hi")}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.MsgSeqs[0] != 1 || reply.MsgSeqs[1] != 0 {
+		t.Errorf("seqs got %v, want [1 0]", reply.MsgSeqs)
+	}
+	// the offline user is not kept
+	if n := r.Outboxes[0].Len(); n != 1 {
+		t.Errorf("outbox users got %d, want 1", n)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy is the multi-session policy of a user, the sessions over the limits
+// are refused, or the oldest ones are kicked if kick.
+type Policy struct {
+	Max       int            // max sessions of a user, 0 no limit
+	Platforms map[string]int // max sessions of a platform, 0 no limit
+	Kick      bool           // kick the oldest sessions instead of refusing
+}
+
+// NewPolicy new a policy, the platforms are "platform:max" pairs.
+func NewPolicy(max int, platforms []string, kick bool) (p *Policy, err error) {
+	var (
+		n   int
+		idx int
+	)
+	p = &Policy{Max: max, Platforms: make(map[string]int, len(platforms)), Kick: kick}
+	for _, platform := range platforms {
+		if platform = strings.TrimSpace(platform); platform == "" {
+			continue
+		}
+		if idx = strings.LastIndexByte(platform, ':'); idx <= 0 {
+			err = ErrPolicy
+			return
+		}
+		if n, err = strconv.Atoi(platform[idx+1:]); err != nil || n < 0 {
+			err = ErrPolicy
+			return
+		}
+		p.Platforms[platform[:idx]] = n
+	}
+	return
+}
+
+// Evict get the seqs must be evicted before a new session of the platform,
+// the seqs are increasing so the smaller is the older, ErrSessionLimit if
+// over the limits and not kick.
+func (p *Policy) Evict(s *Session, platform string) (seqs []int32, err error) {
+	var (
+		max     int
+		all     []int32
+		same    []int32
+		evicted = make(map[int32]struct{})
+	)
+	if p == nil || s == nil || (p.Max <= 0 && len(p.Platforms) == 0) {
+		return
+	}
+	for seq := range s.servers {
+		all = append(all, seq)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	// platform limit
+	if max = p.Platforms[platform]; max > 0 {
+		for _, seq := range all {
+			if s.platforms[seq] == platform {
+				same = append(same, seq)
+			}
+		}
+		for i := 0; i < len(same)-max+1; i++ {
+			evicted[same[i]] = struct{}{}
+			seqs = append(seqs, same[i])
+		}
+	}
+	// user limit
+	if p.Max > 0 {
+		for i, n := 0, len(all)-len(seqs)-p.Max+1; n > 0 && i < len(all); i++ {
+			if _, ok := evicted[all[i]]; !ok {
+				seqs = append(seqs, all[i])
+				n--
+			}
+		}
+	}
+	if len(seqs) > 0 && !p.Kick {
+		seqs = nil
+		err = ErrSessionLimit
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	p, err := NewPolicy(2, []string{"ios:1", " pc:1 ", ""}, true)
+	if err != nil {
+		t.Fatalf("NewPolicy() error(%v)", err)
+	}
+	if p.Platforms["ios"] != 1 || p.Platforms["pc"] != 1 {
+		t.Errorf("NewPolicy() platforms got %v", p.Platforms)
+	}
+	for _, platforms := range [][]string{{"ios"}, {"ios:a"}, {":1"}, {"ios:-1"}} {
+		if _, err = NewPolicy(0, platforms, false); err != ErrPolicy {
+			t.Errorf("NewPolicy(%v) error(%v), want ErrPolicy", platforms, err)
+		}
+	}
+}
+
+func TestBucketPolicy(t *testing.T) {
+	// the buckets read it in their cleaners
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	p, _ := NewPolicy(2, []string{"ios:1"}, true)
+	b := NewBucket(10, 10, 10, p)
+	// new ios login kicks the old ios
+	seq1, _, _ := b.Put(1, 1, "1", "ios")
+	_, kicks, err := b.Put(1, 2, "1", "ios")
+	if err != nil || len(kicks) != 1 || kicks[seq1] != 1 {
+		t.Fatalf("b.Put(ios) got kicks %v error(%v), want map[%d:1]", kicks, err, seq1)
+	}
+	if n := b.RoomCount("1"); n != 1 {
+		t.Errorf("b.RoomCount(1) got %d, want 1", n)
+	}
+	if users := b.RoomUsers("1"); len(users) != 1 {
+		t.Errorf("b.RoomUsers(1) got %v, want [1]", users)
+	}
+	// max 2 kicks the oldest
+	seq3, kicks, _ := b.Put(1, 1, "", "pc")
+	if len(kicks) != 0 {
+		t.Errorf("b.Put(pc) got kicks %v, want none", kicks)
+	}
+	if _, kicks, _ = b.Put(1, 1, "", "android"); len(kicks) != 1 {
+		t.Errorf("b.Put(android) got kicks %v, want 1", kicks)
+	}
+	if n := b.UserCount(1); n != 2 {
+		t.Errorf("b.UserCount(1) got %d, want 2", n)
+	}
+	if seqs, _ := b.Get(1); len(seqs) != 2 || (seqs[0] != seq3 && seqs[1] != seq3) {
+		t.Errorf("b.Get(1) got %v, want the pc seq %d kept", seqs, seq3)
+	}
+	// refuse if not kick
+	p.Kick = false
+	if _, _, err = b.Put(1, 1, "", "web"); err != ErrSessionLimit {
+		t.Errorf("b.Put(web) error(%v), want ErrSessionLimit", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"goim/libs/proto"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// roomMember is a user in the room on a comet.
+type roomMember struct {
+	userId int64
+	server int32
+}
+
+// Rooms is the room metadata and the members of the rooms owned by this
+// router. the metadata is set to all routers and persisted in the file, the
+// members of a room are only counted by its owner, the router of the room id
+// in the logic router ring, so the max members is enforced in one place.
+type Rooms struct {
+	lock    sync.RWMutex
+	file    string
+	metas   map[string]*proto.RoomMeta
+	members map[string]map[roomMember]int32 // roomid->member channels
+	users   map[string]map[int64]int32      // roomid->userid comets
+}
+
+// NewRooms new a rooms struct, the metadata is loaded from and saved in the
+// file, empty file not persisted.
+func NewRooms(file string) (r *Rooms, err error) {
+	var b []byte
+	r = &Rooms{
+		file:    file,
+		metas:   make(map[string]*proto.RoomMeta),
+		members: make(map[string]map[roomMember]int32),
+		users:   make(map[string]map[int64]int32),
+	}
+	if file == "" {
+		return
+	}
+	if b, err = ioutil.ReadFile(file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var metas []*proto.RoomMeta
+	if err = json.Unmarshal(b, &metas); err != nil {
+		return
+	}
+	for _, meta := range metas {
+		r.metas[meta.RoomId] = meta
+	}
+	return
+}
+
+// save write the metadata to a tmp file and rename it, under the lock.
+func (r *Rooms) save() (err error) {
+	var (
+		b     []byte
+		tmp   = r.file + ".tmp"
+		metas = make([]*proto.RoomMeta, 0, len(r.metas))
+	)
+	if r.file == "" {
+		return
+	}
+	for _, meta := range r.metas {
+		metas = append(metas, meta)
+	}
+	if b, err = json.Marshal(metas); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, r.file)
+}
+
+// Set set the room metadata.
+func (r *Rooms) Set(meta *proto.RoomMeta) (err error) {
+	r.lock.Lock()
+	r.metas[meta.RoomId] = meta
+	if err = r.save(); err != nil {
+		guluLogger.Errorf("rooms save(\"%s\") error(%v)", r.file, err)
+	}
+	r.lock.Unlock()
+	return
+}
+
+// Get get the room metadata and members.
+func (r *Rooms) Get(roomId string) (meta *proto.RoomMeta, members int32) {
+	r.lock.RLock()
+	meta = r.metas[roomId]
+	members = int32(len(r.users[roomId]))
+	r.lock.RUnlock()
+	return
+}
+
+// Del delete the room metadata.
+func (r *Rooms) Del(roomId string) (err error) {
+	r.lock.Lock()
+	delete(r.metas, roomId)
+	if err = r.save(); err != nil {
+		guluLogger.Errorf("rooms save(\"%s\") error(%v)", r.file, err)
+	}
+	r.lock.Unlock()
+	return
+}
+
+// Join add a channel of the user on the comet to the room, false if the user
+// is not a member and the room is full.
+func (r *Rooms) Join(roomId string, userId int64, server int32) (ok bool) {
+	var m = roomMember{userId: userId, server: server}
+	r.lock.Lock()
+	users := r.users[roomId]
+	if meta, has := r.metas[roomId]; has && meta.Max > 0 && users[userId] == 0 && int32(len(users)) >= meta.Max {
+		r.lock.Unlock()
+		return
+	}
+	if users == nil {
+		users = make(map[int64]int32)
+		r.users[roomId] = users
+		r.members[roomId] = make(map[roomMember]int32)
+	}
+	if r.members[roomId][m]++; r.members[roomId][m] == 1 {
+		users[userId]++
+	}
+	r.lock.Unlock()
+	return true
+}
+
+// Leave remove a channel of the user on the comet from the room.
+func (r *Rooms) Leave(roomId string, userId int64, server int32) {
+	var m = roomMember{userId: userId, server: server}
+	r.lock.Lock()
+	if members, ok := r.members[roomId]; ok && members[m] > 0 {
+		if members[m]--; members[m] == 0 {
+			delete(members, m)
+			r.leave(roomId, userId)
+		}
+	}
+	r.lock.Unlock()
+}
+
+// leave remove a comet of the user from the room, under the lock.
+func (r *Rooms) leave(roomId string, userId int64) {
+	users := r.users[roomId]
+	if users[userId]--; users[userId] > 0 {
+		return
+	}
+	delete(users, userId)
+	if len(users) == 0 {
+		delete(r.users, roomId)
+		delete(r.members, roomId)
+	}
+}
+
+// Reset replace the members of all rooms, rebuilt from the sessions of all
+// routers by logic.
+func (r *Rooms) Reset(members []*proto.RoomMember) {
+	var (
+		rooms = make(map[string]map[roomMember]int32)
+		users = make(map[string]map[int64]int32)
+	)
+	for _, m := range members {
+		if m.Count <= 0 {
+			continue
+		}
+		if rooms[m.RoomId] == nil {
+			rooms[m.RoomId] = make(map[roomMember]int32)
+			users[m.RoomId] = make(map[int64]int32)
+		}
+		key := roomMember{userId: m.UserId, server: m.Server}
+		if rooms[m.RoomId][key] == 0 {
+			users[m.RoomId][m.UserId]++
+		}
+		rooms[m.RoomId][key] += m.Count
+	}
+	r.lock.Lock()
+	r.members = rooms
+	r.users = users
+	r.lock.Unlock()
+}
+
+// DelServer remove the members on the comet from all rooms.
+func (r *Rooms) DelServer(server int32) {
+	r.lock.Lock()
+	for roomId, members := range r.members {
+		for m := range members {
+			if m.server == server {
+				delete(members, m)
+				r.leave(roomId, m.userId)
+			}
+		}
+	}
+	r.lock.Unlock()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"goim/libs/proto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoomMax(t *testing.T) {
+	rooms, err := NewRooms("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms.Set(&proto.RoomMeta{RoomId: "1", Max: 2})
+	if !rooms.Join("1", 1, 1) {
+		t.Fatal("Join(1) refused")
+	}
+	// the same user joins again on another comet
+	if !rooms.Join("1", 1, 2) {
+		t.Fatal("Join(1) again refused")
+	}
+	if !rooms.Join("1", 2, 1) {
+		t.Fatal("Join(2) refused")
+	}
+	if rooms.Join("1", 3, 1) {
+		t.Fatal("Join(3) got ok, want full")
+	}
+	if _, members := rooms.Get("1"); members != 2 {
+		t.Errorf("members got %d, want 2", members)
+	}
+	rooms.Leave("1", 2, 1)
+	if _, members := rooms.Get("1"); members != 1 {
+		t.Errorf("members got %d, want 1", members)
+	}
+	if !rooms.Join("1", 3, 1) {
+		t.Error("Join(3) after leave refused")
+	}
+	// user 1 stays by the comet 2
+	rooms.DelServer(1)
+	if _, members := rooms.Get("1"); members != 1 {
+		t.Errorf("members after DelServer got %d, want 1", members)
+	}
+	rooms.Leave("1", 1, 2)
+	// leave again ignored
+	rooms.Leave("1", 1, 2)
+	if _, members := rooms.Get("1"); members != 0 {
+		t.Errorf("members got %d, want 0", members)
+	}
+	// unlimited without the metadata
+	for i := int64(0); i < 10; i++ {
+		if !rooms.Join("2", i, 1) {
+			t.Fatalf("Join(2, %d) refused", i)
+		}
+	}
+}
+
+func TestRoomMetaFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "room.json")
+	rooms, err := NewRooms(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms.Set(&proto.RoomMeta{RoomId: "1", Name: "a", Max: 2})
+	rooms.Set(&proto.RoomMeta{RoomId: "2", Name: "b"})
+	rooms.Del("2")
+	if rooms, err = NewRooms(file); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := rooms.Get("1"); meta == nil || meta.Name != "a" || meta.Max != 2 {
+		t.Errorf("meta got %v", meta)
+	}
+	if meta, _ := rooms.Get("2"); meta != nil {
+		t.Errorf("deleted meta got %v", meta)
+	}
+	if _, err = os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("tmp file error(%v)", err)
+	}
+}
+
+func TestBucketRoomUsers(t *testing.T) {
+	// the buckets read it in their cleaners
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	b := NewBucket(10, 10, 10, nil)
+	b.Put(1, 1, "1", "")
+	seq, _, _ := b.Put(2, 1, "1", "")
+	if userIds := b.RoomUsers("1"); len(userIds) != 2 {
+		t.Errorf("b.RoomUsers(1) got %v", userIds)
+	}
+	b.Del(2, seq, "1")
+	if userIds := b.RoomUsers("1"); len(userIds) != 1 {
+		t.Errorf("b.RoomUsers(1) after del got %v", userIds)
+	}
+	b.DelServer(1)
+	if userIds := b.RoomUsers("1"); len(userIds) != 0 {
+		t.Errorf("b.RoomUsers(1) after DelServer got %v", userIds)
+	}
+}
+
+func TestRoomReset(t *testing.T) {
+	// the buckets read it in their cleaners
+	if Conf == nil {
+		Conf = NewConfig()
+	}
+	b := NewBucket(10, 10, 10, nil)
+	b.Put(1, 1, "1", "")
+	b.Put(1, 1, "1", "")
+	b.Put(1, 2, "1", "")
+	b.Put(2, 1, "1", "")
+	b.Put(3, 1, "2", "")
+	rooms, err := NewRooms("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms.Set(&proto.RoomMeta{RoomId: "1", Max: 2})
+	// a stale member of a restarted owner
+	rooms.Join("1", 9, 1)
+	rooms.Reset(b.RoomMembers())
+	if _, members := rooms.Get("1"); members != 2 {
+		t.Errorf("room 1 members got %d, want 2", members)
+	}
+	if _, members := rooms.Get("2"); members != 1 {
+		t.Errorf("room 2 members got %d, want 1", members)
+	}
+	if rooms.Join("1", 9, 1) {
+		t.Error("Join(9) got ok, want full")
+	}
+	// the channels of user 1 on comet 1 leave one by one
+	rooms.Leave("1", 1, 1)
+	rooms.Leave("1", 1, 2)
+	if _, members := rooms.Get("1"); members != 2 {
+		t.Errorf("room 1 members got %d, want 2", members)
+	}
+	rooms.Leave("1", 1, 1)
+	if _, members := rooms.Get("1"); members != 1 {
+		t.Errorf("room 1 members got %d, want 1", members)
+	}
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"goim/libs/define"
 	inet "goim/libs/net"
+	"goim/libs/net/xrpc"
 	"goim/libs/proto"
 	"net"
 	"net/rpc"
 )
 
-func InitRPC(bs []*Bucket) (err error) {
+func InitRPC(bs []*Bucket, os []*Outbox, rooms *Rooms) (err error) {
 	var (
 		network, addr string
-		c             = &RouterRPC{Buckets: bs, BucketIdx: int64(len(bs))}
+		options       xrpc.ServerOptions
+		c             = &RouterRPC{Buckets: bs, Outboxes: os, BucketIdx: int64(len(bs)), Rooms: rooms}
 	)
+	if options, err = xrpc.NewAuthOptions(Conf.RPCTLSOpen, Conf.RPCTLSCert, Conf.RPCTLSKey, Conf.RPCTLSCA, Conf.RPCTLSServerName, Conf.RPCSecret).ServerOptions(); err != nil {
+		guluLogger.Errorf("rpc auth ServerOptions() error(%v)", err)
+		return
+	}
 	rpc.Register(c)
 	for i := 0; i < len(Conf.RPCAddrs); i++ {
 		guluLogger.Infof("start listen rpc addr: \"%s\"", Conf.RPCAddrs[i])
@@ -19,12 +26,12 @@ func InitRPC(bs []*Bucket) (err error) {
 			guluLogger.Errorf("inet.ParseNetwork() error(%v)", err)
 			return
 		}
-		go rpcListen(network, addr)
+		go rpcListen(network, addr, options)
 	}
 	return
 }
 
-func rpcListen(network, addr string) {
+func rpcListen(network, addr string, options xrpc.ServerOptions) {
 	l, err := net.Listen(network, addr)
 	if err != nil {
 		guluLogger.Errorf("net.Listen(\"%s\", \"%s\") error(%v)", network, addr, err)
@@ -37,35 +44,122 @@ func rpcListen(network, addr string) {
 			guluLogger.Errorf("listener.Close() error(%v)", err)
 		}
 	}()
-	rpc.Accept(l)
+	xrpc.Accept(l, options)
 }
 
 // Router RPC
 type RouterRPC struct {
 	Buckets   []*Bucket
+	Outboxes  []*Outbox // sharded as the buckets
 	BucketIdx int64
+	Rooms     *Rooms
 }
 
-func (r *RouterRPC) bucket(userId int64) *Bucket {
+func (r *RouterRPC) index(userId int64) int {
 	idx := int(userId % r.BucketIdx)
 	// fix panic
 	if idx < 0 {
 		idx = 0
 	}
-	return r.Buckets[idx]
+	return idx
+}
+
+func (r *RouterRPC) bucket(userId int64) *Bucket {
+	return r.Buckets[r.index(userId)]
+}
+
+func (r *RouterRPC) outbox(userId int64) *Outbox {
+	return r.Outboxes[r.index(userId)]
 }
 
 func (r *RouterRPC) Ping(arg *proto.NoArg, reply *proto.NoReply) error {
 	return nil
 }
 
-func (r *RouterRPC) Put(arg *proto.PutArg, reply *proto.PutReply) error {
-	reply.Seq = r.bucket(arg.UserId).Put(arg.UserId, arg.Server, arg.RoomId)
+func (r *RouterRPC) SetRoom(arg *proto.RoomMeta, reply *proto.NoReply) error {
+	return r.Rooms.Set(arg)
+}
+
+func (r *RouterRPC) DelRoom(arg *proto.RoomArg, reply *proto.NoReply) error {
+	return r.Rooms.Del(arg.RoomId)
+}
+
+func (r *RouterRPC) JoinRoom(arg *proto.RoomJoinArg, reply *proto.RoomJoinReply) error {
+	if reply.Full = !r.Rooms.Join(arg.RoomId, arg.UserId, arg.Server); reply.Full {
+		roomRefuse.Inc()
+	}
+	return nil
+}
+
+func (r *RouterRPC) LeaveRoom(arg *proto.RoomJoinArg, reply *proto.NoReply) error {
+	r.Rooms.Leave(arg.RoomId, arg.UserId, arg.Server)
 	return nil
 }
 
+// RoomMembers get the room members of the sessions.
+func (r *RouterRPC) RoomMembers(arg *proto.NoArg, reply *proto.RoomMembersReply) error {
+	var bucket *Bucket
+	for _, bucket = range r.Buckets {
+		reply.Members = append(reply.Members, bucket.RoomMembers()...)
+	}
+	return nil
+}
+
+// SyncRooms reset the members of the owned rooms.
+func (r *RouterRPC) SyncRooms(arg *proto.RoomSyncArg, reply *proto.NoReply) error {
+	r.Rooms.Reset(arg.Members)
+	return nil
+}
+
+func (r *RouterRPC) Room(arg *proto.RoomArg, reply *proto.RoomReply) error {
+	reply.Meta, reply.Members = r.Rooms.Get(arg.RoomId)
+	return nil
+}
+
+func (r *RouterRPC) RoomUsers(arg *proto.RoomArg, reply *proto.RoomUsersReply) error {
+	var bucket *Bucket
+	for _, bucket = range r.Buckets {
+		reply.UserIds = append(reply.UserIds, bucket.RoomUsers(arg.RoomId)...)
+	}
+	return nil
+}
+
+func (r *RouterRPC) Stat(arg *proto.NoArg, reply *proto.RouterStatReply) error {
+	var (
+		bucket *Bucket
+		roomId string
+		count  int32
+		rooms  = make(map[string]struct{})
+	)
+	for _, bucket = range r.Buckets {
+		for _, count = range bucket.AllServerCount() {
+			reply.Sessions += count
+		}
+		for roomId = range bucket.AllRoomCount() {
+			if roomId != define.NoRoom {
+				rooms[roomId] = struct{}{}
+			}
+		}
+		reply.Users += bucket.UserTotal()
+	}
+	reply.Rooms = len(rooms)
+	return nil
+}
+
+func (r *RouterRPC) Put(arg *proto.PutArg, reply *proto.PutReply) (err error) {
+	// the session policy is evaluated in the bucket lock
+	if reply.Seq, reply.Kicks, err = r.bucket(arg.UserId).Put(arg.UserId, arg.Server, arg.RoomId, arg.Platform); err == nil {
+		sessionPut.Inc()
+		sessionKick.Add(float64(len(reply.Kicks)))
+	} else if err == ErrSessionLimit {
+		sessionRefuse.Inc()
+	}
+	return
+}
+
 func (r *RouterRPC) Del(arg *proto.DelArg, reply *proto.DelReply) error {
 	reply.Has = r.bucket(arg.UserId).Del(arg.UserId, arg.Seq, arg.RoomId)
+	sessionDel.Inc()
 	return nil
 }
 
@@ -76,6 +170,7 @@ func (r *RouterRPC) DelServer(arg *proto.DelServerArg, reply *proto.NoReply) err
 	for _, bucket = range r.Buckets {
 		bucket.DelServer(arg.Server)
 	}
+	r.Rooms.DelServer(arg.Server)
 	return nil
 }
 
@@ -122,6 +217,37 @@ func (r *RouterRPC) MGet(arg *proto.MGetArg, reply *proto.MGetReply) error {
 	return nil
 }
 
+// MPush allocate the message seqs of the online users and keep the message
+// in their outboxes, the sessions are returned as MGet. the users without
+// sessions get no seq, 0.
+func (r *RouterRPC) MPush(arg *proto.MPushArg, reply *proto.MPushReply) error {
+	var (
+		i       int
+		userId  int64
+		session *proto.GetReply
+	)
+	reply.Sessions = make([]*proto.GetReply, len(arg.UserIds))
+	reply.UserIds = make([]int64, len(arg.UserIds))
+	reply.MsgSeqs = make([]int32, len(arg.UserIds))
+	for i = 0; i < len(arg.UserIds); i++ {
+		userId = arg.UserIds[i]
+		session = new(proto.GetReply)
+		session.Seqs, session.Servers = r.bucket(userId).Get(userId)
+		reply.UserIds[i] = userId
+		reply.Sessions[i] = session
+		if len(session.Seqs) > 0 {
+			reply.MsgSeqs[i] = r.outbox(userId).Push(userId, arg.Msg, arg.Expire)
+		}
+	}
+	return nil
+}
+
+// Outbox get the kept messages of the user after the seq.
+func (r *RouterRPC) Outbox(arg *proto.OutboxArg, reply *proto.OutboxReply) error {
+	reply.Msgs = r.outbox(arg.UserId).Get(arg.UserId, arg.Since)
+	return nil
+}
+
 func (r *RouterRPC) Count(arg *proto.NoArg, reply *proto.CountReply) error {
 	var (
 		bucket *Bucket
@@ -144,10 +270,11 @@ func (r *RouterRPC) RoomCount(arg *proto.RoomCountArg, reply *proto.RoomCountRep
 
 func (r *RouterRPC) AllRoomCount(arg *proto.NoArg, reply *proto.AllRoomCountReply) error {
 	var (
-		bucket        *Bucket
-		roomId, count int32
+		bucket *Bucket
+		roomId string
+		count  int32
 	)
-	reply.Counter = make(map[int32]int32)
+	reply.Counter = make(map[string]int32)
 	for _, bucket = range r.Buckets {
 		for roomId, count = range bucket.AllRoomCount() {
 			reply.Counter[roomId] += count
@@ -1,16 +1,22 @@
 package main
 
+import (
+	"goim/libs/define"
+)
+
 type Session struct {
-	seq     int32
-	servers map[int32]int32           // seq:server
-	rooms   map[int32]map[int32]int32 // roomid:seq:server with specified room id
+	seq       int32
+	servers   map[int32]int32            // seq:server
+	platforms map[int32]string           // seq:platform, only the known platforms
+	rooms     map[string]map[int32]int32 // roomid:seq:server with specified room id
 }
 
 // NewSession new a session struct. store the seq and serverid.
 func NewSession(server int) *Session {
 	s := new(Session)
 	s.servers = make(map[int32]int32, server)
-	s.rooms = make(map[int32]map[int32]int32)
+	s.platforms = make(map[int32]string)
+	s.rooms = make(map[string]map[int32]int32)
 	s.seq = 0
 	return s
 }
@@ -21,19 +27,22 @@ func (s *Session) nextSeq() int32 {
 }
 
 // Put put a session according with sub key.
-func (s *Session) Put(server int32) (seq int32) {
+func (s *Session) Put(server int32, platform string) (seq int32) {
 	seq = s.nextSeq()
 	s.servers[seq] = server
+	if platform != "" {
+		s.platforms[seq] = platform
+	}
 	return
 }
 
 // PutRoom put a session in a room according with subkey.
-func (s *Session) PutRoom(server int32, roomId int32) (seq int32) {
+func (s *Session) PutRoom(server int32, roomId string, platform string) (seq int32) {
 	var (
 		ok   bool
 		room map[int32]int32
 	)
-	seq = s.Put(server)
+	seq = s.Put(server, platform)
 	if room, ok = s.rooms[roomId]; !ok {
 		room = make(map[int32]int32)
 		s.rooms[roomId] = room
@@ -61,13 +70,14 @@ func (s *Session) Servers() (seqs []int32, servers []int32) {
 func (s *Session) Del(seq int32) (has, empty bool, server int32) {
 	if server, has = s.servers[seq]; has {
 		delete(s.servers, seq)
+		delete(s.platforms, seq)
 	}
 	empty = (len(s.servers) == 0)
 	return
 }
 
 // DelRoom delete the session and room by subkey.
-func (s *Session) DelRoom(seq int32, roomId int32) (has, empty bool, server int32) {
+func (s *Session) DelRoom(seq int32, roomId string) (has, empty bool, server int32) {
 	var (
 		ok   bool
 		room map[int32]int32
@@ -82,6 +92,16 @@ func (s *Session) DelRoom(seq int32, roomId int32) (has, empty bool, server int3
 	return
 }
 
+// Room get the room id of the session.
+func (s *Session) Room(seq int32) string {
+	for roomId, room := range s.rooms {
+		if _, ok := room[seq]; ok {
+			return roomId
+		}
+	}
+	return define.NoRoom
+}
+
 func (s *Session) Count() int {
 	return len(s.servers)
 }